@@ -17,43 +17,97 @@ limitations under the License.
 package app
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/go-co-op/gocron/v2"
 	"github.com/masteryyh/micro-ddns/internal/config"
 	"github.com/masteryyh/micro-ddns/internal/ddns"
+	"github.com/masteryyh/micro-ddns/internal/history"
 	"github.com/masteryyh/micro-ddns/internal/metrics"
 	"github.com/masteryyh/micro-ddns/internal/signal"
+	"github.com/masteryyh/micro-ddns/internal/state"
 )
 
 type App struct {
+	configFile string
 	manager    *ddns.DDNSInstanceManager
+	watcher    *config.Watcher
 	logger     *slog.Logger
 	metrics    *metrics.MetricsServer
+	history    *history.Recorder
 	shutdownWg *sync.WaitGroup
 }
 
-func initLogger(level int) (*slog.Logger, error) {
+func initLogger(level int, w io.Writer) (*slog.Logger, error) {
 	if level != -4 && level != 0 && level != 4 && level != 8 {
 		return nil, fmt.Errorf("invalid log level: %d", level)
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{
 		Level: slog.Level(level),
 	})
 	logger := slog.New(handler)
 	return logger, nil
 }
 
-func NewApp(logLevel int, configFile string) (*App, error) {
-	logger, err := initLogger(logLevel)
+// Options are the command line settings of the application
+type Options struct {
+	LogLevel   int
+	ConfigFile string
+
+	// WatchInterval is how often the config file is checked for changes,
+	// use 0 to disable watching and rely on SIGHUP only
+	WatchInterval time.Duration
+
+	// StateFile is where record state is persisted, leave it empty to keep it in memory
+	StateFile string
+
+	// RunOnStart updates every instance right after startup, unless its spec says otherwise
+	RunOnStart bool
+
+	// RunOnStartStagger is the delay between the startup updates of two instances
+	RunOnStartStagger time.Duration
+
+	// DryRun reports the changes every instance would make instead of making them
+	DryRun bool
+
+	// ShutdownTimeout bounds the deletion of records on shutdown
+	ShutdownTimeout time.Duration
+
+	// HistorySize is the number of history entries kept in memory per instance
+	HistorySize int
+
+	// AuditLog is where every history entry is appended as JSON lines, leave it empty to disable
+	AuditLog string
+}
+
+// openHistory opens the history recorder of options
+func openHistory(options Options, logger *slog.Logger) (*history.Recorder, error) {
+	if options.AuditLog != "" {
+		logger.Info("appending update history to " + options.AuditLog)
+	}
+	return history.Open(options.HistorySize, options.AuditLog)
+}
+
+func NewApp(options Options) (*App, error) {
+	logger, err := initLogger(options.LogLevel, os.Stdout)
 	if err != nil {
 		return nil, err
 	}
 
+	configFile := options.ConfigFile
+	stateFile := options.StateFile
+
+	if options.DryRun {
+		logger.Warn("running in dry run mode, no DNS record will be changed")
+	}
+
 	logger.Info("reading config file from " + configFile)
 	configs, err := config.ReadConfigOrGet(configFile)
 	if err != nil {
@@ -65,22 +119,97 @@ func NewApp(logLevel int, configFile string) (*App, error) {
 		return nil, err
 	}
 
+	if stateFile != "" {
+		logger.Info("loading record state from " + stateFile)
+	}
+	store, err := state.Open(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := openHistory(options, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	var wg sync.WaitGroup
 
-	manager, err := ddns.NewDDNSInstanceManager(configs.DDNS, scheduler, logger, &wg)
+	managerOptions := ddns.ManagerOptions{
+		RunOnStart:        options.RunOnStart,
+		RunOnStartStagger: options.RunOnStartStagger,
+		DryRun:            options.DryRun,
+		ShutdownTimeout:   options.ShutdownTimeout,
+	}
+	shared := ddns.NewShared(store, recorder, logger)
+	manager, err := ddns.NewDDNSInstanceManager(configs.DDNS, scheduler, shared, managerOptions, logger, &wg)
 	if err != nil {
 		return nil, err
 	}
 
+	var watcher *config.Watcher
+	if options.WatchInterval > 0 {
+		watcherLogger := logger.With(slog.Group("component", "type", "watcher"))
+		watcher, err = config.NewWatcher(configFile, options.WatchInterval, watcherLogger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	metricsLogger := logger.With(slog.Group("component", "type", "metrics"))
+	metricsServer := metrics.NewMetricsServer(metricsLogger, &wg)
+	metricsServer.HandleStatus(func() (interface{}, []string) {
+		status := manager.Status()
+		return status, status.Problems()
+	})
+	metricsServer.HandleJSON("/history", recorder.Lookup)
+
 	return &App{
+		configFile: configFile,
 		logger:     logger,
 		manager:    manager,
-		metrics:    metrics.NewMetricsServer(metricsLogger, &wg),
+		watcher:    watcher,
+		metrics:    metricsServer,
+		history:    recorder,
 		shutdownWg: &wg,
 	}, nil
 }
 
+func (a *App) reloadConfig(reason string) {
+	a.logger.Info("reloading config file from "+a.configFile, "reason", reason)
+	configs, err := config.ReadConfigOrGet(a.configFile)
+	if err != nil {
+		a.logger.Error("failed to load new config, keeping current config", "err", err)
+		return
+	}
+
+	if err := a.manager.Reload(configs.DDNS); err != nil {
+		a.logger.Error("failed to apply new config", "err", err)
+		return
+	}
+	a.logger.Info("config reloaded")
+}
+
+func (a *App) watchConfig(ctx context.Context) {
+	reloadSignal := signal.SetupReloadChannel()
+
+	var changes <-chan struct{}
+	if a.watcher != nil {
+		go a.watcher.Watch(ctx)
+		changes = a.watcher.Changes()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			a.reloadConfig("config file changed")
+		case sig := <-reloadSignal:
+			a.reloadConfig("received " + sig.String())
+		}
+	}
+}
+
 func (a *App) Run() {
 	a.logger.Info("starting app")
 
@@ -89,8 +218,12 @@ func (a *App) Run() {
 
 	go a.metrics.Serve(ctx)
 	go a.manager.Start(ctx)
+	go a.watchConfig(ctx)
 
 	<-ctx.Done()
 	a.logger.Info("shutting down")
 	a.shutdownWg.Wait()
+	if err := a.history.Close(); err != nil {
+		a.logger.Error("failed to close audit log", "err", err)
+	}
 }
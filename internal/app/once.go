@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/ddns"
+	"github.com/masteryyh/micro-ddns/internal/state"
+)
+
+// selectSpecs returns the specs named in names in config order, or every spec if names is empty
+func selectSpecs(specs []*config.DDNSSpec, names []string) ([]*config.DDNSSpec, error) {
+	if len(names) == 0 {
+		return specs, nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []*config.DDNSSpec
+	for _, spec := range specs {
+		if wanted[spec.Name] {
+			selected = append(selected, spec)
+			delete(wanted, spec.Name)
+		}
+	}
+
+	for _, name := range names {
+		if wanted[name] {
+			return nil, fmt.Errorf("DDNS spec %s not found", name)
+		}
+	}
+	return selected, nil
+}
+
+// RunOnce reconciles every DDNS spec, or only those listed in names, concurrently
+// and returns the result of every record. Logs are written to stderr so results
+// can be printed to stdout, an instance that cannot be created is reported as
+// a result without record.
+func RunOnce(ctx context.Context, options Options, names []string) ([]*ddns.RecordResult, error) {
+	logger, err := initLogger(options.LogLevel, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("reading config file from " + options.ConfigFile)
+	configs, err := config.ReadConfigOrGet(options.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	specs, err := selectSpecs(configs.DDNS, names)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DryRun {
+		logger.Warn("running in dry run mode, no DNS record will be changed")
+	}
+
+	store, err := state.Open(options.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := openHistory(options, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer recorder.Close()
+
+	shared := ddns.NewShared(store, recorder, logger)
+	results := make([][]*ddns.RecordResult, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func(i int, spec *config.DDNSSpec) {
+			defer wg.Done()
+
+			instanceLogger := logger.With(slog.Group("component", "type", "instance", "name", spec.Name))
+			instance, err := ddns.NewDDNSInstance(spec, shared, options.DryRun, instanceLogger)
+			if err != nil {
+				results[i] = []*ddns.RecordResult{{Name: spec.Name, DryRun: options.DryRun, Err: err}}
+				return
+			}
+
+			results[i], _ = instance.Reconcile(ctx)
+		}(i, spec)
+	}
+	wg.Wait()
+
+	var all []*ddns.RecordResult
+	for _, instanceResults := range results {
+		all = append(all, instanceResults...)
+	}
+	return all, nil
+}
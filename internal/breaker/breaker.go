@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package breaker
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// ErrOpen is returned for runs skipped because the circuit is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State string
+
+const (
+	// Closed lets every run through
+	Closed State = "closed"
+
+	// Open skips runs until the cool-down is over
+	Open State = "open"
+
+	// HalfOpen lets a single run through as a probe, its outcome closes the
+	// circuit or opens it again with a longer cool-down
+	HalfOpen State = "halfOpen"
+)
+
+// Status is a snapshot of a Breaker
+type Status struct {
+	State               State      `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	CoolDown            string     `json:"coolDown,omitempty"`
+	OpenUntil           *time.Time `json:"openUntil,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+// Breaker stops runs of an instance failing on every run, so a revoked
+// credential does not hit the provider on every scheduled run forever
+type Breaker struct {
+	threshold       int
+	initialCoolDown time.Duration
+	maxCoolDown     time.Duration
+
+	failures  int
+	coolDown  time.Duration
+	openUntil time.Time
+	probing   bool
+	lastError string
+	lock      sync.Mutex
+}
+
+// New creates a Breaker from a validated CircuitBreakerSpec
+func New(spec *config.CircuitBreakerSpec) *Breaker {
+	if spec == nil {
+		spec = config.NewDefaultCircuitBreakerSpec()
+	}
+	return &Breaker{
+		threshold:       *spec.FailureThreshold,
+		initialCoolDown: spec.CoolDown.Duration(),
+		maxCoolDown:     spec.MaxCoolDown.Duration(),
+	}
+}
+
+func (b *Breaker) state(now time.Time) State {
+	switch {
+	case b.threshold == 0 || b.failures < b.threshold:
+		return Closed
+	case !b.probing && now.Before(b.openUntil):
+		return Open
+	}
+	return HalfOpen
+}
+
+// Allow reports if a run can start at now, probe is true for the single run
+// let through once the cool-down is over. Every allowed run must be followed
+// by Done.
+func (b *Breaker) Allow(now time.Time) (probe bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state(now) {
+	case Closed:
+		return false, nil
+	case Open:
+		return false, ErrOpen
+	}
+
+	if b.probing {
+		return false, ErrOpen
+	}
+	b.probing = true
+	return true, nil
+}
+
+// Done records the outcome of a run and returns the resulting status, the
+// circuit opens once the failures reach the threshold
+func (b *Breaker) Done(err error, now time.Time) Status {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.coolDown = 0
+		b.lastError = ""
+		return b.status(now)
+	}
+
+	b.failures++
+	b.lastError = strings.TrimSpace(err.Error())
+	if b.threshold == 0 || b.failures < b.threshold {
+		return b.status(now)
+	}
+
+	if b.coolDown == 0 {
+		b.coolDown = b.initialCoolDown
+	} else {
+		b.coolDown = min(2*b.coolDown, b.maxCoolDown)
+	}
+	b.openUntil = now.Add(b.coolDown)
+	return b.status(now)
+}
+
+// Cancel ends an allowed run canceled before its outcome was known, it is
+// neither a failure nor a success so a probe is simply let through again
+func (b *Breaker) Cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.probing = false
+}
+
+// Status returns the current status of the breaker
+func (b *Breaker) Status() Status {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.status(time.Now())
+}
+
+func (b *Breaker) status(now time.Time) Status {
+	status := Status{
+		State:               b.state(now),
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if status.State != Closed {
+		openUntil := b.openUntil
+		status.OpenUntil = &openUntil
+		status.CoolDown = b.coolDown.String()
+	}
+	return status
+}
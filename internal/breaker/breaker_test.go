@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// step is an operation on a breaker at an offset from the start of a test
+type step struct {
+	at time.Duration
+	op string
+
+	wantProbe    bool
+	wantErr      error
+	wantState    State
+	wantFailures int
+	wantCoolDown string
+}
+
+func newBreaker(threshold int, coolDown time.Duration, maxCoolDown time.Duration) *Breaker {
+	initial := config.Duration(coolDown)
+	limit := config.Duration(maxCoolDown)
+	return New(&config.CircuitBreakerSpec{
+		FailureThreshold: &threshold,
+		CoolDown:         &initial,
+		MaxCoolDown:      &limit,
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		breaker *Breaker
+		steps   []step
+	}{
+		{
+			name:    "success keeps the circuit closed",
+			breaker: newBreaker(2, time.Minute, 3*time.Minute),
+			steps: []step{
+				{op: "allow", wantState: Closed},
+				{op: "fail", wantState: Closed, wantFailures: 1},
+				{op: "allow", wantState: Closed, wantFailures: 1},
+				{op: "succeed", wantState: Closed},
+			},
+		},
+		{
+			name:    "failures open the circuit with a growing cool-down",
+			breaker: newBreaker(2, time.Minute, 3*time.Minute),
+			steps: []step{
+				{op: "allow", wantState: Closed},
+				{op: "fail", wantState: Closed, wantFailures: 1},
+				{op: "allow", wantState: Closed, wantFailures: 1},
+				{op: "fail", wantState: Open, wantFailures: 2, wantCoolDown: "1m0s"},
+				{at: 30 * time.Second, op: "allow", wantErr: ErrOpen, wantState: Open, wantFailures: 2, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 2, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "allow", wantErr: ErrOpen, wantState: HalfOpen, wantFailures: 2, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "fail", wantState: Open, wantFailures: 3, wantCoolDown: "2m0s"},
+				{at: 3 * time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 3, wantCoolDown: "2m0s"},
+				{at: 3 * time.Minute, op: "fail", wantState: Open, wantFailures: 4, wantCoolDown: "3m0s"},
+				{at: 6 * time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 4, wantCoolDown: "3m0s"},
+				{at: 6 * time.Minute, op: "fail", wantState: Open, wantFailures: 5, wantCoolDown: "3m0s"},
+			},
+		},
+		{
+			name:    "successful probe closes the circuit",
+			breaker: newBreaker(1, time.Minute, time.Hour),
+			steps: []step{
+				{op: "allow", wantState: Closed},
+				{op: "fail", wantState: Open, wantFailures: 1, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 1, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "succeed", wantState: Closed},
+				{at: time.Minute, op: "allow", wantState: Closed},
+				{at: time.Minute, op: "fail", wantState: Open, wantFailures: 1, wantCoolDown: "1m0s"},
+			},
+		},
+		{
+			name:    "canceled probe lets the next run probe again",
+			breaker: newBreaker(1, time.Minute, time.Hour),
+			steps: []step{
+				{op: "allow", wantState: Closed},
+				{op: "fail", wantState: Open, wantFailures: 1, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 1, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "cancel", wantState: HalfOpen, wantFailures: 1, wantCoolDown: "1m0s"},
+				{at: time.Minute, op: "allow", wantProbe: true, wantState: HalfOpen, wantFailures: 1, wantCoolDown: "1m0s"},
+			},
+		},
+		{
+			name:    "canceled run is not a failure",
+			breaker: newBreaker(1, time.Minute, time.Hour),
+			steps: []step{
+				{op: "allow", wantState: Closed},
+				{op: "cancel", wantState: Closed},
+				{op: "allow", wantState: Closed},
+			},
+		},
+		{
+			name:    "zero threshold disables the circuit breaker",
+			breaker: newBreaker(0, time.Minute, time.Hour),
+			steps: []step{
+				{op: "fail", wantState: Closed, wantFailures: 1},
+				{op: "fail", wantState: Closed, wantFailures: 2},
+				{op: "allow", wantState: Closed, wantFailures: 2},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.breaker
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				var status Status
+				switch s.op {
+				case "allow":
+					probe, err := b.Allow(now)
+					if probe != s.wantProbe {
+						t.Errorf("step %d: expected probe %v, got %v", i, s.wantProbe, probe)
+					}
+					if !errors.Is(err, s.wantErr) {
+						t.Errorf("step %d: expected error %v, got %v", i, s.wantErr, err)
+					}
+					status = b.status(now)
+				case "fail":
+					status = b.Done(errors.New("provider unavailable\n"), now)
+				case "succeed":
+					status = b.Done(nil, now)
+				case "cancel":
+					b.Cancel()
+					status = b.status(now)
+				default:
+					t.Fatalf("step %d: unknown operation %s", i, s.op)
+				}
+
+				if status.State != s.wantState {
+					t.Errorf("step %d: expected state %s, got %s", i, s.wantState, status.State)
+				}
+				if status.ConsecutiveFailures != s.wantFailures {
+					t.Errorf("step %d: expected %d failures, got %d", i, s.wantFailures, status.ConsecutiveFailures)
+				}
+				if status.CoolDown != s.wantCoolDown {
+					t.Errorf("step %d: expected cool-down %q, got %q", i, s.wantCoolDown, status.CoolDown)
+				}
+				if s.wantFailures > 0 && status.LastError != "provider unavailable" {
+					t.Errorf("step %d: unexpected last error %q", i, status.LastError)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// configCmd represents the config command
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Tools for working with micro-ddns config files.",
+	}
+
+	// configValidateCmd represents the config validate command
+	configValidateCmd = &cobra.Command{
+		Use:          "validate",
+		Short:        "Validate a config file and report every problem found.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configFile == "" {
+				return fmt.Errorf("no config file specified")
+			}
+
+			_, err := config.ValidateFile(configFile)
+			if err == nil {
+				fmt.Fprintln(cmd.OutOrStdout(), configFile+" is valid")
+				return nil
+			}
+
+			var errs config.FieldErrors
+			if !errors.As(err, &errs) {
+				return err
+			}
+
+			for _, fieldErr := range errs {
+				fmt.Fprintln(cmd.OutOrStdout(), fieldErr.Error())
+			}
+			return fmt.Errorf("found %d problem(s) in %s", len(errs), configFile)
+		},
+	}
+
+	// configSchemaCmd represents the config schema command
+	configSchemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the config file format.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, err := config.Schema()
+			if err != nil {
+				return err
+			}
+
+			bytes, err := json.MarshalIndent(schema, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(bytes))
+			return nil
+		},
+	}
+)
+
+func init() {
+	configValidateCmd.Flags().StringVarP(&configFile, "config", "c", "/etc/micro-ddns/config.yaml", "config file or directory location")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
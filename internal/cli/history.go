@@ -0,0 +1,164 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/history"
+	"github.com/spf13/cobra"
+)
+
+// parseSince reads a point in time given either as a duration before now or
+// as an RFC 3339 timestamp
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be a duration like 24h or an RFC 3339 timestamp")
+	}
+	return t, nil
+}
+
+// fetchHistory gets the history entries selected by q from the server listening at address
+func fetchHistory(address string, q history.Query) ([]history.Entry, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(strings.TrimSuffix(address, "/") + "/history?" + q.Values().Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("server responded with status %s: %s", res.Status, strings.TrimSpace(string(detail)))
+	}
+
+	var entries []history.Entry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid history document: %w", err)
+	}
+	return entries, nil
+}
+
+func printHistoryTable(w io.Writer, entries []history.Entry) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tNAME\tRECORD\tTYPE\tACTION\tADDRESS\tDURATION\tERROR")
+	for _, e := range entries {
+		action := e.Action
+		if e.DryRun && action != "none" && action != history.ActionError {
+			action += " (dry run)"
+		}
+
+		address := e.Address
+		if address == "" {
+			address = "-"
+		} else if e.PreviousAddress != "" && e.PreviousAddress != e.Address {
+			address = e.PreviousAddress + " -> " + e.Address
+		}
+
+		errText := "-"
+		if e.Error != "" {
+			errText = strings.TrimSpace(e.Error)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Name, e.Record,
+			e.Type, action, address, e.Duration.Duration().Round(time.Millisecond), errText)
+	}
+	return table.Flush()
+}
+
+var (
+	historyAddress string
+	historyName    string
+	historySince   string
+	historyLimit   int
+	historyOutput  string
+
+	// historyCmd represents the history command
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "Show the update history of a running server or an audit log.",
+		Long: `Show what recent runs did to every record, either from the history kept in
+memory by a running server or, with --audit-log, from an audit log file.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if historyOutput != "table" && historyOutput != "json" {
+				return fmt.Errorf("unknown output format %s, must be table or json", historyOutput)
+			}
+			if historyLimit < 0 {
+				return fmt.Errorf("limit cannot be negative")
+			}
+
+			since, err := parseSince(historySince)
+			if err != nil {
+				return err
+			}
+			q := history.Query{
+				Name:  historyName,
+				Since: since,
+				Limit: historyLimit,
+			}
+
+			var entries []history.Entry
+			if auditLog != "" {
+				all, err := history.ReadAuditLog(auditLog)
+				if err != nil {
+					return err
+				}
+				entries = q.Filter(all)
+			} else {
+				entries, err = fetchHistory(historyAddress, q)
+				if err != nil {
+					return err
+				}
+			}
+
+			if historyOutput == "json" {
+				bytes, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(bytes))
+				return nil
+			}
+			return printHistoryTable(cmd.OutOrStdout(), entries)
+		},
+	}
+)
+
+func init() {
+	historyCmd.Flags().StringVar(&historyAddress, "address", "http://127.0.0.1:8080", "address of the metrics server of the running micro-ddns")
+	historyCmd.Flags().StringVar(&auditLog, "audit-log", "", "read entries from this audit log file instead of a running server")
+	historyCmd.Flags().StringVar(&historyName, "name", "", "only show entries of the DDNS instance with this name")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show entries since a duration ago like 24h, or an RFC 3339 timestamp")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "only show the latest entries, 0 shows every entry")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", "table", "output format, table or json")
+	rootCmd.AddCommand(historyCmd)
+}
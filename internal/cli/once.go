@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/masteryyh/micro-ddns/internal/app"
+	"github.com/masteryyh/micro-ddns/internal/ddns"
+	"github.com/masteryyh/micro-ddns/internal/signal"
+	"github.com/spf13/cobra"
+)
+
+// onceResult is a RecordResult as printed by the once command
+type onceResult struct {
+	Name            string `json:"name"`
+	Record          string `json:"record,omitempty"`
+	Type            string `json:"type,omitempty"`
+	Action          string `json:"action,omitempty"`
+	Address         string `json:"address,omitempty"`
+	PreviousAddress string `json:"previousAddress,omitempty"`
+	DryRun          bool   `json:"dryRun"`
+	Error           string `json:"error,omitempty"`
+}
+
+func newOnceResult(result *ddns.RecordResult) onceResult {
+	r := onceResult{
+		Name:            result.Name,
+		Record:          result.Record,
+		Type:            string(result.Type),
+		Action:          string(result.Action),
+		Address:         result.Address,
+		PreviousAddress: result.PreviousAddress,
+		DryRun:          result.DryRun,
+	}
+	if result.Err != nil {
+		r.Error = result.Err.Error()
+	}
+	return r
+}
+
+func printJSON(w io.Writer, results []onceResult) error {
+	bytes, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bytes))
+	return err
+}
+
+func printTable(w io.Writer, results []onceResult) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tRECORD\tTYPE\tACTION\tADDRESS\tSTATUS")
+	for _, r := range results {
+		action := r.Action
+		if action == "" {
+			action = "-"
+		}
+		if r.DryRun && action != string(ddns.ActionNone) && action != "-" {
+			action += " (dry run)"
+		}
+
+		address := r.Address
+		if address == "" {
+			address = "-"
+		} else if r.PreviousAddress != "" && r.PreviousAddress != r.Address {
+			address = r.PreviousAddress + " -> " + r.Address
+		}
+
+		status := "ok"
+		if r.Error != "" {
+			status = "failed: " + r.Error
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Record, r.Type, action, address, status)
+	}
+	return table.Flush()
+}
+
+// runOnce updates the selected instances once and prints their results to w,
+// an error is returned when any record failed
+func runOnce(ctx context.Context, w io.Writer) error {
+	results, err := app.RunOnce(ctx, app.Options{
+		LogLevel:   logLevel,
+		ConfigFile: configFile,
+		StateFile:  stateFile,
+		DryRun:     dryRun,
+		AuditLog:   auditLog,
+	}, onceNames)
+	if err != nil {
+		return err
+	}
+
+	printed := make([]onceResult, 0, len(results))
+	failed := 0
+	for _, result := range results {
+		printed = append(printed, newOnceResult(result))
+		if result.Err != nil {
+			failed++
+		}
+	}
+
+	if onceOutput == "json" {
+		err = printJSON(w, printed)
+	} else {
+		err = printTable(w, printed)
+	}
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d record(s) failed", failed, len(results))
+	}
+	return nil
+}
+
+var (
+	onceNames  []string
+	onceOutput string
+
+	// onceCmd represents the once command
+	onceCmd = &cobra.Command{
+		Use:   "once",
+		Short: "Update every DDNS instance once and exit.",
+		Long: `Update every DDNS instance, or those selected by --name, once and exit.
+Exits with a non-zero code when any record failed, so it can be driven by
+systemd timers, hotplug scripts or Kubernetes CronJobs.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configFile == "" {
+				return fmt.Errorf("no config file specified")
+			}
+			if onceOutput != "table" && onceOutput != "json" {
+				return fmt.Errorf("unknown output format %s, must be table or json", onceOutput)
+			}
+
+			ctx, cancel := signal.SetupContext()
+			defer cancel()
+
+			return runOnce(ctx, cmd.OutOrStdout())
+		},
+	}
+)
+
+func init() {
+	onceCmd.Flags().StringVarP(&configFile, "config", "c", "/etc/micro-ddns/config.yaml", "config file or directory location")
+	onceCmd.Flags().StringSliceVar(&onceNames, "name", nil, "only update the DDNS instances with these names, can be repeated")
+	onceCmd.Flags().StringVarP(&onceOutput, "output", "o", "table", "output format, table or json")
+	onceCmd.Flags().StringVar(&stateFile, "state-file", "", "file to persist last known addresses and record IDs across runs, empty keeps them in memory only")
+	onceCmd.Flags().StringVar(&auditLog, "audit-log", "", "file the result of every record is appended to as a JSON line, empty disables the audit log")
+	rootCmd.AddCommand(onceCmd)
+}
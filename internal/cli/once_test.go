@@ -0,0 +1,146 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newTestDNSServer starts an RFC 2136 server without records, updates of
+// names starting with "broken." are refused
+func newTestDNSServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(r)
+		for _, rr := range r.Ns {
+			if strings.HasPrefix(rr.Header().Name, "broken.") {
+				reply.Rcode = dns.RcodeRefused
+			}
+		}
+		w.WriteMsg(reply)
+	})}
+	// Updates are answered with NOTIMP by default
+	server.MsgAcceptFunc = func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() {
+		server.Shutdown()
+	})
+	return conn.LocalAddr().String()
+}
+
+func TestOnceExitCode(t *testing.T) {
+	detection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "203.0.113.1")
+	}))
+	t.Cleanup(detection.Close)
+
+	host, port, err := net.SplitHostPort(newTestDNSServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := fmt.Sprintf(`ddns:
+  - name: home
+    domain: example.com
+    subdomains: [home, broken]
+    stack: IPv4
+    interval: 1h
+    retry: {maxAttempts: 1}
+    detection: {api: {url: %q}}
+    provider: {rfc2136: {address: %s, port: %s}}
+`, detection.URL, host, port)
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		dryRun     bool
+		wantErr    string
+		wantFailed []string
+	}{
+		{
+			name:       "some records failed",
+			wantErr:    "1 of 2 record(s) failed",
+			wantFailed: []string{"broken.example.com"},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile, stateFile, auditLog, onceNames = path, "", "", nil
+			onceOutput, dryRun, logLevel = "json", tt.dryRun, 8
+
+			// The command exits with 1 when runOnce returns an error
+			var out bytes.Buffer
+			err := runOnce(context.Background(), &out)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected success, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("expected error %q, got %v\n%s", tt.wantErr, err, out.String())
+			}
+
+			// Results are printed whether records failed or not
+			var results []onceResult
+			if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+				t.Fatalf("output is not a JSON list of results: %v\n%s", err, out.String())
+			}
+			if len(results) != 2 {
+				t.Fatalf("expected 2 results, got %v", results)
+			}
+			var failed []string
+			for _, result := range results {
+				if result.Error != "" {
+					failed = append(failed, result.Record)
+				}
+				if result.DryRun != tt.dryRun {
+					t.Errorf("%s: expected dry run %v", result.Record, tt.dryRun)
+				}
+			}
+			if strings.Join(failed, ",") != strings.Join(tt.wantFailed, ",") {
+				t.Errorf("expected failed records %v, got %v", tt.wantFailed, failed)
+			}
+		})
+	}
+}
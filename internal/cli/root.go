@@ -24,6 +24,7 @@ import (
 
 var (
 	logLevel int
+	dryRun   bool
 )
 
 var (
@@ -50,4 +51,5 @@ func Execute() {
 func init() {
 	rootCmd.PersistentFlags().IntVarP(&logLevel, "verbose", "v",
 		0, "log level, available options are -4 (DEBUG), 0 (INFO), 4 (WARN) and 8 (ERROR)")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "detect addresses and query DNS providers, but only report the changes that would be made")
 }
@@ -18,14 +18,26 @@ package cli
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/masteryyh/micro-ddns/internal/app"
+	"github.com/masteryyh/micro-ddns/internal/history"
 	"github.com/spf13/cobra"
 )
 
 // runCmd represents the run command
 var (
-	configFile string
+	configFile    string
+	watchInterval time.Duration
+	stateFile     string
+
+	runOnStart        bool
+	runOnStartStagger time.Duration
+
+	shutdownTimeout time.Duration
+
+	historySize int
+	auditLog    string
 
 	runCmd = &cobra.Command{
 		Use:   "run",
@@ -35,7 +47,18 @@ var (
 				return fmt.Errorf("no config file specified")
 			}
 
-			a, err := app.NewApp(logLevel, configFile)
+			a, err := app.NewApp(app.Options{
+				LogLevel:          logLevel,
+				ConfigFile:        configFile,
+				WatchInterval:     watchInterval,
+				StateFile:         stateFile,
+				RunOnStart:        runOnStart,
+				RunOnStartStagger: runOnStartStagger,
+				DryRun:            dryRun,
+				ShutdownTimeout:   shutdownTimeout,
+				HistorySize:       historySize,
+				AuditLog:          auditLog,
+			})
 			if err != nil {
 				return err
 			}
@@ -46,6 +69,13 @@ var (
 )
 
 func init() {
-	runCmd.Flags().StringVarP(&configFile, "config", "c", "/etc/micro-ddns/config.yaml", "config file location")
+	runCmd.Flags().StringVarP(&configFile, "config", "c", "/etc/micro-ddns/config.yaml", "config file or directory location")
+	runCmd.Flags().DurationVar(&watchInterval, "watch-interval", 10*time.Second, "how often the config file is checked for changes, 0 disables watching (SIGHUP still reloads)")
+	runCmd.Flags().StringVar(&stateFile, "state-file", "", "file to persist last known addresses and record IDs across restarts, empty keeps them in memory only")
+	runCmd.Flags().BoolVar(&runOnStart, "run-on-start", false, "update every DDNS instance right after startup instead of waiting for its first cron tick, runOnStart in a spec overrides this")
+	runCmd.Flags().DurationVar(&runOnStartStagger, "run-on-start-stagger", 2*time.Second, "delay between the startup updates of two instances")
+	runCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for records of specs with deleteOnShutdown to be deleted when shutting down")
+	runCmd.Flags().IntVar(&historySize, "history-size", history.DefaultSize, "number of update history entries kept in memory for every DDNS instance")
+	runCmd.Flags().StringVar(&auditLog, "audit-log", "", "file every update history entry is appended to as a JSON line, empty disables the audit log")
 	rootCmd.AddCommand(runCmd)
 }
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/ddns"
+	"github.com/spf13/cobra"
+)
+
+// fetchStatus gets the status document of the server listening at address
+func fetchStatus(address string) (*ddns.Status, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(strings.TrimSuffix(address, "/") + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with status %s", res.Status)
+	}
+
+	var status ddns.Status
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status document: %w", err)
+	}
+	return &status, nil
+}
+
+func printStatusTable(w io.Writer, status *ddns.Status) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tCIRCUIT\tFAILURES\tOPEN UNTIL\tLAST ERROR")
+	for _, instance := range status.Instances {
+		b := instance.CircuitBreaker
+		openUntil := "-"
+		if b.OpenUntil != nil {
+			openUntil = b.OpenUntil.Local().Format(time.DateTime)
+		}
+		lastError := "-"
+		if b.LastError != "" {
+			lastError = b.LastError
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", instance.Name, b.State, b.ConsecutiveFailures, openUntil, lastError)
+	}
+	return table.Flush()
+}
+
+var (
+	statusAddress string
+	statusOutput  string
+
+	// statusCmd represents the status command
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the health of every DDNS instance of a running server.",
+		Long: `Show the circuit breaker state of every DDNS instance of a running server.
+Exits with a non-zero code when any instance is unhealthy.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if statusOutput != "table" && statusOutput != "json" {
+				return fmt.Errorf("unknown output format %s, must be table or json", statusOutput)
+			}
+
+			status, err := fetchStatus(statusAddress)
+			if err != nil {
+				return err
+			}
+
+			if statusOutput == "json" {
+				bytes, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(bytes))
+			} else if err := printStatusTable(cmd.OutOrStdout(), status); err != nil {
+				return err
+			}
+
+			if problems := status.Problems(); len(problems) > 0 {
+				return fmt.Errorf("%d of %d instance(s) unhealthy", len(problems), len(status.Instances))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	statusCmd.Flags().StringVar(&statusAddress, "address", "http://127.0.0.1:8080", "address of the metrics server of the running micro-ddns")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "output format, table or json")
+	rootCmd.AddCommand(statusCmd)
+}
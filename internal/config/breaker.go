@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "time"
+
+const (
+	DefaultBreakerFailureThreshold = 5
+	DefaultBreakerCoolDown         = time.Minute
+	DefaultBreakerMaxCoolDown      = time.Hour
+)
+
+// CircuitBreakerSpec defines when an instance failing on every run stops
+// calling its detection and provider for a while
+type CircuitBreakerSpec struct {
+	// FailureThreshold is the number of consecutive failed runs opening the
+	// circuit, use 0 to disable the circuit breaker
+	FailureThreshold *int `json:"failureThreshold,omitempty" yaml:"failureThreshold,omitempty"`
+
+	// CoolDown is how long runs are skipped once the circuit opens, doubled
+	// after every failed probe
+	CoolDown *Duration `json:"coolDown,omitempty" yaml:"coolDown,omitempty"`
+
+	// MaxCoolDown caps the cool-down
+	MaxCoolDown *Duration `json:"maxCoolDown,omitempty" yaml:"maxCoolDown,omitempty"`
+}
+
+// NewDefaultCircuitBreakerSpec returns the circuit breaker used when a DDNS spec has none
+func NewDefaultCircuitBreakerSpec() *CircuitBreakerSpec {
+	spec := &CircuitBreakerSpec{}
+	spec.setDefaults()
+	return spec
+}
+
+func (spec *CircuitBreakerSpec) setDefaults() {
+	if spec.FailureThreshold == nil {
+		threshold := DefaultBreakerFailureThreshold
+		spec.FailureThreshold = &threshold
+	}
+	if spec.CoolDown == nil {
+		coolDown := Duration(DefaultBreakerCoolDown)
+		spec.CoolDown = &coolDown
+	}
+	if spec.MaxCoolDown == nil {
+		maxCoolDown := Duration(DefaultBreakerMaxCoolDown)
+		spec.MaxCoolDown = &maxCoolDown
+	}
+}
+
+func (spec *CircuitBreakerSpec) Validate() error {
+	var errs FieldErrors
+	spec.setDefaults()
+
+	if *spec.FailureThreshold < 0 {
+		errs.addf("failureThreshold", "failureThreshold cannot be negative")
+	}
+
+	if *spec.CoolDown <= 0 {
+		errs.addf("coolDown", "coolDown must be positive")
+	}
+
+	if *spec.MaxCoolDown < *spec.CoolDown {
+		errs.addf("maxCoolDown", "maxCoolDown cannot be shorter than coolDown")
+	}
+	return errs.err()
+}
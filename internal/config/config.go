@@ -17,22 +17,13 @@ limitations under the License.
 package config
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"regexp"
+	"math"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/masteryyh/micro-ddns/pkg/utils"
-	"gopkg.in/yaml.v3"
-)
-
-var (
-	config Config
-
-	domainRegex    = regexp.MustCompile(`^[a-zA-Z0-9-]+\.[a-zA-Z]{2,}$`)
-	subdomainRegex = regexp.MustCompile(`^([a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*)|([a-zA-Z0-9]*@[a-zA-Z0-9]*)$`)
+	"github.com/robfig/cron/v3"
 )
 
 type NetworkStack string
@@ -40,6 +31,21 @@ type NetworkStack string
 const (
 	IPv4 NetworkStack = "IPv4"
 	IPv6 NetworkStack = "IPv6"
+
+	// DualStack manages both A and AAAA records
+	DualStack NetworkStack = "Both"
+)
+
+const (
+	// MinInterval is the shortest interval a DDNS spec can be scheduled at
+	MinInterval = 10 * time.Second
+
+	// DefaultAPICacheTTL is how long an address detected by an API is reused
+	DefaultAPICacheTTL = 30 * time.Second
+
+	// DefaultProviderMaxConcurrency is how many operations can run against a
+	// DNS provider at the same time
+	DefaultProviderMaxConcurrency = 4
 )
 
 type AddressDetectionType string
@@ -76,6 +82,9 @@ type DNSProviderSpec struct {
 
 	providerType DNSProvider
 
+	// owner is the name of the DDNS spec an inline provider is declared in
+	owner string
+
 	Cloudflare *CloudflareSpec `json:"cloudflare,omitempty" yaml:"cloudflare,omitempty"`
 
 	AliCloud *AliCloudSpec `json:"alicloud,omitempty" yaml:"alicloud,omitempty"`
@@ -87,9 +96,61 @@ type DNSProviderSpec struct {
 	JD *JDCloudSpec `json:"jd,omitempty" yaml:"jd,omitempty"`
 
 	RFC2136 *RFC2136Spec `json:"rfc2136,omitempty" yaml:"rfc2136,omitempty"`
+
+	// Limits caps how fast and how many operations of every DDNS spec using this
+	// provider run at the same time
+	Limits *ProviderLimitsSpec `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// ProviderLimitsSpec keeps the DDNS specs sharing a DNS provider under its API quotas
+type ProviderLimitsSpec struct {
+	// RequestsPerSecond is the rate operations are started at, 0 means unlimited
+	RequestsPerSecond *float64 `json:"requestsPerSecond,omitempty" yaml:"requestsPerSecond,omitempty"`
+
+	// Burst is how many operations can start at once before RequestsPerSecond applies
+	Burst *int `json:"burst,omitempty" yaml:"burst,omitempty"`
+
+	// MaxConcurrency is how many operations can run at the same time
+	MaxConcurrency *int `json:"maxConcurrency,omitempty" yaml:"maxConcurrency,omitempty"`
+}
+
+func (spec *ProviderLimitsSpec) setDefaults() {
+	if spec.RequestsPerSecond == nil {
+		rps := 0.0
+		spec.RequestsPerSecond = &rps
+	}
+	if spec.Burst == nil {
+		burst := max(1, int(math.Ceil(*spec.RequestsPerSecond)))
+		spec.Burst = &burst
+	}
+	if spec.MaxConcurrency == nil {
+		maxConcurrency := DefaultProviderMaxConcurrency
+		spec.MaxConcurrency = &maxConcurrency
+	}
+}
+
+func (spec *ProviderLimitsSpec) Validate() error {
+	var errs FieldErrors
+	spec.setDefaults()
+
+	if *spec.RequestsPerSecond < 0 {
+		errs.addf("requestsPerSecond", "requestsPerSecond cannot be negative")
+	}
+	if *spec.Burst < 1 {
+		errs.addf("burst", "burst must be at least 1")
+	}
+	if *spec.MaxConcurrency < 1 {
+		errs.addf("maxConcurrency", "maxConcurrency must be at least 1")
+	}
+	return errs.err()
 }
 
 func (spec *DNSProviderSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Name == "" {
+		errs.addf("name", "name is needed for a provider spec")
+	}
+
 	count := 0
 	if spec.Cloudflare != nil {
 		count++
@@ -110,53 +171,96 @@ func (spec *DNSProviderSpec) Validate() error {
 		count++
 	}
 
+	if spec.Limits == nil {
+		spec.Limits = &ProviderLimitsSpec{}
+	}
+	errs.add("limits", spec.Limits.Validate())
+
 	if count == 0 {
-		return fmt.Errorf("no provider specified")
+		errs.addf("", "no provider specified")
+		return errs
 	}
 
 	if count > 1 {
-		return fmt.Errorf("only 1 provider can be used within 1 spec")
+		errs.addf("", "only 1 provider can be used within 1 spec")
+		return errs
 	}
 
 	if spec.Cloudflare != nil {
 		spec.providerType = DNSProviderCloudflare
-		return spec.Cloudflare.Validate()
+		errs.add("cloudflare", spec.Cloudflare.Validate())
 	} else if spec.AliCloud != nil {
 		spec.providerType = DNSProviderAliCloud
-		return spec.AliCloud.Validate()
+		errs.add("alicloud", spec.AliCloud.Validate())
 	} else if spec.DNSPod != nil {
 		spec.providerType = DNSProviderDNSPod
-		return spec.DNSPod.Validate()
+		errs.add("dnspod", spec.DNSPod.Validate())
 	} else if spec.Huawei != nil {
 		spec.providerType = DNSProviderHuaweiCloud
-		return spec.Huawei.Validate()
+		errs.add("huawei", spec.Huawei.Validate())
 	} else if spec.JD != nil {
 		spec.providerType = DNSProviderJDCloud
-		return spec.JD.Validate()
+		errs.add("jd", spec.JD.Validate())
 	} else if spec.RFC2136 != nil {
 		spec.providerType = DNSProviderRFC2136
-		return spec.RFC2136.Validate()
+		errs.add("rfc2136", spec.RFC2136.Validate())
 	}
 
-	return nil
+	return errs.err()
+}
+
+func (spec *DNSProviderSpec) secrets(visit secretVisitor) {
+	switch {
+	case spec.Cloudflare != nil:
+		spec.Cloudflare.secrets(visit.within("cloudflare"))
+	case spec.AliCloud != nil:
+		spec.AliCloud.secrets(visit.within("alicloud"))
+	case spec.DNSPod != nil:
+		spec.DNSPod.secrets(visit.within("dnspod"))
+	case spec.Huawei != nil:
+		spec.Huawei.secrets(visit.within("huawei"))
+	case spec.JD != nil:
+		spec.JD.secrets(visit.within("jd"))
+	case spec.RFC2136 != nil:
+		spec.RFC2136.secrets(visit.within("rfc2136"))
+	}
 }
 
 func (spec *DNSProviderSpec) GetType() DNSProvider {
 	return spec.providerType
 }
 
+// PoolKey identifies the spec among top-level and inline providers, an
+// inline provider may share its name with a top-level one
+func (spec *DNSProviderSpec) PoolKey() string {
+	if spec.owner != "" {
+		return "inline:" + spec.owner
+	}
+	return "ref:" + spec.Name
+}
+
 // NetworkInterfaceDetectionSpec defines how should we get IP address from an interface
 // By default the first address detected will be used
 type NetworkInterfaceDetectionSpec struct {
 	// Name is the name of interface
 	Name string `json:"name" yaml:"name"`
+
+	// Watch triggers an update as soon as an address of the interface changes,
+	// only supported on Linux, enabled by default
+	Watch *bool `json:"watch,omitempty" yaml:"watch,omitempty"`
+}
+
+// IsWatched reports if address changes of the interface should trigger updates
+func (spec *NetworkInterfaceDetectionSpec) IsWatched() bool {
+	return spec.Watch == nil || *spec.Watch
 }
 
 func (spec *NetworkInterfaceDetectionSpec) Validate() error {
+	var errs FieldErrors
 	if spec.Name == "" {
-		return fmt.Errorf("interface name cannot be empty")
+		errs.addf("name", "interface name cannot be empty")
 	}
-	return nil
+	return errs.err()
 }
 
 // ThirdPartyServiceSpec defines how should we access third party API to get our IP address
@@ -180,16 +284,28 @@ type ThirdPartyServiceSpec struct {
 	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
 }
 
+func (spec *ThirdPartyServiceSpec) secrets(visit secretVisitor) {
+	if spec.Username != nil {
+		visit("username", spec.Username)
+	}
+	if spec.Password != nil {
+		visit("password", spec.Password)
+	}
+}
+
 func (spec *ThirdPartyServiceSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.URL == "" {
-		return fmt.Errorf("url cannot be empty")
+		errs.addf("url", "url cannot be empty")
 	}
 
 	if spec.JsonPath != nil && *spec.JsonPath == "" {
 		spec.JsonPath = nil
 	}
 
-	return nil
+	return errs.err()
 }
 
 // AddressDetectionSpec defines how should we detect current IP address
@@ -199,6 +315,9 @@ type AddressDetectionSpec struct {
 
 	detectionType AddressDetectionType
 
+	// owner is the name of the DDNS spec an inline detection is declared in
+	owner string
+
 	// LocalAddressPolicy defines how should we process addresses
 	// LocalAddressPolicyIgnore means the operation would fail when no public address presents on the interface
 	// LocalAddressPolicyAllow means local addresses will be used for DNS record, but only if no public address presents on the interface
@@ -208,32 +327,73 @@ type AddressDetectionSpec struct {
 	Interface *NetworkInterfaceDetectionSpec `json:"interface,omitempty" yaml:"interface,omitempty"`
 
 	API *ThirdPartyServiceSpec `json:"api,omitempty" yaml:"api,omitempty"`
+
+	// CacheTTL is how long a detected address is reused by every instance
+	// sharing this spec, defaults to 30s for API detection and 0 for interfaces
+	CacheTTL *Duration `json:"cacheTTL,omitempty" yaml:"cacheTTL,omitempty"`
 }
 
 func (spec *AddressDetectionSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Name == "" {
+		errs.addf("name", "name is needed for a detection spec")
+	}
+
 	if spec.LocalAddressPolicy == nil {
 		spec.LocalAddressPolicy = (*LocalAddressPolicy)(utils.StringPtr("Ignore"))
 	}
 
 	p := *spec.LocalAddressPolicy
 	if p != "Ignore" && p != "Prefer" && p != "Allow" {
-		return fmt.Errorf("unknown localAddressPolicy %s", p)
+		errs.addf("localAddressPolicy", "unknown localAddressPolicy %s", p)
 	}
 
 	if spec.Interface != nil {
 		spec.detectionType = AddressDetectionIface
-		return spec.Interface.Validate()
+		errs.add("interface", spec.Interface.Validate())
 	} else if spec.API != nil {
 		spec.detectionType = AddressDetectionThirdParty
-		return spec.API.Validate()
+		errs.add("api", spec.API.Validate())
+	} else {
+		errs.addf("", "must specify a detection method")
+	}
+
+	if spec.CacheTTL != nil && *spec.CacheTTL < 0 {
+		errs.addf("cacheTTL", "cacheTTL cannot be negative")
+	}
+	return errs.err()
+}
+
+func (spec *AddressDetectionSpec) secrets(visit secretVisitor) {
+	if spec.API != nil {
+		spec.API.secrets(visit.within("api"))
 	}
-	return fmt.Errorf("must specify a detection method")
 }
 
 func (spec *AddressDetectionSpec) GetDetectionType() AddressDetectionType {
 	return spec.detectionType
 }
 
+// PoolKey identifies the spec among top-level and inline detections, an
+// inline detection may share its name with a top-level one
+func (spec *AddressDetectionSpec) PoolKey() string {
+	if spec.owner != "" {
+		return "inline:" + spec.owner
+	}
+	return "ref:" + spec.Name
+}
+
+// GetCacheTTL returns how long a detected address can be reused
+func (spec *AddressDetectionSpec) GetCacheTTL() time.Duration {
+	if spec.CacheTTL != nil {
+		return spec.CacheTTL.Duration()
+	}
+	if spec.detectionType == AddressDetectionThirdParty {
+		return DefaultAPICacheTTL
+	}
+	return 0
+}
+
 // DDNSSpec is the specification of DDNS service
 type DDNSSpec struct {
 	// Name is the name of the specification
@@ -243,72 +403,303 @@ type DDNSSpec struct {
 	Domain string `json:"domain" yaml:"domain"`
 
 	// Subdomain is the subdomain to update, use "@" if no subdomain is used
-	Subdomain string `json:"subdomain" yaml:"subdomain"`
+	Subdomain string `json:"subdomain,omitempty" yaml:"subdomain,omitempty"`
+
+	// Subdomains is a list of subdomains to update with the same address,
+	// can be used together with Subdomain
+	Subdomains []string `json:"subdomains,omitempty" yaml:"subdomains,omitempty"`
 
-	// Stack determines if IPv4 or IPv6 is used
+	// Stack determines if IPv4, IPv6 or both of them are used
 	Stack NetworkStack `json:"stack" yaml:"stack"`
 
 	// Cron is the cron expression about how should we schedule this task
-	Cron string `json:"cron" yaml:"cron"`
+	Cron string `json:"cron,omitempty" yaml:"cron,omitempty"`
+
+	// Interval runs this task at a fixed interval instead of a cron schedule
+	Interval *Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+
+	// IntervalJitter adds a random delay of up to this duration to every interval
+	IntervalJitter *Duration `json:"intervalJitter,omitempty" yaml:"intervalJitter,omitempty"`
 
 	// ProviderRef is the name of the DNS provider specification defined by user
-	ProviderRef string `json:"providerRef" yaml:"providerRef"`
+	ProviderRef string `json:"providerRef,omitempty" yaml:"providerRef,omitempty"`
+
+	// Provider is an inline DNS provider specification, used instead of ProviderRef
+	Provider *DNSProviderSpec `json:"provider,omitempty" yaml:"provider,omitempty"`
 
 	// DetectionRef is the name of the address detection specification defined by user
-	DetectionRef string `json:"detectionRef" yaml:"detectionRef"`
+	DetectionRef string `json:"detectionRef,omitempty" yaml:"detectionRef,omitempty"`
+
+	// Detection is an inline address detection specification, used instead of DetectionRef
+	Detection *AddressDetectionSpec `json:"detection,omitempty" yaml:"detection,omitempty"`
+
+	// DetectionRefs are several address detection specifications combined by
+	// DetectionStrategy, used instead of DetectionRef
+	DetectionRefs []string `json:"detectionRefs,omitempty" yaml:"detectionRefs,omitempty"`
+
+	// DetectionStrategy defines how the addresses of DetectionRefs are combined,
+	// and the static addresses used when detection fails
+	DetectionStrategy *DetectionStrategySpec `json:"detectionStrategy,omitempty" yaml:"detectionStrategy,omitempty"`
+
+	// RunOnStart updates the records as soon as the instance is started instead of
+	// waiting for the first cron tick, overrides the global --run-on-start flag
+	RunOnStart *bool `json:"runOnStart,omitempty" yaml:"runOnStart,omitempty"`
+
+	// DryRun detects the address and queries the DNS provider, but only reports
+	// the changes it would make instead of making them
+	DryRun *bool `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+
+	// DeleteOnShutdown deletes the records published by the instance when
+	// micro-ddns shuts down gracefully, for hosts that don't outlive the process
+	DeleteOnShutdown *bool `json:"deleteOnShutdown,omitempty" yaml:"deleteOnShutdown,omitempty"`
+
+	// Retry defines how failed updates are retried, a default policy is used if empty
+	Retry *RetrySpec `json:"retry,omitempty" yaml:"retry,omitempty"`
+
+	// CircuitBreaker defines when runs are skipped after failing repeatedly,
+	// a default circuit breaker is used if empty
+	CircuitBreaker *CircuitBreakerSpec `json:"circuitBreaker,omitempty" yaml:"circuitBreaker,omitempty"`
 
-	detectionSpec *AddressDetectionSpec
+	// Hooks are run after the address of a record changed
+	Hooks []*HookSpec `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+
+	// Notify sends events of this spec to notifiers defined in notification
+	Notify []*SubscriptionSpec `json:"notify,omitempty" yaml:"notify,omitempty"`
+
+	detectionSpecs []*AddressDetectionSpec
 
 	providerSpec *DNSProviderSpec
 }
 
 func (spec *DDNSSpec) Validate() error {
+	var errs FieldErrors
 	if spec.Name == "" {
-		return fmt.Errorf("name is needed for a DDNS spec")
+		errs.addf("name", "name is needed for a DDNS spec")
 	}
 
+	// Unicode names are converted to punycode here so providers only ever see ASCII
 	if spec.Domain == "" {
-		return fmt.Errorf("domain cannot be empty")
+		errs.addf("domain", "domain cannot be empty")
+	} else if domain, err := normalizeDomain(spec.Domain); err != nil {
+		errs.add("domain", err)
+	} else {
+		spec.Domain = domain
+	}
+
+	if spec.Subdomain == "" && len(spec.Subdomains) == 0 {
+		errs.addf("subdomain", "subdomain cannot be empty, use \"@\" if you want to use zone apex")
+	} else if spec.Subdomain != "" {
+		if subdomain, err := normalizeSubdomain(spec.Subdomain); err != nil {
+			errs.add("subdomain", err)
+		} else {
+			spec.Subdomain = subdomain
+		}
 	}
 
-	if !domainRegex.MatchString(spec.Domain) {
-		return fmt.Errorf("%s is not a valid domain", spec.Domain)
-	}
+	seen := map[string]bool{strings.ToLower(spec.Subdomain): spec.Subdomain != ""}
+	for i, subdomain := range spec.Subdomains {
+		path := indexPath("subdomains", i)
+		if subdomain == "" {
+			errs.addf(path, "subdomain cannot be empty, use \"@\" if you want to use zone apex")
+			continue
+		}
 
-	if spec.Subdomain == "" {
-		return fmt.Errorf("subdomain cannot be empty, use \"@\" if you want to use zone apex")
+		normalized, err := normalizeSubdomain(subdomain)
+		if err != nil {
+			errs.add(path, err)
+			continue
+		}
+		spec.Subdomains[i] = normalized
+
+		if seen[strings.ToLower(normalized)] {
+			errs.addf(path, "subdomain %s is listed more than once", subdomain)
+			continue
+		}
+		seen[strings.ToLower(normalized)] = true
 	}
 
-	if !subdomainRegex.MatchString(spec.Subdomain) {
-		return fmt.Errorf("%s is not a valid subdomain", spec.Subdomain)
+	if len(errs) == 0 {
+		for _, subdomain := range spec.GetSubdomains() {
+			if subdomain != ZoneApex && len(subdomain)+1+len(spec.Domain) > maxDomainLength {
+				errs.addf("subdomain", "%s.%s is longer than %d characters", subdomain, spec.Domain, maxDomainLength)
+			}
+		}
 	}
 
 	stack := string(spec.Stack)
 	if stack == "" {
-		return fmt.Errorf("stack cannot be empty, must be one of IPv4 or IPv6")
+		errs.addf("stack", "stack cannot be empty, must be one of IPv4, IPv6 or Both")
+	} else if stack != "IPv4" && stack != "IPv6" && stack != "Both" {
+		errs.addf("stack", "%s is not a valid stack, must be one of IPv4, IPv6 or Both", stack)
 	}
 
-	if stack != "IPv4" && stack != "IPv6" {
-		return fmt.Errorf("%s is not a valid stack, must be one of IPv4 or IPv6", stack)
+	if spec.Cron != "" && spec.Interval != nil {
+		errs.addf("interval", "interval cannot be used together with cron")
+	} else if spec.Interval != nil {
+		if *spec.Interval < Duration(MinInterval) {
+			errs.addf("interval", "interval cannot be shorter than %s", MinInterval)
+		}
+	} else if spec.Cron == "" {
+		errs.addf("cron", "either cron or interval must be specified")
+	} else if _, err := cron.ParseStandard(spec.Cron); err != nil {
+		errs.addf("cron", "%s is not a valid cron expression: %v", spec.Cron, err)
+	}
+
+	if spec.IntervalJitter != nil {
+		if spec.Interval == nil {
+			errs.addf("intervalJitter", "intervalJitter can only be used with interval")
+		} else if *spec.IntervalJitter < 0 {
+			errs.addf("intervalJitter", "intervalJitter cannot be negative")
+		}
+	}
+
+	// Inline specs are wired directly, references are resolved by Config.Validate
+	if spec.Provider != nil {
+		if spec.ProviderRef != "" {
+			errs.addf("providerRef", "providerRef cannot be used together with an inline provider")
+		}
+		if spec.Provider.Name == "" {
+			spec.Provider.Name = spec.Name
+		}
+		spec.Provider.owner = spec.Name
+		errs.add("provider", spec.Provider.Validate())
+		spec.providerSpec = spec.Provider
+	} else if spec.ProviderRef == "" {
+		errs.addf("providerRef", "providerref cannot be empty")
+	}
+
+	if spec.Detection != nil {
+		if spec.DetectionRef != "" {
+			errs.addf("detectionRef", "detectionRef cannot be used together with an inline detection")
+		}
+		if len(spec.DetectionRefs) > 0 {
+			errs.addf("detectionRefs", "detectionRefs cannot be used together with an inline detection")
+		}
+		if spec.Detection.Name == "" {
+			spec.Detection.Name = spec.Name
+		}
+		spec.Detection.owner = spec.Name
+		errs.add("detection", spec.Detection.Validate())
+		spec.detectionSpecs = []*AddressDetectionSpec{spec.Detection}
+	} else if spec.DetectionRef != "" && len(spec.DetectionRefs) > 0 {
+		errs.addf("detectionRefs", "detectionRefs cannot be used together with detectionRef")
+	} else if spec.DetectionRef == "" && len(spec.DetectionRefs) == 0 {
+		errs.addf("detectionRef", "detectionref cannot be empty")
+	}
+
+	seenRefs := make(map[string]bool, len(spec.DetectionRefs))
+	for i, ref := range spec.DetectionRefs {
+		path := indexPath("detectionRefs", i)
+		if ref == "" {
+			errs.addf(path, "detection reference cannot be empty")
+		} else if seenRefs[ref] {
+			errs.addf(path, "detection spec %s is referenced more than once", ref)
+		}
+		seenRefs[ref] = true
+	}
+
+	if spec.DetectionStrategy != nil {
+		errs.add("detectionStrategy", spec.DetectionStrategy.Validate(spec.detectionSources()))
+	}
+
+	if spec.Retry == nil {
+		spec.Retry = NewDefaultRetrySpec()
+	}
+	errs.add("retry", spec.Retry.Validate())
+
+	if spec.CircuitBreaker == nil {
+		spec.CircuitBreaker = NewDefaultCircuitBreakerSpec()
+	}
+	errs.add("circuitBreaker", spec.CircuitBreaker.Validate())
+
+	for i, hook := range spec.Hooks {
+		if hook.Name == "" {
+			hook.Name = indexPath("hooks", i)
+		}
+		errs.add(indexPath("hooks", i), hook.Validate())
 	}
 
-	if spec.Cron == "" {
-		return fmt.Errorf("crontab cannot be empty")
+	for i, subscription := range spec.Notify {
+		errs.add(indexPath("notify", i), subscription.Validate())
 	}
 
-	if spec.ProviderRef == "" {
-		return fmt.Errorf("providerref cannot be empty")
+	return errs.err()
+}
+
+// secrets visits the secrets of the inline specs and hooks, referenced specs
+// are visited through the top-level lists of Config
+func (spec *DDNSSpec) secrets(visit secretVisitor) {
+	if spec.Provider != nil {
+		spec.Provider.secrets(visit.within("provider"))
+	}
+	if spec.Detection != nil {
+		spec.Detection.secrets(visit.within("detection"))
+	}
+	for i, hook := range spec.Hooks {
+		hook.secrets(visit.within(indexPath("hooks", i)))
 	}
+}
+
+// IsDryRun reports if changes of this spec should only be reported
+func (spec *DDNSSpec) IsDryRun() bool {
+	return spec.DryRun != nil && *spec.DryRun
+}
+
+// IsDeleteOnShutdown reports if the records of this spec are deleted on shutdown
+func (spec *DDNSSpec) IsDeleteOnShutdown() bool {
+	return spec.DeleteOnShutdown != nil && *spec.DeleteOnShutdown
+}
 
-	if spec.DetectionRef == "" {
-		return fmt.Errorf("detectionref cannot be empty")
+// GetSubdomains returns every subdomain managed by this spec, Subdomain first
+func (spec *DDNSSpec) GetSubdomains() []string {
+	subdomains := make([]string, 0, len(spec.Subdomains)+1)
+	if spec.Subdomain != "" {
+		subdomains = append(subdomains, spec.Subdomain)
 	}
+	return append(subdomains, spec.Subdomains...)
+}
+
+// GetStacks returns every single stack managed by this spec, DualStack is
+// expanded to IPv4 and IPv6
+func (spec *DDNSSpec) GetStacks() []NetworkStack {
+	if spec.Stack == DualStack {
+		return []NetworkStack{IPv4, IPv6}
+	}
+	return []NetworkStack{spec.Stack}
+}
+
+// targets returns the DNS records managed by this spec, as FQDN and record type
+func (spec *DDNSSpec) targets() []string {
+	var targets []string
+	for _, stack := range spec.GetStacks() {
+		recordType := "A"
+		if stack == IPv6 {
+			recordType = "AAAA"
+		}
 
-	return nil
+		for _, subdomain := range spec.GetSubdomains() {
+			fqdn := strings.ToLower(spec.Domain)
+			if subdomain != ZoneApex {
+				fqdn = strings.ToLower(subdomain) + "." + fqdn
+			}
+			targets = append(targets, fqdn+" "+recordType)
+		}
+	}
+	return targets
 }
 
-func (spec *DDNSSpec) GetDetectionSpec() *AddressDetectionSpec {
-	return spec.detectionSpec
+// detectionSources returns the number of detection specs used by this spec
+func (spec *DDNSSpec) detectionSources() int {
+	if spec.Detection == nil && len(spec.DetectionRefs) > 0 {
+		return len(spec.DetectionRefs)
+	}
+	return 1
+}
+
+// GetDetectionSpecs returns the detection specs of this spec, in the order
+// they are tried by the firstSuccess strategy
+func (spec *DDNSSpec) GetDetectionSpecs() []*AddressDetectionSpec {
+	return spec.detectionSpecs
 }
 
 func (spec *DDNSSpec) GetProviderSpec() *DNSProviderSpec {
@@ -317,144 +708,182 @@ func (spec *DDNSSpec) GetProviderSpec() *DNSProviderSpec {
 
 // Config is the configuration of this application
 type Config struct {
+	// Include is a list of other config files or directories to merge into
+	// this one, relative paths are resolved from the directory of this file
+	// and glob patterns are supported
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+
 	DDNS []*DDNSSpec `json:"ddns" yaml:"ddns"`
 
 	Detection []*AddressDetectionSpec `json:"detection" yaml:"detection"`
 
 	Provider []*DNSProviderSpec `json:"provider" yaml:"provider"`
+
+	Notification []*NotifierSpec `json:"notification,omitempty" yaml:"notification,omitempty"`
 }
 
 func (c *Config) Validate() error {
+	var errs FieldErrors
 	if len(c.DDNS) == 0 {
-		return fmt.Errorf("must have at least 1 ddns spec")
-	}
-
-	if len(c.Detection) == 0 {
-		return fmt.Errorf("must have at least 1 detection spec")
-	}
-
-	if len(c.Provider) == 0 {
-		return fmt.Errorf("must have at least 1 provider")
+		errs.addf("ddns", "must have at least 1 ddns spec")
 	}
 
 	var validateWg sync.WaitGroup
-	validateWg.Add(3)
+	validateWg.Add(4)
 
-	var ddnsErr error
+	var ddnsErrs FieldErrors
 	ddns := make(map[string]*DDNSSpec)
+	ddnsValid := make([]bool, len(c.DDNS))
 	go func(wg *sync.WaitGroup) {
-		for _, spec := range c.DDNS {
+		for i, spec := range c.DDNS {
+			path := indexPath("ddns", i)
 			if _, exists := ddns[spec.Name]; exists {
-				ddnsErr = fmt.Errorf("ddns spec %s already exists", spec.Name)
-				break
+				ddnsErrs.addf(path+".name", "ddns spec %s already exists", spec.Name)
+				continue
 			}
-			if ddnsErr = spec.Validate(); ddnsErr != nil {
-				break
+			if err := spec.Validate(); err != nil {
+				ddnsErrs.add(path, err)
+			} else {
+				ddnsValid[i] = true
 			}
 			ddns[spec.Name] = spec
 		}
 		wg.Done()
 	}(&validateWg)
 
-	var detectionErr error
+	var detectionErrs FieldErrors
 	detects := make(map[string]*AddressDetectionSpec)
 	go func(wg *sync.WaitGroup) {
-		for _, spec := range c.Detection {
+		for i, spec := range c.Detection {
+			path := indexPath("detection", i)
 			if _, exists := detects[spec.Name]; exists {
-				detectionErr = fmt.Errorf("detection spec %s already exists", spec.Name)
-				break
-			}
-			if detectionErr = spec.Validate(); detectionErr != nil {
-				break
+				detectionErrs.addf(path+".name", "detection spec %s already exists", spec.Name)
+				continue
 			}
+			detectionErrs.add(path, spec.Validate())
 			detects[spec.Name] = spec
 		}
 		wg.Done()
 	}(&validateWg)
 
-	var providerErr error
+	var providerErrs FieldErrors
 	providers := make(map[string]*DNSProviderSpec)
 	go func(wg *sync.WaitGroup) {
-		for _, spec := range c.Provider {
+		for i, spec := range c.Provider {
+			path := indexPath("provider", i)
 			if _, exists := providers[spec.Name]; exists {
-				providerErr = fmt.Errorf("provider spec %s already exists", spec.Name)
-				break
-			}
-			if providerErr = spec.Validate(); providerErr != nil {
-				break
+				providerErrs.addf(path+".name", "provider spec %s already exists", spec.Name)
+				continue
 			}
+			providerErrs.add(path, spec.Validate())
 			providers[spec.Name] = spec
 		}
 		wg.Done()
 	}(&validateWg)
 
+	var notifierErrs FieldErrors
+	notifiers := make(map[string]*NotifierSpec)
+	go func(wg *sync.WaitGroup) {
+		for i, spec := range c.Notification {
+			path := indexPath("notification", i)
+			if _, exists := notifiers[spec.Name]; exists {
+				notifierErrs.addf(path+".name", "notifier spec %s already exists", spec.Name)
+				continue
+			}
+			notifierErrs.add(path, spec.Validate())
+			notifiers[spec.Name] = spec
+		}
+		wg.Done()
+	}(&validateWg)
+
 	validateWg.Wait()
 
-	if ddnsErr != nil {
-		return ddnsErr
-	}
-	if detectionErr != nil {
-		return detectionErr
-	}
-	if providerErr != nil {
-		return providerErr
-	}
+	errs = append(errs, ddnsErrs...)
+	errs = append(errs, detectionErrs...)
+	errs = append(errs, providerErrs...)
+	errs = append(errs, notifierErrs...)
 
-	for k := range ddns {
-		ddnsSpec := ddns[k]
+	targets := make(map[string]string)
+	for i, ddnsSpec := range c.DDNS {
+		path := indexPath("ddns", i)
 
 		detectionName := ddnsSpec.DetectionRef
-		if _, exists := detects[detectionName]; !exists {
-			return fmt.Errorf("ddns spec %s referenced unknown detection spec %s", ddnsSpec.Name, detectionName)
+		if detectionName != "" && ddnsSpec.Detection == nil {
+			if _, exists := detects[detectionName]; !exists {
+				errs.addf(path+".detectionRef", "ddns spec %s referenced unknown detection spec %s", ddnsSpec.Name, detectionName)
+			}
+			ddnsSpec.detectionSpecs = []*AddressDetectionSpec{detects[detectionName]}
+		}
+
+		if len(ddnsSpec.DetectionRefs) > 0 && ddnsSpec.Detection == nil && detectionName == "" {
+			ddnsSpec.detectionSpecs = make([]*AddressDetectionSpec, 0, len(ddnsSpec.DetectionRefs))
+			for j, name := range ddnsSpec.DetectionRefs {
+				detection, exists := detects[name]
+				if !exists {
+					errs.addf(joinPath(path, indexPath("detectionRefs", j)), "ddns spec %s referenced unknown detection spec %s", ddnsSpec.Name, name)
+					continue
+				}
+				ddnsSpec.detectionSpecs = append(ddnsSpec.detectionSpecs, detection)
+			}
 		}
-		ddnsSpec.detectionSpec = detects[detectionName]
 
 		providerName := ddnsSpec.ProviderRef
-		if _, exists := providers[providerName]; !exists {
-			return fmt.Errorf("ddns spec %s referenced unknown provider spec %s", ddnsSpec.Name, providerName)
+		if providerName != "" && ddnsSpec.Provider == nil {
+			if _, exists := providers[providerName]; !exists {
+				errs.addf(path+".providerRef", "ddns spec %s referenced unknown provider spec %s", ddnsSpec.Name, providerName)
+			}
+			ddnsSpec.providerSpec = providers[providerName]
+		}
+
+		for j, subscription := range ddnsSpec.Notify {
+			if subscription.NotifierRef == "" {
+				continue
+			}
+			notifier, exists := notifiers[subscription.NotifierRef]
+			if !exists {
+				errs.addf(joinPath(path, indexPath("notify", j)+".notifierRef"), "ddns spec %s referenced unknown notifier spec %s", ddnsSpec.Name, subscription.NotifierRef)
+			}
+			subscription.notifierSpec = notifier
+		}
+
+		// Only check for conflicting records between specs that are valid
+		// by themselves, the targets of an invalid spec are meaningless
+		if !ddnsValid[i] {
+			continue
+		}
+		for _, target := range ddnsSpec.targets() {
+			if owner, exists := targets[target]; exists {
+				errs.addf(path, "record %s is already managed by ddns spec %s", target, owner)
+				continue
+			}
+			targets[target] = ddnsSpec.Name
 		}
-		ddnsSpec.providerSpec = providers[providerName]
 	}
-	return nil
+
+	return errs.err()
 }
 
+// ReadConfigOrGet reads configuration from path, validates it and resolves its
+// secrets, path can either be a single YAML/JSON file or a directory of config
+// fragments
 func ReadConfigOrGet(path string) (*Config, error) {
-	info, err := os.Stat(path)
-	if err != nil {
+	loader := newConfigLoader()
+	if err := loader.load(path); err != nil {
 		return nil, err
 	}
 
-	if info.IsDir() {
-		return nil, fmt.Errorf("config path points to a directory")
+	if len(loader.duplicates) > 0 {
+		return nil, loader.duplicates
 	}
 
-	parts := strings.Split(path, ".")
-	if len(parts) < 2 {
-		return nil, fmt.Errorf("config path points to an unknown file type")
-	}
-
-	content, err := os.ReadFile(path)
-	if err != nil {
+	config := loader.merged
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	fileType := parts[len(parts)-1]
-	switch fileType {
-	case "yaml", "yml":
-		if err := yaml.Unmarshal(content, &config); err != nil {
-			return nil, err
-		}
-	case "json":
-		if err := json.Unmarshal(content, &config); err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("config path points to an unknown file type")
-	}
-
-	if err := config.Validate(); err != nil {
+	if err := config.ResolveSecrets(); err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
 }
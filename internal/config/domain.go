@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxLabelLength  = 63
+	maxDomainLength = 253
+
+	// ZoneApex is the subdomain used for the zone itself
+	ZoneApex = "@"
+
+	// Wildcard is the label matching any name that has no record of its own
+	Wildcard = "*"
+)
+
+// validateLabel checks a single label against the LDH rule of RFC 1035,
+// relaxed by RFC 1123 to allow labels starting with a digit
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("empty label")
+	}
+
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label %s is longer than %d characters", label, maxLabelLength)
+	}
+
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("label %s contains invalid character %q", label, c)
+		}
+	}
+
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return fmt.Errorf("label %s cannot start or end with a hyphen", label)
+	}
+	return nil
+}
+
+// toASCII converts a possibly Unicode name to punycode and validates every label
+func toASCII(name string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, label := range strings.Split(ascii, ".") {
+		if err := validateLabel(label); err != nil {
+			return "", err
+		}
+	}
+	return ascii, nil
+}
+
+// normalizeDomain validates a zone name and returns it in punycode, a zone
+// can have any number of labels, e.g. example.co.uk or home.example.com
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("%s is not a valid domain, at least 2 labels are required", domain)
+	}
+
+	ascii, err := toASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid domain: %w", domain, err)
+	}
+
+	if len(ascii) > maxDomainLength {
+		return "", fmt.Errorf("%s is not a valid domain, it is longer than %d characters", domain, maxDomainLength)
+	}
+	return ascii, nil
+}
+
+// normalizeSubdomain validates a subdomain relative to its zone and returns it
+// in punycode, "@" stands for the zone apex and a leading "*" label for a wildcard
+func normalizeSubdomain(subdomain string) (string, error) {
+	if subdomain == ZoneApex || subdomain == Wildcard {
+		return subdomain, nil
+	}
+
+	name := subdomain
+	prefix := ""
+	if strings.HasPrefix(name, Wildcard+".") {
+		prefix = Wildcard + "."
+		name = strings.TrimPrefix(name, prefix)
+	}
+
+	ascii, err := toASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid subdomain: %w", subdomain, err)
+	}
+	return prefix + ascii, nil
+}
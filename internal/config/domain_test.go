@@ -0,0 +1,206 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+
+	tests := []struct {
+		name    string
+		domain  string
+		want    string
+		wantErr string
+	}{
+		{name: "two labels", domain: "example.com", want: "example.com"},
+		{name: "several labels", domain: "home.example.co.uk", want: "home.example.co.uk"},
+		{name: "trailing dot", domain: "example.com.", want: "example.com"},
+		{name: "upper case", domain: "Example.COM", want: "example.com"},
+		{name: "leading digit", domain: "1example.com", want: "1example.com"},
+		{name: "inner hyphen", domain: "my-example.com", want: "my-example.com"},
+		{name: "unicode", domain: "bücher.example", want: "xn--bcher-kva.example"},
+		{name: "unicode TLD", domain: "例子.测试", want: "xn--fsqu00a.xn--0zwm56d"},
+		{name: "punycode", domain: "xn--bcher-kva.example", want: "xn--bcher-kva.example"},
+		{name: "longest label", domain: label63 + ".com", want: label63 + ".com"},
+		{name: "single label", domain: "localhost", wantErr: "at least 2 labels are required"},
+		{name: "label too long", domain: label63 + "a.com", wantErr: "is not a valid domain"},
+		{name: "leading hyphen", domain: "-example.com", wantErr: "is not a valid domain"},
+		{name: "trailing hyphen", domain: "example-.com", wantErr: "is not a valid domain"},
+		{name: "underscore", domain: "my_example.com", wantErr: "is not a valid domain"},
+		{name: "space", domain: "my example.com", wantErr: "is not a valid domain"},
+		{name: "empty label", domain: "example..com", wantErr: "is not a valid domain"},
+		{name: "wildcard", domain: "*.example.com", wantErr: "is not a valid domain"},
+		{
+			name:    "name too long",
+			domain:  strings.Repeat(label63+".", 4) + "com",
+			wantErr: "longer than 253 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeDomain(tt.domain)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tt.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubdomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		subdomain string
+		want      string
+		wantErr   string
+	}{
+		{name: "single label", subdomain: "home", want: "home"},
+		{name: "several labels", subdomain: "home.lab", want: "home.lab"},
+		{name: "upper case", subdomain: "Home", want: "home"},
+		{name: "zone apex", subdomain: "@", want: "@"},
+		{name: "wildcard", subdomain: "*", want: "*"},
+		{name: "leftmost wildcard", subdomain: "*.home", want: "*.home"},
+		{name: "unicode", subdomain: "bücher", want: "xn--bcher-kva"},
+		{name: "unicode below wildcard", subdomain: "*.bücher", want: "*.xn--bcher-kva"},
+		{name: "longest label", subdomain: strings.Repeat("a", 63), want: strings.Repeat("a", 63)},
+		{name: "empty", subdomain: "", wantErr: "is not a valid subdomain"},
+		{name: "only a dot", subdomain: ".", wantErr: "is not a valid subdomain"},
+		{name: "leading dot", subdomain: ".home", wantErr: "is not a valid subdomain"},
+		{name: "trailing dot", subdomain: "home.", wantErr: "is not a valid subdomain"},
+		{name: "empty label", subdomain: "home..lab", wantErr: "is not a valid subdomain"},
+		{name: "label too long", subdomain: strings.Repeat("a", 64), wantErr: "is not a valid subdomain"},
+		{name: "leading hyphen", subdomain: "-home", wantErr: "is not a valid subdomain"},
+		{name: "trailing hyphen", subdomain: "home-", wantErr: "is not a valid subdomain"},
+		{name: "underscore", subdomain: "_acme", wantErr: "is not a valid subdomain"},
+		{name: "inner wildcard", subdomain: "home.*.lab", wantErr: "is not a valid subdomain"},
+		{name: "trailing wildcard", subdomain: "home.*", wantErr: "is not a valid subdomain"},
+		{name: "double wildcard", subdomain: "*.*.home", wantErr: "is not a valid subdomain"},
+		{name: "partial wildcard", subdomain: "ho*me", wantErr: "is not a valid subdomain"},
+		{name: "apex in a name", subdomain: "@.home", wantErr: "is not a valid subdomain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeSubdomain(tt.subdomain)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tt.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateRecordNames(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	tests := []struct {
+		name      string
+		domain    string
+		subdomain string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "unicode names",
+			domain:    "bücher.example",
+			subdomain: "'*.läden'",
+			want:      "*.xn--lden-loa.xn--bcher-kva.example",
+		},
+		{
+			name:      "longest name",
+			domain:    strings.Repeat(label63+".", 2) + "com",
+			subdomain: label63 + "." + strings.Repeat("a", 57),
+			want:      label63 + "." + strings.Repeat("a", 57) + "." + strings.Repeat(label63+".", 2) + "com",
+		},
+		{
+			name:      "name too long",
+			domain:    strings.Repeat(label63+".", 2) + "com",
+			subdomain: label63 + "." + strings.Repeat("a", 58),
+			wantErr:   "is longer than 253 characters",
+		},
+		{
+			name:      "apex of the longest domain",
+			domain:    strings.Repeat(label63+".", 3) + strings.Repeat("a", 61),
+			subdomain: "'@'",
+			want:      strings.Repeat(label63+".", 3) + strings.Repeat("a", 61),
+		},
+		{
+			name:      "empty subdomain",
+			domain:    "example.com",
+			subdomain: "''",
+			wantErr:   `use "@" if you want to use zone apex`,
+		},
+		{
+			name:      "dotted subdomain",
+			domain:    "example.com",
+			subdomain: "'.'",
+			wantErr:   "is not a valid subdomain",
+		},
+		{
+			name:      "wildcard below a label",
+			domain:    "example.com",
+			subdomain: "home.*",
+			wantErr:   "is not a valid subdomain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.NewReplacer(
+				"domain: example.com\n", "domain: "+tt.domain+"\n",
+				"subdomain: home\n", "subdomain: "+tt.subdomain+"\n",
+			).Replace(fmt.Sprintf(ddnsConfig, "    interval: 1h\n    providerRef: home\n    detectionRef: home"))
+
+			config, errs := validateContent(t, content)
+			if tt.wantErr != "" {
+				expectProblem(t, errs, "ddns[0].subdomain", tt.wantErr)
+				return
+			}
+			expectProblem(t, errs, "", "")
+
+			spec := config.DDNS[0]
+			name := spec.Domain
+			if spec.Subdomain != ZoneApex {
+				name = spec.Subdomain + "." + name
+			}
+			if name != tt.want {
+				t.Errorf("expected name %s, got %s", tt.want, name)
+			}
+		})
+	}
+}
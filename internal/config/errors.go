@@ -0,0 +1,142 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a validation problem of a specific field
+type FieldError struct {
+	// Path is the path to the field, e.g. provider[2].rfc2136.tsig.key
+	Path string
+
+	// File is the config file the field was loaded from, if known
+	File string
+
+	// Line is the line of the field in File, if known
+	Line int
+
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	msg := e.Err.Error()
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+
+	if e.File != "" {
+		location := e.File
+		if e.Line > 0 {
+			location += ":" + strconv.Itoa(e.Line)
+		}
+		msg = location + ": " + msg
+	}
+	return msg
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors is a list of every validation problem found
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// add records err under path, errors returned by nested Validate calls keep
+// their own path with path prepended
+func (e *FieldErrors) add(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	var nested FieldErrors
+	if errors.As(err, &nested) {
+		for _, fieldErr := range nested {
+			fieldErr.Path = joinPath(path, fieldErr.Path)
+			*e = append(*e, fieldErr)
+		}
+		return
+	}
+
+	*e = append(*e, &FieldError{
+		Path: path,
+		Err:  err,
+	})
+}
+
+func (e *FieldErrors) addf(path string, format string, args ...interface{}) {
+	e.add(path, fmt.Errorf(format, args...))
+}
+
+// checkSecret records a malformed secret reference, see checkSecret
+func (e *FieldErrors) checkSecret(path string, field *string) {
+	if field == nil {
+		return
+	}
+	e.add(path, checkSecret(*field))
+}
+
+// resolveSecret resolves a secret reference in place, see resolveSecret
+func (e *FieldErrors) resolveSecret(path string, field *string) {
+	if field == nil {
+		return
+	}
+
+	v, err := resolveSecret(*field)
+	if err != nil {
+		e.add(path, err)
+		return
+	}
+	*field = v
+}
+
+// err returns nil if no problem was recorded, so callers can return it directly
+func (e FieldErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func joinPath(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "" {
+		return prefix
+	}
+	if strings.HasPrefix(path, "[") {
+		return prefix + path
+	}
+	return prefix + "." + path
+}
+
+func indexPath(prefix string, index int) string {
+	return prefix + "[" + strconv.Itoa(index) + "]"
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultHookTimeout is how long a hook can run before it is cancelled
+const DefaultHookTimeout = 30 * time.Second
+
+// HookSpec is an action run after the address of a record changed, either a
+// local command or an HTTP request
+type HookSpec struct {
+	// Name identifies the hook in logs, defaults to its position in the list
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	Command *CommandHookSpec `json:"command,omitempty" yaml:"command,omitempty"`
+
+	HTTP *HTTPHookSpec `json:"http,omitempty" yaml:"http,omitempty"`
+
+	// OnFailure runs the hook when updating a record failed as well
+	OnFailure *bool `json:"onFailure,omitempty" yaml:"onFailure,omitempty"`
+
+	// Timeout is how long the hook can run before it is cancelled
+	Timeout *Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// CommandHookSpec runs a local command, the change is passed in MICRO_DDNS_*
+// environment variables and as JSON on stdin
+type CommandHookSpec struct {
+	// Path is the executable to run, looked up in PATH if it has no slash
+	Path string `json:"path" yaml:"path"`
+
+	Args []string `json:"args,omitempty" yaml:"args,omitempty"`
+}
+
+// HTTPHookSpec sends an HTTP request, the body is a Go template executed with the change
+type HTTPHookSpec struct {
+	URL string `json:"url" yaml:"url"`
+
+	// Method is the HTTP method, default is POST
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+
+	// Headers are added to the request, values accept secret references
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	// Body is a Go template of the request body, the change is sent as JSON if empty
+	Body string `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+func (spec *HookSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Command != nil && spec.HTTP != nil {
+		errs.addf("", "a hook can only have one of command or http")
+	} else if spec.Command == nil && spec.HTTP == nil {
+		errs.addf("", "a hook must have either command or http")
+	}
+
+	if spec.Command != nil {
+		errs.add("command", spec.Command.Validate())
+	}
+	if spec.HTTP != nil {
+		errs.add("http", spec.HTTP.Validate())
+	}
+
+	if spec.Timeout == nil {
+		timeout := Duration(DefaultHookTimeout)
+		spec.Timeout = &timeout
+	} else if *spec.Timeout <= 0 {
+		errs.addf("timeout", "timeout must be positive")
+	}
+	return errs.err()
+}
+
+func (spec *HookSpec) secrets(visit secretVisitor) {
+	if spec.HTTP != nil {
+		spec.HTTP.secrets(visit.within("http"))
+	}
+}
+
+// IsOnFailure reports if the hook runs when updating a record failed
+func (spec *HookSpec) IsOnFailure() bool {
+	return spec.OnFailure != nil && *spec.OnFailure
+}
+
+func (spec *CommandHookSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Path == "" {
+		errs.addf("path", "path cannot be empty")
+	}
+	return errs.err()
+}
+
+func (spec *HTTPHookSpec) secrets(visit secretVisitor) {
+	for name, value := range spec.Headers {
+		visit("headers."+name, &value)
+		spec.Headers[name] = value
+	}
+}
+
+func (spec *HTTPHookSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
+	if spec.URL == "" {
+		errs.addf("url", "url cannot be empty")
+	} else if u, err := url.Parse(spec.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.addf("url", "%s is not a valid HTTP URL", spec.URL)
+	}
+
+	if spec.Method == "" {
+		spec.Method = http.MethodPost
+	}
+	spec.Method = strings.ToUpper(spec.Method)
+
+	if spec.Body != "" {
+		if _, err := template.New("body").Parse(spec.Body); err != nil {
+			errs.addf("body", "body is not a valid template: %v", err)
+		}
+	}
+	return errs.err()
+}
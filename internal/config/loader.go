@@ -0,0 +1,280 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configLoader reads config files and fragments, following includes and
+// merging every ddns, detection and provider list into a single Config
+type configLoader struct {
+	merged *Config
+
+	// files is every file loaded so far in load order, used to avoid
+	// loading the same fragment twice
+	files  []string
+	loaded map[string]bool
+
+	// sources records the file each named spec was defined in
+	sources map[string]string
+
+	// origins records where each merged spec came from, indexed the same way
+	// as the merged lists, keyed by "ddns", "detection", "provider" and "notification"
+	origins map[string][]origin
+
+	// duplicates records every spec whose name was already taken, such specs
+	// are left out of merged so loading goes on and all of them are reported
+	duplicates FieldErrors
+}
+
+// origin is the location of a spec in its source file
+type origin struct {
+	file  string
+	index int
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		merged:  &Config{},
+		loaded:  make(map[string]bool),
+		sources: make(map[string]string),
+		origins: make(map[string][]origin),
+	}
+}
+
+// isConfigFile reports if name looks like a config fragment, hidden files are
+// skipped so the "..data" entries of Kubernetes volume mounts are ignored
+func isConfigFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func (l *configLoader) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return l.loadDir(path)
+	}
+	return l.loadFile(path)
+}
+
+func (l *configLoader) loadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !isConfigFile(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		files = append(files, path)
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no config file found in directory %s", dir)
+	}
+
+	sort.Strings(files)
+	for _, file := range files {
+		if err := l.loadFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *configLoader) loadFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+	l.files = append(l.files, abs)
+
+	fragment, err := readConfigFile(abs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	l.merge(path, fragment)
+
+	for _, include := range fragment.Include {
+		if err := l.loadInclude(filepath.Dir(abs), include); err != nil {
+			return fmt.Errorf("%s: include %s: %w", path, include, err)
+		}
+	}
+	return nil
+}
+
+func (l *configLoader) loadInclude(baseDir string, include string) error {
+	if include == "" {
+		return fmt.Errorf("include path cannot be empty")
+	}
+
+	pattern := include
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	// Glob ignores missing files, report them for plain paths
+	if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+		_, err := os.Stat(pattern)
+		return err
+	}
+
+	sort.Strings(matches)
+	for _, match := range matches {
+		if err := l.load(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claim records path as the file defining the spec at index of the kind
+// list, a spec whose name is already taken is recorded as a duplicate with
+// its location and false is returned
+func (l *configLoader) claim(kind string, name string, path string, index int) bool {
+	key := kind + "/" + name
+	previous, exists := l.sources[key]
+	if !exists {
+		l.sources[key] = path
+		return true
+	}
+
+	err := &FieldError{
+		Path: joinPath(indexPath(kind, index), "name"),
+		File: path,
+	}
+	if previous == path {
+		err.Err = fmt.Errorf("%s spec %s already exists", kind, name)
+	} else {
+		err.Err = fmt.Errorf("%s spec %s is defined in both %s and %s", kind, name, previous, path)
+	}
+	if node := parseNode(path); node != nil {
+		err.Line = lineOf(node, err.Path)
+	}
+	l.duplicates = append(l.duplicates, err)
+	return false
+}
+
+func (l *configLoader) merge(path string, fragment *Config) {
+	for i, spec := range fragment.DDNS {
+		if !l.claim("ddns", spec.Name, path, i) {
+			continue
+		}
+		l.merged.DDNS = append(l.merged.DDNS, spec)
+		l.origins["ddns"] = append(l.origins["ddns"], origin{file: path, index: i})
+	}
+
+	for i, spec := range fragment.Detection {
+		if !l.claim("detection", spec.Name, path, i) {
+			continue
+		}
+		l.merged.Detection = append(l.merged.Detection, spec)
+		l.origins["detection"] = append(l.origins["detection"], origin{file: path, index: i})
+	}
+
+	for i, spec := range fragment.Provider {
+		if !l.claim("provider", spec.Name, path, i) {
+			continue
+		}
+		l.merged.Provider = append(l.merged.Provider, spec)
+		l.origins["provider"] = append(l.origins["provider"], origin{file: path, index: i})
+	}
+
+	for i, spec := range fragment.Notification {
+		if !l.claim("notification", spec.Name, path, i) {
+			continue
+		}
+		l.merged.Notification = append(l.merged.Notification, spec)
+		l.origins["notification"] = append(l.origins["notification"], origin{file: path, index: i})
+	}
+}
+
+// readConfigFile decodes a single config file based on its extension
+func readConfigFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Always decode into a fresh value so a reload never inherits
+	// specs from the previous configuration
+	var config Config
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &config); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("config path points to an unknown file type")
+	}
+
+	return &config, nil
+}
+
+// listConfigFiles returns every file that makes up the configuration at path,
+// including fragments pulled in by includes, and the files referenced by secrets
+func listConfigFiles(path string) ([]string, []string, error) {
+	loader := newConfigLoader()
+	if err := loader.load(path); err != nil {
+		return nil, nil, err
+	}
+	return loader.files, loader.merged.SecretFiles(), nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigLoader(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		path          string
+		wantDDNS      []string
+		wantDetection []string
+		wantFiles     []string
+		wantErr       string
+
+		// wantDuplicates are the duplicates found as "file:line: path: message"
+		wantDuplicates []string
+	}{
+		{
+			name: "single file",
+			files: map[string]string{
+				"config.yaml": "ddns:\n  - name: home\ndetection:\n  - name: api\n",
+			},
+			path:          "config.yaml",
+			wantDDNS:      []string{"home"},
+			wantDetection: []string{"api"},
+			wantFiles:     []string{"config.yaml"},
+		},
+		{
+			name: "directory merged in name order",
+			files: map[string]string{
+				"conf/b.yaml":      "ddns:\n  - name: b\n",
+				"conf/a.yml":       "ddns:\n  - name: a\n",
+				"conf/c.json":      `{"ddns": [{"name": "c"}], "detection": [{"name": "api"}]}`,
+				"conf/.hidden.yml": "ddns:\n  - name: hidden\n",
+				"conf/notes.txt":   "not a config file",
+			},
+			path:          "conf",
+			wantDDNS:      []string{"a", "b", "c"},
+			wantDetection: []string{"api"},
+			wantFiles:     []string{"conf/a.yml", "conf/b.yaml", "conf/c.json"},
+		},
+		{
+			name: "includes are loaded once",
+			files: map[string]string{
+				"main.yaml":        "include: [common.yaml, 'conf.d/*.yaml', main.yaml]\nddns:\n  - name: main\n",
+				"common.yaml":      "detection:\n  - name: api\n",
+				"conf.d/b.yaml":    "include: [../common.yaml]\nddns:\n  - name: b\n",
+				"conf.d/a.yaml":    "ddns:\n  - name: a\n",
+				"conf.d/skip.json": `{"ddns": [{"name": "skip"}]}`,
+			},
+			path:          "main.yaml",
+			wantDDNS:      []string{"main", "a", "b"},
+			wantDetection: []string{"api"},
+			wantFiles:     []string{"main.yaml", "common.yaml", "conf.d/a.yaml", "conf.d/b.yaml"},
+		},
+		{
+			name: "duplicates in the same file",
+			files: map[string]string{
+				"config.yaml": "ddns:\n  - name: home\n  - name: home\n  - name: office\n  - name: home\n",
+			},
+			path:      "config.yaml",
+			wantDDNS:  []string{"home", "office"},
+			wantFiles: []string{"config.yaml"},
+			wantDuplicates: []string{
+				"config.yaml:3: ddns[1].name: ddns spec home already exists",
+				"config.yaml:5: ddns[3].name: ddns spec home already exists",
+			},
+		},
+		{
+			name: "duplicate across files",
+			files: map[string]string{
+				"conf/a.yaml": "detection:\n  - name: api\n",
+				"conf/b.yaml": "ddns:\n  - name: home\ndetection:\n  - name: api\n",
+			},
+			path:          "conf",
+			wantDDNS:      []string{"home"},
+			wantDetection: []string{"api"},
+			wantFiles:     []string{"conf/a.yaml", "conf/b.yaml"},
+			wantDuplicates: []string{
+				"conf/b.yaml:4: detection[0].name: detection spec api is defined in both",
+			},
+		},
+		{
+			name: "same name in different lists",
+			files: map[string]string{
+				"config.yaml": "ddns:\n  - name: home\ndetection:\n  - name: home\nprovider:\n  - name: home\n",
+			},
+			path:          "config.yaml",
+			wantDDNS:      []string{"home"},
+			wantDetection: []string{"home"},
+			wantFiles:     []string{"config.yaml"},
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"config.yaml": "include: [missing.yaml]\n",
+			},
+			path:    "config.yaml",
+			wantErr: "include missing.yaml",
+		},
+		{
+			name: "empty directory",
+			files: map[string]string{
+				"conf/README.md": "nothing here",
+			},
+			path:    "conf",
+			wantErr: "no config file found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, path, content)
+			}
+
+			loader := newConfigLoader()
+			err := loader.load(filepath.Join(dir, tt.path))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var ddns, detection []string
+			for _, spec := range loader.merged.DDNS {
+				ddns = append(ddns, spec.Name)
+			}
+			for _, spec := range loader.merged.Detection {
+				detection = append(detection, spec.Name)
+			}
+			if !reflect.DeepEqual(ddns, tt.wantDDNS) {
+				t.Errorf("expected ddns specs %v, got %v", tt.wantDDNS, ddns)
+			}
+			if !reflect.DeepEqual(detection, tt.wantDetection) {
+				t.Errorf("expected detection specs %v, got %v", tt.wantDetection, detection)
+			}
+
+			if len(loader.duplicates) != len(tt.wantDuplicates) {
+				t.Fatalf("expected %d duplicates, got %v", len(tt.wantDuplicates), loader.duplicates)
+			}
+			for i, duplicate := range loader.duplicates {
+				rel, err := filepath.Rel(dir, duplicate.File)
+				if err != nil {
+					t.Fatal(err)
+				}
+				located := *duplicate
+				located.File = filepath.ToSlash(rel)
+				if !strings.HasPrefix(located.Error(), tt.wantDuplicates[i]) {
+					t.Errorf("duplicate %d: expected %q, got %q", i, tt.wantDuplicates[i], located.Error())
+				}
+			}
+
+			var files []string
+			for _, file := range loader.files {
+				rel, err := filepath.Rel(dir, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("expected files %v, got %v", tt.wantFiles, files)
+			}
+
+			// Every merged spec points back to its position in its own file
+			if len(loader.origins["ddns"]) != len(ddns) {
+				t.Fatalf("expected %d ddns origins, got %d", len(ddns), len(loader.origins["ddns"]))
+			}
+			for i, o := range loader.origins["ddns"] {
+				fragment, err := readConfigFile(o.file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := fragment.DDNS[o.index].Name; got != ddns[i] {
+					t.Errorf("ddns spec %d: expected origin of %s, got %s", i, ddns[i], got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type NotificationEvent string
+
+const (
+	// NotifyChanged is sent when records were created or updated
+	NotifyChanged NotificationEvent = "changed"
+
+	// NotifyFailed is sent when updating records failed
+	NotifyFailed NotificationEvent = "failed"
+
+	// NotifyRecovered is sent when records were updated again after failing
+	NotifyRecovered NotificationEvent = "recovered"
+
+	// NotifyDigest is the daily summary of a notifier in digest mode, it is
+	// sent by the notifier itself and cannot be subscribed to
+	NotifyDigest NotificationEvent = "digest"
+)
+
+// AllNotificationEvents is every event a subscription gets by default
+var AllNotificationEvents = []NotificationEvent{NotifyChanged, NotifyFailed, NotifyRecovered}
+
+type NotifierType string
+
+const (
+	NotifierSlack    NotifierType = "Slack"
+	NotifierDiscord  NotifierType = "Discord"
+	NotifierTelegram NotifierType = "Telegram"
+	NotifierWebhook  NotifierType = "Webhook"
+	NotifierEmail    NotifierType = "Email"
+)
+
+type SMTPSecurity string
+
+const (
+	// SMTPStartTLS upgrades a plain connection with the STARTTLS command
+	SMTPStartTLS SMTPSecurity = "startTLS"
+
+	// SMTPImplicitTLS connects with TLS from the start, usually on port 465
+	SMTPImplicitTLS SMTPSecurity = "implicitTLS"
+
+	// SMTPNone sends mail without encryption, only meant for local relays
+	SMTPNone SMTPSecurity = "none"
+)
+
+// NotifierSpec is a named destination notifications are sent to
+type NotifierSpec struct {
+	Name string `json:"name" yaml:"name"`
+
+	Slack *SlackNotifierSpec `json:"slack,omitempty" yaml:"slack,omitempty"`
+
+	Discord *DiscordNotifierSpec `json:"discord,omitempty" yaml:"discord,omitempty"`
+
+	Telegram *TelegramNotifierSpec `json:"telegram,omitempty" yaml:"telegram,omitempty"`
+
+	Webhook *WebhookNotifierSpec `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+
+	Email *EmailNotifierSpec `json:"email,omitempty" yaml:"email,omitempty"`
+
+	// Digest replaces single notifications with a daily summary of every
+	// DDNS spec subscribed to this notifier
+	Digest *DigestSpec `json:"digest,omitempty" yaml:"digest,omitempty"`
+
+	notifierType NotifierType
+}
+
+// SlackNotifierSpec posts to a Slack incoming webhook
+type SlackNotifierSpec struct {
+	WebhookURL string `json:"webhookUrl" yaml:"webhookUrl"`
+}
+
+// DiscordNotifierSpec posts to a Discord channel webhook
+type DiscordNotifierSpec struct {
+	WebhookURL string `json:"webhookUrl" yaml:"webhookUrl"`
+}
+
+// TelegramNotifierSpec sends messages with the Telegram bot API
+type TelegramNotifierSpec struct {
+	BotToken string `json:"botToken" yaml:"botToken"`
+
+	ChatID ChatID `json:"chatId" yaml:"chatId"`
+
+	// APIURL is the address of the bot API, default is https://api.telegram.org
+	APIURL *string `json:"apiUrl,omitempty" yaml:"apiUrl,omitempty"`
+}
+
+// ChatID is a Telegram chat ID, written as a number like -1001234567890 or
+// as a string like @channelname
+type ChatID string
+
+func (id *ChatID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = ChatID(s)
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("chatId must be an integer or a string")
+	}
+	*id = ChatID(strconv.FormatInt(n, 10))
+	return nil
+}
+
+func (id *ChatID) UnmarshalYAML(node *yaml.Node) error {
+	switch {
+	case node.Kind == yaml.ScalarNode && node.ShortTag() == "!!int":
+		var n int64
+		if err := node.Decode(&n); err != nil {
+			return fmt.Errorf("line %d: chatId must be an integer or a string", node.Line)
+		}
+		*id = ChatID(strconv.FormatInt(n, 10))
+	case node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str":
+		*id = ChatID(node.Value)
+	default:
+		return fmt.Errorf("line %d: chatId must be an integer or a string", node.Line)
+	}
+	return nil
+}
+
+// WebhookNotifierSpec posts every notification as JSON to a URL
+type WebhookNotifierSpec struct {
+	URL string `json:"url" yaml:"url"`
+
+	// Headers are added to the request, values accept secret references
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// EmailNotifierSpec sends mail through an SMTP server
+type EmailNotifierSpec struct {
+	Host string `json:"host" yaml:"host"`
+
+	// Port defaults to 587 for startTLS, 465 for implicitTLS and 25 for none
+	Port *int `json:"port,omitempty" yaml:"port,omitempty"`
+
+	// Security is how the connection is encrypted, default is startTLS
+	Security *SMTPSecurity `json:"security,omitempty" yaml:"security,omitempty"`
+
+	// Username and Password are used for PLAIN authentication, leave them
+	// empty if the server accepts mail without authentication
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	From string   `json:"from" yaml:"from"`
+	To   []string `json:"to" yaml:"to"`
+}
+
+func (spec *EmailNotifierSpec) secrets(visit secretVisitor) {
+	if spec.Username != nil {
+		visit("username", spec.Username)
+	}
+	if spec.Password != nil {
+		visit("password", spec.Password)
+	}
+}
+
+func (spec *EmailNotifierSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Host == "" {
+		errs.addf("host", "host cannot be empty")
+	}
+
+	if spec.Security == nil {
+		security := SMTPStartTLS
+		spec.Security = &security
+	}
+
+	port := 0
+	switch *spec.Security {
+	case SMTPStartTLS:
+		port = 587
+	case SMTPImplicitTLS:
+		port = 465
+	case SMTPNone:
+		port = 25
+	default:
+		errs.addf("security", "unknown security %s, must be one of startTLS, implicitTLS or none", *spec.Security)
+	}
+	if spec.Port == nil {
+		spec.Port = &port
+	} else if *spec.Port < 1 || *spec.Port > 65535 {
+		errs.addf("port", "port must be between 1 and 65535")
+	}
+
+	spec.secrets(errs.checkSecret)
+	if (spec.Username == nil) != (spec.Password == nil) {
+		errs.addf("", "username and password must be specified together")
+	}
+
+	if _, err := mail.ParseAddress(spec.From); err != nil {
+		errs.addf("from", "%s is not a valid email address: %v", spec.From, err)
+	}
+	if len(spec.To) == 0 {
+		errs.addf("to", "at least 1 recipient is needed")
+	}
+	for i, to := range spec.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			errs.addf(indexPath("to", i), "%s is not a valid email address: %v", to, err)
+		}
+	}
+	return errs.err()
+}
+
+// DigestSpec is the schedule of the daily summary of a notifier
+type DigestSpec struct {
+	// At is the local time of day the digest is sent, like 08:00
+	At string `json:"at" yaml:"at"`
+
+	at time.Time
+}
+
+func (spec *DigestSpec) Validate() error {
+	var errs FieldErrors
+	at, err := time.Parse("15:04", spec.At)
+	if err != nil {
+		errs.addf("at", "%s is not a valid time of day, must be in HH:MM format", spec.At)
+	}
+	spec.at = at
+	return errs.err()
+}
+
+// GetTime returns the hour and minute the digest is sent at
+func (spec *DigestSpec) GetTime() (int, int) {
+	return spec.at.Hour(), spec.at.Minute()
+}
+
+// validateHTTPURL reports if value is an absolute HTTP or HTTPS URL
+func validateHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (spec *NotifierSpec) secrets(visit secretVisitor) {
+	if spec.Slack != nil {
+		visit("slack.webhookUrl", &spec.Slack.WebhookURL)
+	}
+	if spec.Discord != nil {
+		visit("discord.webhookUrl", &spec.Discord.WebhookURL)
+	}
+	if spec.Telegram != nil {
+		visit("telegram.botToken", &spec.Telegram.BotToken)
+	}
+	if spec.Webhook != nil {
+		for name, value := range spec.Webhook.Headers {
+			visit("webhook.headers."+name, &value)
+			spec.Webhook.Headers[name] = value
+		}
+	}
+	if spec.Email != nil {
+		spec.Email.secrets(visit.within("email"))
+	}
+}
+
+// validateURLs checks the webhook URLs, a URL given as a secret reference is
+// only checked after it was resolved
+func (spec *NotifierSpec) validateURLs() error {
+	var errs FieldErrors
+	if spec.Slack != nil && !isSecretReference(spec.Slack.WebhookURL) && !validateHTTPURL(spec.Slack.WebhookURL) {
+		errs.addf("slack.webhookUrl", "webhookUrl must be an HTTP URL")
+	}
+	if spec.Discord != nil && !isSecretReference(spec.Discord.WebhookURL) && !validateHTTPURL(spec.Discord.WebhookURL) {
+		errs.addf("discord.webhookUrl", "webhookUrl must be an HTTP URL")
+	}
+	return errs.err()
+}
+
+func (spec *NotifierSpec) Validate() error {
+	var errs FieldErrors
+	if spec.Name == "" {
+		errs.addf("name", "name is needed for a notifier spec")
+	}
+
+	count := 0
+	if spec.Slack != nil {
+		spec.notifierType = NotifierSlack
+		errs.checkSecret("slack.webhookUrl", &spec.Slack.WebhookURL)
+		count++
+	}
+
+	if spec.Discord != nil {
+		spec.notifierType = NotifierDiscord
+		errs.checkSecret("discord.webhookUrl", &spec.Discord.WebhookURL)
+		count++
+	}
+
+	if spec.Telegram != nil {
+		spec.notifierType = NotifierTelegram
+		errs.checkSecret("telegram.botToken", &spec.Telegram.BotToken)
+		if spec.Telegram.BotToken == "" {
+			errs.addf("telegram.botToken", "botToken cannot be empty")
+		}
+		if spec.Telegram.ChatID == "" {
+			errs.addf("telegram.chatId", "chatId cannot be empty")
+		}
+		if spec.Telegram.APIURL != nil && !validateHTTPURL(*spec.Telegram.APIURL) {
+			errs.addf("telegram.apiUrl", "%s is not a valid HTTP URL", *spec.Telegram.APIURL)
+		}
+		count++
+	}
+
+	if spec.Webhook != nil {
+		spec.notifierType = NotifierWebhook
+		for name, value := range spec.Webhook.Headers {
+			errs.checkSecret("webhook.headers."+name, &value)
+		}
+		if !validateHTTPURL(spec.Webhook.URL) {
+			errs.addf("webhook.url", "url must be an HTTP URL")
+		}
+		count++
+	}
+
+	if spec.Email != nil {
+		spec.notifierType = NotifierEmail
+		errs.add("email", spec.Email.Validate())
+		count++
+	}
+
+	errs.add("", spec.validateURLs())
+
+	if spec.Digest != nil {
+		errs.add("digest", spec.Digest.Validate())
+	}
+
+	if count == 0 {
+		errs.addf("", "no notifier specified")
+	} else if count > 1 {
+		errs.addf("", "only 1 notifier can be specified for each notifier spec")
+	}
+	return errs.err()
+}
+
+func (spec *NotifierSpec) GetType() NotifierType {
+	return spec.notifierType
+}
+
+// SubscriptionSpec sends some events of a DDNS spec to a notifier, or adds
+// its records to the digest of a notifier in digest mode
+type SubscriptionSpec struct {
+	// NotifierRef is the name of a notifier spec defined in notification
+	NotifierRef string `json:"notifierRef" yaml:"notifierRef"`
+
+	// Events are the events sent to the notifier, default is every event
+	Events []NotificationEvent `json:"events,omitempty" yaml:"events,omitempty"`
+
+	// RepeatInterval is how long until a failure that persists is sent
+	// again, it is only sent once until recovered if empty
+	RepeatInterval *Duration `json:"repeatInterval,omitempty" yaml:"repeatInterval,omitempty"`
+
+	notifierSpec *NotifierSpec
+}
+
+func (spec *SubscriptionSpec) Validate() error {
+	var errs FieldErrors
+	if spec.NotifierRef == "" {
+		errs.addf("notifierRef", "notifierRef cannot be empty")
+	}
+
+	if len(spec.Events) == 0 {
+		spec.Events = append([]NotificationEvent(nil), AllNotificationEvents...)
+	}
+	for i, event := range spec.Events {
+		if event != NotifyChanged && event != NotifyFailed && event != NotifyRecovered {
+			errs.addf(indexPath("events", i), "unknown event %s, must be one of changed, failed or recovered", event)
+		}
+	}
+
+	if spec.RepeatInterval != nil && *spec.RepeatInterval < 0 {
+		errs.addf("repeatInterval", "repeatInterval cannot be negative")
+	}
+	return errs.err()
+}
+
+// Wants reports if event is sent to the notifier
+func (spec *SubscriptionSpec) Wants(event NotificationEvent) bool {
+	for _, e := range spec.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *SubscriptionSpec) GetNotifierSpec() *NotifierSpec {
+	return spec.notifierSpec
+}
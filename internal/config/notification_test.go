@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestChatIDUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		want    ChatID
+		wantErr bool
+	}{
+		{name: "yaml integer", format: "yaml", content: "chatId: -1001234567890", want: "-1001234567890"},
+		{name: "yaml quoted integer", format: "yaml", content: `chatId: "-1001234567890"`, want: "-1001234567890"},
+		{name: "yaml username", format: "yaml", content: "chatId: '@channel'", want: "@channel"},
+		{name: "yaml float", format: "yaml", content: "chatId: 1.5", wantErr: true},
+		{name: "yaml list", format: "yaml", content: "chatId: [1]", wantErr: true},
+		{name: "json integer", format: "json", content: `{"chatId": -1001234567890}`, want: "-1001234567890"},
+		{name: "json string", format: "json", content: `{"chatId": "@channel"}`, want: "@channel"},
+		{name: "json float", format: "json", content: `{"chatId": 1.5}`, wantErr: true},
+		{name: "json boolean", format: "json", content: `{"chatId": true}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec TelegramNotifierSpec
+			var err error
+			if tt.format == "json" {
+				err = json.Unmarshal([]byte(tt.content), &spec)
+			} else {
+				err = yaml.Unmarshal([]byte(tt.content), &spec)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got chatId %q", spec.ChatID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if spec.ChatID != tt.want {
+				t.Errorf("expected chatId %q, got %q", tt.want, spec.ChatID)
+			}
+		})
+	}
+}
@@ -16,8 +16,6 @@ limitations under the License.
 
 package config
 
-import "fmt"
-
 // AliCloudSpec is the information of AliCloud API credential and extra settings
 type AliCloudSpec struct {
 	// AccessKeyID is the AccessKey of the account
@@ -30,16 +28,24 @@ type AliCloudSpec struct {
 	Line *string `json:"line,omitempty" yaml:"line,omitempty"`
 }
 
+func (spec *AliCloudSpec) secrets(visit secretVisitor) {
+	visit("accessKeyId", &spec.AccessKeyID)
+	visit("accessKeySecret", &spec.AccessKeySecret)
+}
+
 func (spec *AliCloudSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.AccessKeyID == "" {
-		return fmt.Errorf("AccessKeyID cannot be empty")
+		errs.addf("accessKeyId", "AccessKeyID cannot be empty")
 	}
 
 	if spec.AccessKeySecret == "" {
-		return fmt.Errorf("AccessKeySecret cannot be empty")
+		errs.addf("accessKeySecret", "AccessKeySecret cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
 
 // CloudflareSpec is the information of Cloudflare API credential
@@ -54,18 +60,34 @@ type CloudflareSpec struct {
 	Email *string `json:"email,omitempty" yaml:"email,omitempty"`
 }
 
+func (spec *CloudflareSpec) secrets(visit secretVisitor) {
+	if spec.APIToken != nil {
+		visit("apiToken", spec.APIToken)
+	}
+	if spec.GlobalAPIKey != nil {
+		visit("globalApiKey", spec.GlobalAPIKey)
+	}
+	if spec.Email != nil {
+		visit("email", spec.Email)
+	}
+}
+
 func (spec *CloudflareSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+	if len(errs) > 0 {
+		return errs
+	}
+
 	if spec.APIToken == nil || *spec.APIToken == "" {
 		if spec.GlobalAPIKey == nil || *spec.GlobalAPIKey == "" {
-			return fmt.Errorf("must choose between api token or global api key with email")
-		}
-
-		if spec.Email == nil || *spec.Email == "" {
-			return fmt.Errorf("must choose between api token or global api key with email")
+			errs.addf("", "must choose between api token or global api key with email")
+		} else if spec.Email == nil || *spec.Email == "" {
+			errs.addf("email", "must choose between api token or global api key with email")
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 // DNSPodSpec is the information of Tencent DNSPod API credential and extra settings
@@ -80,16 +102,24 @@ type DNSPodSpec struct {
 	LineID *string `json:"lineId,omitempty" yaml:"lineId,omitempty"`
 }
 
+func (spec *DNSPodSpec) secrets(visit secretVisitor) {
+	visit("secretId", &spec.SecretID)
+	visit("secretKey", &spec.SecretKey)
+}
+
 func (spec *DNSPodSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.SecretID == "" {
-		return fmt.Errorf("SecretID cannot be empty")
+		errs.addf("secretId", "SecretID cannot be empty")
 	}
 
 	if spec.SecretKey == "" {
-		return fmt.Errorf("SecretKey cannot be empty")
+		errs.addf("secretKey", "SecretKey cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
 
 // HuaweiCloudSpec is the information of Huawei Cloud credential and settings
@@ -104,20 +134,28 @@ type HuaweiCloudSpec struct {
 	Region string `json:"region" yaml:"region"`
 }
 
+func (spec *HuaweiCloudSpec) secrets(visit secretVisitor) {
+	visit("accessKey", &spec.AccessKey)
+	visit("secretAccessKey", &spec.SecretAccessKey)
+}
+
 func (spec *HuaweiCloudSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.AccessKey == "" {
-		return fmt.Errorf("AcessKey cannot be empty")
+		errs.addf("accessKey", "AcessKey cannot be empty")
 	}
 
 	if spec.SecretAccessKey == "" {
-		return fmt.Errorf("SecretAccessKey cannot be empty")
+		errs.addf("secretAccessKey", "SecretAccessKey cannot be empty")
 	}
 
 	if spec.Region == "" {
-		return fmt.Errorf("region cannot be empty")
+		errs.addf("region", "region cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
 
 // JDCloudSpec is the information of JDCloud credential and settings
@@ -132,16 +170,24 @@ type JDCloudSpec struct {
 	ViewID *int `json:"viewId,omitempty" yaml:"viewId,omitempty"`
 }
 
+func (spec *JDCloudSpec) secrets(visit secretVisitor) {
+	visit("accessKey", &spec.AccessKey)
+	visit("secretKey", &spec.SecretKey)
+}
+
 func (spec *JDCloudSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.AccessKey == "" {
-		return fmt.Errorf("AccessKey cannot be empty")
+		errs.addf("accessKey", "AccessKey cannot be empty")
 	}
 
 	if spec.SecretKey == "" {
-		return fmt.Errorf("SecretKey cannot be empty")
+		errs.addf("secretKey", "SecretKey cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
 
 // RFC2136Spec is the information about an RFC 2136 compliant DNS server
@@ -160,22 +206,31 @@ type RFC2136Spec struct {
 	GSSTSIG *GSSTSIGSpec `json:"gssTsig,omitempty" yaml:"gssTsig,omitempty"`
 }
 
+func (spec *RFC2136Spec) secrets(visit secretVisitor) {
+	if spec.TSIG != nil {
+		spec.TSIG.secrets(visit.within("tsig"))
+	} else if spec.GSSTSIG != nil {
+		spec.GSSTSIG.secrets(visit.within("gssTsig"))
+	}
+}
+
 func (spec *RFC2136Spec) Validate() error {
+	var errs FieldErrors
 	if spec.Address == "" {
-		return fmt.Errorf("address cannot be empty")
+		errs.addf("address", "address cannot be empty")
 	}
 
 	if spec.Port != nil && (*spec.Port < 1 || *spec.Port > 65535) {
-		return fmt.Errorf("port %d is invalid", *spec.Port)
+		errs.addf("port", "port %d is invalid", *spec.Port)
 	}
 
 	if spec.TSIG != nil {
-		return spec.TSIG.Validate()
+		errs.add("tsig", spec.TSIG.Validate())
 	} else if spec.GSSTSIG != nil {
-		return spec.GSSTSIG.Validate()
+		errs.add("gssTsig", spec.GSSTSIG.Validate())
 	}
 
-	return nil
+	return errs.err()
 }
 
 // TSIGSpec is the information about TSIG authentication
@@ -187,16 +242,24 @@ type TSIGSpec struct {
 	Key string `json:"key,omitempty" yaml:"key,omitempty"`
 }
 
+func (spec *TSIGSpec) secrets(visit secretVisitor) {
+	visit("keyName", &spec.KeyName)
+	visit("key", &spec.Key)
+}
+
 func (spec *TSIGSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.KeyName == "" {
-		return fmt.Errorf("key name cannot be empty")
+		errs.addf("keyName", "key name cannot be empty")
 	}
 
 	if spec.Key == "" {
-		return fmt.Errorf("key cannot be empty")
+		errs.addf("key", "key cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
 
 type GSSTSIGSpec struct {
@@ -210,18 +273,26 @@ type GSSTSIGSpec struct {
 	Password string `json:"password" yaml:"password"`
 }
 
+func (spec *GSSTSIGSpec) secrets(visit secretVisitor) {
+	visit("username", &spec.Username)
+	visit("password", &spec.Password)
+}
+
 func (spec *GSSTSIGSpec) Validate() error {
+	var errs FieldErrors
+	spec.secrets(errs.checkSecret)
+
 	if spec.Domain == "" {
-		return fmt.Errorf("domain cannot be empty")
+		errs.addf("domain", "domain cannot be empty")
 	}
 
 	if spec.Username == "" {
-		return fmt.Errorf("username cannot be empty")
+		errs.addf("username", "username cannot be empty")
 	}
 
 	if spec.Password == "" {
-		return fmt.Errorf("password cannot be empty")
+		errs.addf("password", "password cannot be empty")
 	}
 
-	return nil
+	return errs.err()
 }
@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "time"
+
+const (
+	DefaultRetryMaxAttempts    = 3
+	DefaultRetryInitialBackoff = 2 * time.Second
+	DefaultRetryMaxBackoff     = 30 * time.Second
+	DefaultRetryJitter         = 0.2
+)
+
+// RetrySpec defines how failed address detections and DNS provider calls are
+// retried before giving up until the next scheduled run
+type RetrySpec struct {
+	// MaxAttempts is the number of attempts including the first one, use 1 to disable retrying
+	MaxAttempts *int `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+
+	// InitialBackoff is the wait before the first retry, doubled after every attempt
+	InitialBackoff *Duration `json:"initialBackoff,omitempty" yaml:"initialBackoff,omitempty"`
+
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff *Duration `json:"maxBackoff,omitempty" yaml:"maxBackoff,omitempty"`
+
+	// Jitter randomizes each wait by up to this fraction of it, between 0 and 1
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+// NewDefaultRetrySpec returns the retry policy used when a DDNS spec has none
+func NewDefaultRetrySpec() *RetrySpec {
+	spec := &RetrySpec{}
+	spec.setDefaults()
+	return spec
+}
+
+func (spec *RetrySpec) setDefaults() {
+	if spec.MaxAttempts == nil {
+		maxAttempts := DefaultRetryMaxAttempts
+		spec.MaxAttempts = &maxAttempts
+	}
+	if spec.InitialBackoff == nil {
+		initialBackoff := Duration(DefaultRetryInitialBackoff)
+		spec.InitialBackoff = &initialBackoff
+	}
+	if spec.MaxBackoff == nil {
+		maxBackoff := Duration(DefaultRetryMaxBackoff)
+		spec.MaxBackoff = &maxBackoff
+	}
+	if spec.Jitter == nil {
+		jitter := DefaultRetryJitter
+		spec.Jitter = &jitter
+	}
+}
+
+func (spec *RetrySpec) Validate() error {
+	var errs FieldErrors
+	spec.setDefaults()
+
+	if *spec.MaxAttempts < 1 {
+		errs.addf("maxAttempts", "maxAttempts must be at least 1")
+	}
+
+	if *spec.InitialBackoff <= 0 {
+		errs.addf("initialBackoff", "initialBackoff must be positive")
+	}
+
+	if *spec.MaxBackoff < *spec.InitialBackoff {
+		errs.addf("maxBackoff", "maxBackoff cannot be shorter than initialBackoff")
+	}
+
+	if *spec.Jitter < 0 || *spec.Jitter > 1 {
+		errs.addf("jitter", "jitter must be between 0 and 1")
+	}
+	return errs.err()
+}
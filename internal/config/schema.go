@@ -0,0 +1,332 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEnums lists the allowed values of string types
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(NetworkStack("")):       {string(IPv4), string(IPv6), string(DualStack)},
+	reflect.TypeOf(LocalAddressPolicy("")): {string(LocalAddressPolicyIgnore), string(LocalAddressPolicyAllow), string(LocalAddressPolicyPrefer)},
+	reflect.TypeOf(DetectionStrategy("")):  {string(DetectionFirstSuccess), string(DetectionQuorum)},
+	reflect.TypeOf(NotificationEvent("")):  {string(NotifyChanged), string(NotifyFailed), string(NotifyRecovered)},
+	reflect.TypeOf(SMTPSecurity("")):       {string(SMTPStartTLS), string(SMTPImplicitTLS), string(SMTPNone)},
+}
+
+// schemaTypes overrides the schema of types with custom encodings
+var schemaTypes = map[reflect.Type]map[string]interface{}{
+	reflect.TypeOf(Duration(0)): {"type": "string", "pattern": durationPattern},
+	reflect.TypeOf(ChatID("")):  {"type": []string{"string", "integer"}},
+}
+
+// schemaRules adds the rules enforced by Validate methods that cannot be
+// derived from struct fields alone
+var schemaRules = map[reflect.Type]func(schema map[string]interface{}) error{
+	reflect.TypeOf(Config{}): func(schema map[string]interface{}) error {
+		// Names are optional for inline specs but required in top level lists
+		for _, list := range []string{"detection", "provider"} {
+			items, err := schemaProperty(schema, list)
+			if err != nil {
+				return err
+			}
+			items["items"] = map[string]interface{}{
+				"allOf": []interface{}{
+					items["items"],
+					map[string]interface{}{"required": []string{"name"}},
+				},
+			}
+		}
+		return nil
+	},
+	reflect.TypeOf(DDNSSpec{}): func(schema map[string]interface{}) error {
+		// A single schedule, and either a reference or an inline spec
+		schema["allOf"] = []interface{}{
+			map[string]interface{}{"anyOf": requiredEach("subdomain", "subdomains")},
+			map[string]interface{}{"oneOf": requiredEach("cron", "interval")},
+			map[string]interface{}{"oneOf": requiredEach("providerRef", "provider")},
+			map[string]interface{}{"oneOf": requiredEach("detectionRef", "detection", "detectionRefs")},
+		}
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"detectionRefs": {"minItems": 1},
+		})
+	},
+	reflect.TypeOf(DNSProviderSpec{}): func(schema map[string]interface{}) error {
+		removeRequired(schema, "name")
+		// Exactly 1 provider per spec
+		schema["oneOf"] = requiredEach("cloudflare", "alicloud", "dnspod", "huawei", "jd", "rfc2136")
+		return nil
+	},
+	reflect.TypeOf(AddressDetectionSpec{}): func(schema map[string]interface{}) error {
+		removeRequired(schema, "name")
+		schema["anyOf"] = requiredEach("interface", "api")
+		return nil
+	},
+	reflect.TypeOf(CloudflareSpec{}): func(schema map[string]interface{}) error {
+		schema["anyOf"] = []interface{}{
+			map[string]interface{}{"required": []string{"apiToken"}},
+			map[string]interface{}{"required": []string{"globalApiKey", "email"}},
+		}
+		return nil
+	},
+	reflect.TypeOf(RFC2136Spec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"port": {"minimum": 1, "maximum": 65535},
+		})
+	},
+	reflect.TypeOf(RetrySpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"maxAttempts": {"minimum": 1},
+			"jitter":      {"minimum": 0, "maximum": 1},
+		})
+	},
+	reflect.TypeOf(CircuitBreakerSpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"failureThreshold": {"minimum": 0},
+		})
+	},
+	reflect.TypeOf(ProviderLimitsSpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"requestsPerSecond": {"minimum": 0},
+			"burst":             {"minimum": 1},
+			"maxConcurrency":    {"minimum": 1},
+		})
+	},
+	reflect.TypeOf(DetectionStrategySpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"quorum":       {"minimum": 1},
+			"fallbackIPv4": {"format": "ipv4"},
+			"fallbackIPv6": {"format": "ipv6"},
+		})
+	},
+	reflect.TypeOf(NotifierSpec{}): func(schema map[string]interface{}) error {
+		schema["oneOf"] = requiredEach("slack", "discord", "telegram", "webhook", "email")
+		return nil
+	},
+	reflect.TypeOf(EmailNotifierSpec{}): func(schema map[string]interface{}) error {
+		required, _ := schema["required"].([]string)
+		schema["required"] = append(required, "to")
+		schema["dependentRequired"] = map[string]interface{}{
+			"username": []string{"password"},
+			"password": []string{"username"},
+		}
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"port": {"minimum": 1, "maximum": 65535},
+			"to":   {"minItems": 1},
+		})
+	},
+	reflect.TypeOf(DigestSpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"at": {"pattern": `^([01][0-9]|2[0-3]):[0-5][0-9]$`},
+		})
+	},
+	reflect.TypeOf(HookSpec{}): func(schema map[string]interface{}) error {
+		schema["oneOf"] = requiredEach("command", "http")
+		return nil
+	},
+	reflect.TypeOf(HTTPHookSpec{}): func(schema map[string]interface{}) error {
+		return setSchemaKeywords(schema, map[string]map[string]interface{}{
+			"url": {"format": "uri"},
+		})
+	},
+	reflect.TypeOf(TSIGSpec{}): func(schema map[string]interface{}) error {
+		schema["required"] = []string{"keyName", "key"}
+		return nil
+	},
+}
+
+// schemaProperty returns the schema of the property name, failing instead
+// of panicking when a field was renamed or its tag changed
+func schemaProperty(schema map[string]interface{}, name string) (map[string]interface{}, error) {
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema has no properties")
+	}
+
+	property, ok := properties[name].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema has no property %s", name)
+	}
+	return property, nil
+}
+
+// setSchemaKeywords adds keywords to the schema of each listed property
+func setSchemaKeywords(schema map[string]interface{}, keywords map[string]map[string]interface{}) error {
+	for name, values := range keywords {
+		property, err := schemaProperty(schema, name)
+		if err != nil {
+			return err
+		}
+		for keyword, value := range values {
+			property[keyword] = value
+		}
+	}
+	return nil
+}
+
+func removeRequired(schema map[string]interface{}, field string) {
+	required, ok := schema["required"].([]string)
+	if !ok {
+		return
+	}
+
+	kept := make([]string, 0, len(required))
+	for _, name := range required {
+		if name != field {
+			kept = append(kept, name)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(schema, "required")
+		return
+	}
+	schema["required"] = kept
+}
+
+func requiredEach(fields ...string) []interface{} {
+	list := make([]interface{}, len(fields))
+	for i, field := range fields {
+		list[i] = map[string]interface{}{"required": []string{field}}
+	}
+	return list
+}
+
+// Schema returns the JSON Schema of the config file format, generated from the
+// config structs so it always matches what the loader understands
+func Schema() (map[string]interface{}, error) {
+	defs := make(map[string]interface{})
+	root, err := structSchema(reflect.TypeOf(Config{}), defs)
+	if err != nil {
+		return nil, err
+	}
+	root["$schema"] = schemaDraft
+	root["title"] = "micro-ddns configuration"
+	root["$defs"] = defs
+	return root, nil
+}
+
+func typeSchema(t reflect.Type, defs map[string]interface{}) (map[string]interface{}, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if schema, ok := schemaTypes[t]; ok {
+		return schema, nil
+	}
+
+	if enum, ok := schemaEnums[t]; ok {
+		return map[string]interface{}{
+			"type": "string",
+			"enum": enum,
+		}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": items,
+		}, nil
+	case reflect.Map:
+		values, err := typeSchema(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": values,
+		}, nil
+	case reflect.Struct:
+		if _, exists := defs[t.Name()]; !exists {
+			// Reserve the name first so recursive types terminate
+			defs[t.Name()] = nil
+			schema, err := structSchema(t, defs)
+			if err != nil {
+				return nil, err
+			}
+			defs[t.Name()] = schema
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) (map[string]interface{}, error) {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property, err := typeSchema(field.Type, defs)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = property
+
+		// Lists are never required so a config fragment can hold any of them
+		kind := field.Type.Kind()
+		if kind != reflect.Pointer && kind != reflect.Slice && kind != reflect.Map && !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if rule, ok := schemaRules[t]; ok {
+		if err := rule(schema); err != nil {
+			return nil, fmt.Errorf("schema of %s: %w", t.Name(), err)
+		}
+	}
+	return schema, nil
+}
@@ -0,0 +1,334 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaValidator checks a document against the subset of JSON Schema
+// generated by Schema
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+// toJSON converts v to the generic values produced by encoding/json
+func toJSON(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(content, &generic); err != nil {
+		t.Fatal(err)
+	}
+	return generic
+}
+
+func newSchemaValidator(t *testing.T) *schemaValidator {
+	t.Helper()
+	schema, err := Schema()
+	if err != nil {
+		t.Fatalf("failed to generate schema: %v", err)
+	}
+	return &schemaValidator{root: toJSON(t, schema).(map[string]interface{})}
+}
+
+func isJSONType(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return name == "object"
+	case []interface{}:
+		return name == "array"
+	case string:
+		return name == "string"
+	case bool:
+		return name == "boolean"
+	case float64:
+		return name == "number" || (name == "integer" && v == float64(int64(v)))
+	}
+	return false
+}
+
+// validate returns every problem of value, an empty list when it matches schema
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) []string {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		def, ok := v.root["$defs"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			fail("unknown reference %s", ref)
+			return problems
+		}
+		return v.validate(def, value, path)
+	}
+
+	switch types := schema["type"].(type) {
+	case string:
+		if !isJSONType(value, types) {
+			fail("expected %s, got %v", types, value)
+			return problems
+		}
+	case []interface{}:
+		matched := false
+		for _, name := range types {
+			matched = matched || isJSONType(value, name.(string))
+		}
+		if !matched {
+			fail("expected one of %v, got %v", types, value)
+			return problems
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, ok := value.(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			fail("%q does not match %s", s, pattern)
+		}
+	}
+
+	if number, ok := value.(float64); ok {
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			fail("%v is less than %v", number, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && number > maximum {
+			fail("%v is greater than %v", number, maximum)
+		}
+	}
+
+	if items, ok := value.([]interface{}); ok {
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(items)) < minItems {
+			fail("expected at least %v items", minItems)
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range items {
+				problems = append(problems, v.validate(itemSchema, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range object {
+			if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, v.validate(propertySchema, property, joinPath(path, name))...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unknown property %s", name)
+				}
+			case map[string]interface{}:
+				problems = append(problems, v.validate(additional, property, joinPath(path, name))...)
+			}
+		}
+
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+
+		dependentRequired, _ := schema["dependentRequired"].(map[string]interface{})
+		for name, dependencies := range dependentRequired {
+			if _, ok := object[name]; !ok {
+				continue
+			}
+			for _, dependency := range dependencies.([]interface{}) {
+				if _, ok := object[dependency.(string)]; !ok {
+					fail("%s requires %s", name, dependency)
+				}
+			}
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			problems = append(problems, v.validate(sub.(map[string]interface{}), value, path)...)
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && v.matches(anyOf, value, path) == 0 {
+		fail("matches none of anyOf")
+	}
+
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		if matches := v.matches(oneOf, value, path); matches != 1 {
+			fail("matches %d of oneOf instead of exactly 1", matches)
+		}
+	}
+	return problems
+}
+
+// matches returns how many of schemas value matches
+func (v *schemaValidator) matches(schemas []interface{}, value interface{}, path string) int {
+	count := 0
+	for _, sub := range schemas {
+		if len(v.validate(sub.(map[string]interface{}), value, path)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// validateYAML validates a YAML config document against the schema
+func (v *schemaValidator) validateYAML(t *testing.T, content string) []string {
+	t.Helper()
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatal(err)
+	}
+	return v.validate(v.root, toJSON(t, document), "")
+}
+
+func TestSchemaValidatesExamples(t *testing.T) {
+	validator := newSchemaValidator(t)
+
+	examples, err := filepath.Glob("../../example/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(examples) == 0 {
+		t.Fatal("no example config found")
+	}
+
+	documents := make(map[string]string)
+	for _, example := range examples {
+		content, err := os.ReadFile(example)
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents[filepath.Base(example)] = string(content)
+	}
+
+	// The Helm chart ships a config as well
+	values, err := os.ReadFile("../../deploy/chart/micro-ddns/values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chart struct {
+		DDNSConfig string `yaml:"ddnsConfig"`
+	}
+	if err := yaml.Unmarshal(values, &chart); err != nil {
+		t.Fatal(err)
+	}
+	documents["chart values.yaml"] = chart.DDNSConfig
+
+	for name, content := range documents {
+		t.Run(name, func(t *testing.T) {
+			if problems := validator.validateYAML(t, content); len(problems) > 0 {
+				t.Errorf("example does not match the schema:\n%s", strings.Join(problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestSchemaRejectsInvalidConfig(t *testing.T) {
+	validator := newSchemaValidator(t)
+	valid := `ddns:
+  - name: home
+    domain: example.com
+    subdomain: home
+    stack: IPv4
+    cron: "*/5 * * * *"
+    providerRef: dns
+    detectionRef: api
+`
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "both cron and interval",
+			content: valid + "    interval: 5m\n",
+			want:    "matches 2 of oneOf",
+		},
+		{
+			name:    "unknown field",
+			content: valid + "    subdomian: www\n",
+			want:    "unknown property subdomian",
+		},
+		{
+			name:    "unknown stack",
+			content: strings.Replace(valid, "IPv4", "IPv5", 1),
+			want:    "IPv5 is not one of",
+		},
+		{
+			name:    "port out of range",
+			content: valid + "provider:\n  - name: dns\n    rfc2136: {address: 192.0.2.53, port: 70000}\n",
+			want:    "70000 is greater than 65535",
+		},
+		{
+			name:    "top level detection without name",
+			content: valid + "detection:\n  - api: {url: 'https://api.example.com'}\n",
+			want:    "missing required property name",
+		},
+	}
+
+	if problems := validator.validateYAML(t, valid); len(problems) > 0 {
+		t.Fatalf("valid config does not match the schema: %v", problems)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := strings.Join(validator.validateYAML(t, tt.content), "\n")
+			if !strings.Contains(problems, tt.want) {
+				t.Errorf("expected a problem containing %q, got %q", tt.want, problems)
+			}
+		})
+	}
+}
+
+func TestSchemaRuleOfMissingField(t *testing.T) {
+	type renamed struct {
+		Port int `json:"serverPort"`
+	}
+	schemaRules[reflect.TypeOf(renamed{})] = schemaRules[reflect.TypeOf(RFC2136Spec{})]
+	defer delete(schemaRules, reflect.TypeOf(renamed{}))
+
+	_, err := structSchema(reflect.TypeOf(renamed{}), make(map[string]interface{}))
+	if err == nil || !strings.Contains(err.Error(), "no property port") {
+		t.Fatalf("expected an error about the missing port property, got %v", err)
+	}
+}
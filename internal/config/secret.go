@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const secretFilePrefix = "file:"
+
+// secretVisitor is called with the path and the value of every secret-bearing
+// field of a spec, the value can be replaced in place
+type secretVisitor func(path string, field *string)
+
+// within returns a visitor prepending prefix to the path of every field
+func (visit secretVisitor) within(prefix string) secretVisitor {
+	return func(path string, field *string) {
+		visit(joinPath(prefix, path), field)
+	}
+}
+
+// isSecretReference reports if value refers to an environment variable or a file
+func isSecretReference(value string) bool {
+	return isEnvReference(value) || strings.HasPrefix(value, secretFilePrefix)
+}
+
+func isEnvReference(value string) bool {
+	return strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}")
+}
+
+// checkSecret only checks the syntax of a secret reference, the referenced
+// variable or file is not read
+func checkSecret(value string) error {
+	if isEnvReference(value) && len(value) == len("${}") {
+		return fmt.Errorf("empty environment variable reference")
+	}
+	if value == secretFilePrefix {
+		return fmt.Errorf("empty secret file reference")
+	}
+	return nil
+}
+
+// secretFile returns the path of the file value refers to, if any
+func secretFile(value string) (string, bool) {
+	if !strings.HasPrefix(value, secretFilePrefix) || value == secretFilePrefix {
+		return "", false
+	}
+	return strings.TrimPrefix(value, secretFilePrefix), true
+}
+
+// resolveSecret returns the actual value of a secret-bearing field
+// "${NAME}" is replaced by the value of environment variable NAME,
+// "file:/path/to/secret" is replaced by the content of the file with
+// trailing newlines trimmed, anything else is returned as-is
+func resolveSecret(value string) (string, error) {
+	if err := checkSecret(value); err != nil {
+		return "", err
+	}
+
+	if isEnvReference(value) {
+		name := value[2 : len(value)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		if v == "" {
+			return "", fmt.Errorf("environment variable %s is empty", name)
+		}
+		return v, nil
+	}
+
+	if path, ok := secretFile(value); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+
+		v := strings.TrimRight(string(content), "\r\n")
+		if v == "" {
+			return "", fmt.Errorf("secret file %s is empty", path)
+		}
+		return v, nil
+	}
+
+	return value, nil
+}
+
+// ResolveSecrets replaces every secret reference of a validated config by the
+// value it refers to. Validate only checks the syntax of references, so
+// validating a config never needs the environment or the files of the host
+// it runs on.
+func (c *Config) ResolveSecrets() error {
+	var errs FieldErrors
+	c.secrets(errs.resolveSecret)
+
+	// URLs are only checked once they are known
+	for i, spec := range c.Notification {
+		errs.add(indexPath("notification", i), spec.validateURLs())
+	}
+	return errs.err()
+}
+
+// SecretFiles returns every file referenced by a secret of the config
+func (c *Config) SecretFiles() []string {
+	var files []string
+	c.secrets(func(_ string, field *string) {
+		if path, ok := secretFile(*field); ok {
+			files = append(files, path)
+		}
+	})
+	return files
+}
+
+func (c *Config) secrets(visit secretVisitor) {
+	for i, spec := range c.DDNS {
+		spec.secrets(visit.within(indexPath("ddns", i)))
+	}
+	for i, spec := range c.Detection {
+		spec.secrets(visit.within(indexPath("detection", i)))
+	}
+	for i, spec := range c.Provider {
+		spec.secrets(visit.within(indexPath("provider", i)))
+	}
+	for i, spec := range c.Notification {
+		spec.secrets(visit.within(indexPath("notification", i)))
+	}
+}
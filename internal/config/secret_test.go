@@ -0,0 +1,197 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "token"), "s3cr3t\r\n\n")
+	writeFile(t, filepath.Join(dir, "empty"), "\n")
+	t.Setenv("MICRO_DDNS_TEST_TOKEN", "from-env")
+	t.Setenv("MICRO_DDNS_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "plain value",
+			value: "plain",
+			want:  "plain",
+		},
+		{
+			name:  "environment variable",
+			value: "${MICRO_DDNS_TEST_TOKEN}",
+			want:  "from-env",
+		},
+		{
+			name:    "missing environment variable",
+			value:   "${MICRO_DDNS_TEST_MISSING}",
+			wantErr: "environment variable MICRO_DDNS_TEST_MISSING is not set",
+		},
+		{
+			name:    "empty environment variable",
+			value:   "${MICRO_DDNS_TEST_EMPTY}",
+			wantErr: "environment variable MICRO_DDNS_TEST_EMPTY is empty",
+		},
+		{
+			name:    "empty environment variable reference",
+			value:   "${}",
+			wantErr: "empty environment variable reference",
+		},
+		{
+			name:  "file with trailing newlines",
+			value: "file:" + filepath.Join(dir, "token"),
+			want:  "s3cr3t",
+		},
+		{
+			name:    "missing file",
+			value:   "file:" + filepath.Join(dir, "missing"),
+			wantErr: "failed to read secret file",
+		},
+		{
+			name:    "empty file",
+			value:   "file:" + filepath.Join(dir, "empty"),
+			wantErr: "is empty",
+		},
+		{
+			name:    "empty file reference",
+			value:   "file:",
+			wantErr: "empty secret file reference",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSecret(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+const secretConfig = `ddns:
+  - name: home
+    domain: example.com
+    subdomain: home
+    stack: IPv4
+    interval: 1h
+    detectionRef: api
+    provider:
+      cloudflare:
+        apiToken: "${MICRO_DDNS_TEST_TOKEN}"
+detection:
+  - name: api
+    api:
+      url: https://api.example.com
+      password: "file:%s"
+notification:
+  - name: slack
+    slack:
+      webhookUrl: "${MICRO_DDNS_TEST_SLACK}"
+`
+
+func TestValidateOnlyChecksSecretSyntax(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, strings.Replace(secretConfig, "%s", passwordFile, 1))
+
+	// Neither the variables nor the file exist on the host validating the config
+	if _, err := ValidateFile(path); err != nil {
+		t.Fatalf("expected the config to be valid, got %v", err)
+	}
+	if _, err := ReadConfigOrGet(path); err == nil || !strings.Contains(err.Error(), "ddns[0].provider.cloudflare.apiToken: environment variable MICRO_DDNS_TEST_TOKEN is not set") {
+		t.Fatalf("expected the missing variable to be reported, got %v", err)
+	}
+
+	t.Setenv("MICRO_DDNS_TEST_TOKEN", "token")
+	t.Setenv("MICRO_DDNS_TEST_SLACK", "not a URL")
+	writeFile(t, passwordFile, "password\n")
+	if _, err := ReadConfigOrGet(path); err == nil || !strings.Contains(err.Error(), "notification[0].slack.webhookUrl: webhookUrl must be an HTTP URL") {
+		t.Fatalf("expected the resolved URL to be checked, got %v", err)
+	}
+
+	t.Setenv("MICRO_DDNS_TEST_SLACK", "https://hooks.slack.com/services/T0/B0/X")
+	config, err := ReadConfigOrGet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := *config.DDNS[0].GetProviderSpec().Cloudflare.APIToken; token != "token" {
+		t.Errorf("expected the resolved token, got %q", token)
+	}
+	if password := *config.Detection[0].API.Password; password != "password" {
+		t.Errorf("expected the resolved password, got %q", password)
+	}
+}
+
+func TestValidateRejectsMalformedSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, strings.Replace(secretConfig, `"file:%s"`, `"file:"`, 1))
+
+	_, err := ValidateFile(path)
+	if err == nil || !strings.Contains(err.Error(), "detection[0].api.password: empty secret file reference") {
+		t.Fatalf("expected the empty reference to be reported, got %v", err)
+	}
+}
+
+func TestChecksumIncludesSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, strings.Replace(secretConfig, "%s", passwordFile, 1))
+
+	missing, err := checksumOf(path)
+	if err != nil {
+		t.Fatalf("a missing secret file must not break the checksum: %v", err)
+	}
+
+	writeFile(t, passwordFile, "old")
+	old, err := checksumOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old == missing {
+		t.Error("checksum did not change when the secret file was created")
+	}
+
+	writeFile(t, passwordFile, "new")
+	rotated, err := checksumOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == old {
+		t.Error("checksum did not change when the secret file was rotated")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"strings"
+)
+
+type DetectionStrategy string
+
+const (
+	// DetectionFirstSuccess tries the sources in order and uses the first address detected
+	DetectionFirstSuccess DetectionStrategy = "firstSuccess"
+
+	// DetectionQuorum asks every source and only uses an address enough of them agree on
+	DetectionQuorum DetectionStrategy = "quorum"
+)
+
+// DetectionStrategySpec defines how the addresses of several detection
+// sources are combined into the address published by a DDNS spec
+type DetectionStrategySpec struct {
+	// Mode is either firstSuccess or quorum, default is firstSuccess
+	Mode DetectionStrategy `json:"mode,omitempty" yaml:"mode,omitempty"`
+
+	// Quorum is how many sources must agree on an address in quorum mode,
+	// default is a majority of the sources
+	Quorum *int `json:"quorum,omitempty" yaml:"quorum,omitempty"`
+
+	// FallbackIPv4 is published when no IPv4 address could be detected
+	FallbackIPv4 *string `json:"fallbackIPv4,omitempty" yaml:"fallbackIPv4,omitempty"`
+
+	// FallbackIPv6 is published when no IPv6 address could be detected
+	FallbackIPv6 *string `json:"fallbackIPv6,omitempty" yaml:"fallbackIPv6,omitempty"`
+}
+
+func (spec *DetectionStrategySpec) setDefaults(sources int) {
+	if spec.Mode == "" {
+		spec.Mode = DetectionFirstSuccess
+	}
+	if spec.Quorum == nil {
+		quorum := sources/2 + 1
+		spec.Quorum = &quorum
+	}
+}
+
+// Validate checks the strategy against the number of sources it combines
+func (spec *DetectionStrategySpec) Validate(sources int) error {
+	var errs FieldErrors
+	spec.setDefaults(sources)
+
+	if spec.Mode != DetectionFirstSuccess && spec.Mode != DetectionQuorum {
+		errs.addf("mode", "unknown detection strategy %s, must be one of firstSuccess or quorum", spec.Mode)
+	}
+
+	if *spec.Quorum < 1 {
+		errs.addf("quorum", "quorum must be at least 1")
+	} else if *spec.Quorum > sources {
+		errs.addf("quorum", "quorum %d is more than the %d detection source(s)", *spec.Quorum, sources)
+	}
+
+	if spec.FallbackIPv4 != nil {
+		ip := net.ParseIP(*spec.FallbackIPv4)
+		if ip == nil || ip.To4() == nil || strings.Contains(*spec.FallbackIPv4, ":") {
+			errs.addf("fallbackIPv4", "%s is not a valid IPv4 address", *spec.FallbackIPv4)
+		}
+	}
+	if spec.FallbackIPv6 != nil {
+		ip := net.ParseIP(*spec.FallbackIPv6)
+		if ip == nil || !strings.Contains(*spec.FallbackIPv6, ":") {
+			errs.addf("fallbackIPv6", "%s is not a valid IPv6 address", *spec.FallbackIPv6)
+		}
+	}
+	return errs.err()
+}
+
+// GetFallback returns the static address of stack, or an empty string if there is none
+func (spec *DetectionStrategySpec) GetFallback(stack NetworkStack) string {
+	fallback := spec.FallbackIPv4
+	if stack == IPv6 {
+		fallback = spec.FallbackIPv6
+	}
+	if fallback == nil {
+		return ""
+	}
+	return *fallback
+}
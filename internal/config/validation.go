@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateFile loads the configuration at path and runs every validation on it
+// Unlike ReadConfigOrGet, a validation failure is always returned as FieldErrors
+// with each problem pointing to the file and line it was found in when possible
+func ValidateFile(path string) (*Config, error) {
+	loader := newConfigLoader()
+	if err := loader.load(path); err != nil {
+		return nil, err
+	}
+
+	config := loader.merged
+	err := config.Validate()
+	if err == nil && len(loader.duplicates) == 0 {
+		return config, nil
+	}
+
+	var errs FieldErrors
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+
+	nodes := make(map[string]*yaml.Node)
+	for _, fieldErr := range errs {
+		loader.locate(fieldErr, nodes)
+	}
+
+	// Duplicates are located by the loader already
+	errs = append(errs, loader.duplicates...)
+
+	// Report problems in the order they appear in the files, problems found
+	// on the same line keep the order of validation
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return errs[i].Line < errs[j].Line
+	})
+	return nil, errs
+}
+
+// locate rewrites the path of err to be relative to the file the spec came from
+// and fills in the file and line
+func (l *configLoader) locate(err *FieldError, nodes map[string]*yaml.Node) {
+	first, rest, _ := strings.Cut(err.Path, ".")
+	kind, index, ok := parseIndexSegment(first)
+	if !ok {
+		// Problems of the merged config as a whole only have a location
+		// when a single file is loaded
+		if len(l.files) == 1 {
+			err.File = l.files[0]
+		}
+		return
+	}
+
+	origins := l.origins[kind]
+	if index < 0 || index >= len(origins) {
+		return
+	}
+
+	o := origins[index]
+	err.File = o.file
+	err.Path = joinPath(indexPath(kind, o.index), rest)
+
+	node, exists := nodes[o.file]
+	if !exists {
+		node = parseNode(o.file)
+		nodes[o.file] = node
+	}
+	if node != nil {
+		err.Line = lineOf(node, err.Path)
+	}
+}
+
+// parseNode parses a config file into a YAML node tree, JSON files are valid
+// YAML as well so they are handled the same way
+func parseNode(path string) *yaml.Node {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(content, &node); err != nil {
+		return nil
+	}
+	return &node
+}
+
+// parseIndexSegment splits a path segment like "provider[2]" into its key and index
+func parseIndexSegment(segment string) (string, int, bool) {
+	open := strings.Index(segment, "[")
+	if open < 0 || !strings.HasSuffix(segment, "]") {
+		return segment, -1, false
+	}
+
+	index, err := strconv.Atoi(segment[open+1 : len(segment)-1])
+	if err != nil {
+		return segment, -1, false
+	}
+	return segment[:open], index, true
+}
+
+// lineOf returns the line of the field at path, or the line of the deepest
+// existing parent when the field itself is missing
+func lineOf(root *yaml.Node, path string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+
+	for _, segment := range strings.Split(path, ".") {
+		key, index, indexed := parseIndexSegment(segment)
+		if key != "" {
+			keyNode, valueNode := mappingEntry(node, key)
+			if valueNode == nil {
+				return line
+			}
+			node, line = valueNode, keyNode.Line
+		}
+
+		if indexed {
+			if node.Kind != yaml.SequenceNode || index < 0 || index >= len(node.Content) {
+				return line
+			}
+			node = node.Content[index]
+			line = node.Line
+		}
+	}
+	return line
+}
+
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,406 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateFileSortsErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yaml"), `ddns:
+  - name: bad
+    domain: example.com
+    subdomain: x
+    stack: IPv4
+    interval: 1h
+    detectionRef: missing
+    provider: {rfc2136: {address: 127.0.0.1, port: 99999}}
+detection:
+  - name: empty
+    api: {url: ''}
+`)
+	writeFile(t, filepath.Join(dir, "b.yaml"), `provider:
+  - name: broken
+    rfc2136: {address: 127.0.0.1, port: 0}
+`)
+
+	_, err := ValidateFile(dir)
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected FieldErrors, got %v", err)
+	}
+
+	want := []struct {
+		file string
+		line int
+	}{
+		{"a.yaml", 7},
+		{"a.yaml", 8},
+		{"a.yaml", 11},
+		{"b.yaml", 3},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		if filepath.Base(errs[i].File) != w.file || errs[i].Line != w.line {
+			t.Errorf("error %d: expected %s:%d, got %s:%d (%v)", i, w.file, w.line, errs[i].File, errs[i].Line, errs[i])
+		}
+	}
+}
+
+func TestValidateFileReportsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yaml"), `ddns:
+  - name: home
+    domain: example.com
+    subdomain: home
+    stack: IPv4
+    interval: 1h
+    detectionRef: api
+    providerRef: dns
+  - name: home
+    domain: example.com
+    subdomain: www
+    stack: IPv4
+    interval: 1h
+    detectionRef: api
+    providerRef: dns
+detection:
+  - name: api
+    api: {url: 'https://api.example.com'}
+  - name: api
+    api: {url: ''}
+`)
+	writeFile(t, filepath.Join(dir, "b.yaml"), `provider:
+  - name: dns
+    rfc2136: {address: 127.0.0.1, port: 0}
+  - name: dns
+    rfc2136: {address: 127.0.0.1}
+`)
+
+	_, err := ValidateFile(dir)
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected FieldErrors, got %v", err)
+	}
+
+	want := []struct {
+		file string
+		line int
+		path string
+	}{
+		{"a.yaml", 9, "ddns[1].name"},
+		{"a.yaml", 19, "detection[1].name"},
+		{"b.yaml", 3, "provider[0].rfc2136.port"},
+		{"b.yaml", 4, "provider[1].name"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		if filepath.Base(errs[i].File) != w.file || errs[i].Line != w.line || errs[i].Path != w.path {
+			t.Errorf("error %d: expected %s:%d %s, got %s:%d %s (%v)", i, w.file, w.line, w.path, errs[i].File, errs[i].Line, errs[i].Path, errs[i])
+		}
+	}
+}
+
+// ddnsConfig is a valid config with a single DDNS spec, specLines are
+// appended to the spec and the top-level specs share the name of the DDNS spec
+const ddnsConfig = `ddns:
+  - name: home
+    domain: example.com
+    subdomain: home
+    stack: IPv4
+%s
+detection:
+  - name: home
+    api: {url: 'https://api.example.com'}
+provider:
+  - name: home
+    rfc2136: {address: 192.0.2.53}
+`
+
+// validateSpec validates ddnsConfig with specLines and returns the problems found
+func validateSpec(t *testing.T, specLines string) (*Config, FieldErrors) {
+	t.Helper()
+	return validateContent(t, fmt.Sprintf(ddnsConfig, specLines))
+}
+
+// validateContent validates a config file with content and returns the problems found
+func validateContent(t *testing.T, content string) (*Config, FieldErrors) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, content)
+
+	config, err := ValidateFile(path)
+	if err == nil {
+		return config, nil
+	}
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected FieldErrors, got %v", err)
+	}
+	return nil, errs
+}
+
+// expectProblem fails unless errs has a problem of path containing want,
+// an empty want expects no problem at all
+func expectProblem(t *testing.T, errs FieldErrors, path string, want string) {
+	t.Helper()
+	if want == "" {
+		if len(errs) > 0 {
+			t.Fatalf("expected no problem, got %v", errs)
+		}
+		return
+	}
+	for _, err := range errs {
+		if err.Path == path && strings.Contains(err.Err.Error(), want) {
+			return
+		}
+	}
+	t.Fatalf("expected a problem of %s containing %q, got %v", path, want, errs)
+}
+
+func TestValidateInlineSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		specLines string
+		wantPath  string
+		wantErr   string
+	}{
+		{
+			name:      "references",
+			specLines: "    interval: 1h\n    providerRef: home\n    detectionRef: home",
+		},
+		{
+			name:      "inline specs",
+			specLines: "    interval: 1h\n    provider: {rfc2136: {address: 192.0.2.54}}\n    detection: {interface: {name: eth0}}",
+		},
+		{
+			name:      "inline provider with providerRef",
+			specLines: "    interval: 1h\n    providerRef: home\n    provider: {rfc2136: {address: 192.0.2.54}}\n    detectionRef: home",
+			wantPath:  "ddns[0].providerRef",
+			wantErr:   "cannot be used together with an inline provider",
+		},
+		{
+			name:      "inline detection with detectionRef",
+			specLines: "    interval: 1h\n    providerRef: home\n    detectionRef: home\n    detection: {interface: {name: eth0}}",
+			wantPath:  "ddns[0].detectionRef",
+			wantErr:   "cannot be used together with an inline detection",
+		},
+		{
+			name:      "inline detection with detectionRefs",
+			specLines: "    interval: 1h\n    providerRef: home\n    detectionRefs: [home]\n    detection: {interface: {name: eth0}}",
+			wantPath:  "ddns[0].detectionRefs",
+			wantErr:   "cannot be used together with an inline detection",
+		},
+		{
+			name:      "no provider",
+			specLines: "    interval: 1h\n    detectionRef: home",
+			wantPath:  "ddns[0].providerRef",
+			wantErr:   "providerref cannot be empty",
+		},
+		{
+			name:      "no detection",
+			specLines: "    interval: 1h\n    providerRef: home",
+			wantPath:  "ddns[0].detectionRef",
+			wantErr:   "detectionref cannot be empty",
+		},
+		{
+			name:      "invalid inline provider",
+			specLines: "    interval: 1h\n    provider: {rfc2136: {address: ''}}\n    detectionRef: home",
+			wantPath:  "ddns[0].provider.rfc2136.address",
+			wantErr:   "address cannot be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := validateSpec(t, tt.specLines)
+			expectProblem(t, errs, tt.wantPath, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateInlineSpecNames(t *testing.T) {
+	config, errs := validateSpec(t, "    interval: 1h\n    provider: {rfc2136: {address: 192.0.2.54}}\n    detection: {name: custom, interface: {name: eth0}}")
+	expectProblem(t, errs, "", "")
+
+	spec := config.DDNS[0]
+	provider := spec.GetProviderSpec()
+	if provider != spec.Provider || provider.Name != "home" {
+		t.Errorf("expected the inline provider named after the spec, got %s", provider.Name)
+	}
+	detections := spec.GetDetectionSpecs()
+	if len(detections) != 1 || detections[0] != spec.Detection || detections[0].Name != "custom" {
+		t.Errorf("expected the inline detection to keep its name, got %v", detections)
+	}
+
+	// Inline specs never share a pool with the top-level specs of the same name
+	if provider.PoolKey() == config.Provider[0].PoolKey() {
+		t.Errorf("inline and top-level provider share the pool key %s", provider.PoolKey())
+	}
+	if provider.PoolKey() != "inline:home" || config.Provider[0].PoolKey() != "ref:home" {
+		t.Errorf("unexpected pool keys %s and %s", provider.PoolKey(), config.Provider[0].PoolKey())
+	}
+}
+
+func TestValidateSubdomains(t *testing.T) {
+	tests := []struct {
+		name      string
+		specLines string
+		want      []string
+		wantPath  string
+		wantErr   string
+	}{
+		{
+			name:      "subdomain and subdomains",
+			specLines: "    subdomains: [www, '@', API]",
+			want:      []string{"home", "www", "@", "api"},
+		},
+		{
+			name:      "repeated subdomain",
+			specLines: "    subdomains: [www, HOME]",
+			wantPath:  "ddns[0].subdomains[1]",
+			wantErr:   "subdomain HOME is listed more than once",
+		},
+		{
+			name:      "empty subdomain in list",
+			specLines: "    subdomains: [www, '']",
+			wantPath:  "ddns[0].subdomains[1]",
+			wantErr:   "subdomain cannot be empty",
+		},
+		{
+			name:      "invalid subdomain in list",
+			specLines: "    subdomains: [www, 'bad_name']",
+			wantPath:  "ddns[0].subdomains[1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, errs := validateSpec(t, "    interval: 1h\n    providerRef: home\n    detectionRef: home\n"+tt.specLines)
+			if tt.wantPath != "" {
+				if len(errs) == 0 || errs[0].Path != tt.wantPath || !strings.Contains(errs[0].Err.Error(), tt.wantErr) {
+					t.Fatalf("expected a problem of %s containing %q, got %v", tt.wantPath, tt.wantErr, errs)
+				}
+				return
+			}
+			expectProblem(t, errs, "", "")
+			if got := config.DDNS[0].GetSubdomains(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected subdomains %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsRecordsOfOtherSpecs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, strings.Replace(fmt.Sprintf(ddnsConfig, "    interval: 1h\n    providerRef: home\n    detectionRef: home\n    subdomains: [www]"), "ddns:\n", `ddns:
+  - name: www
+    domain: EXAMPLE.com
+    subdomain: WWW
+    stack: Both
+    interval: 1h
+    providerRef: home
+    detectionRef: home
+`, 1))
+
+	_, err := ValidateFile(path)
+	if err == nil || !strings.Contains(err.Error(), "ddns[1]: record www.example.com A is already managed by ddns spec www") {
+		t.Fatalf("expected the record managed by both specs to be reported, got %v", err)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name      string
+		specLines string
+		wantPath  string
+		wantErr   string
+	}{
+		{
+			name:      "cron",
+			specLines: "    cron: '*/5 * * * *'",
+		},
+		{
+			name:      "interval",
+			specLines: "    interval: 10s",
+		},
+		{
+			name:      "interval with jitter",
+			specLines: "    interval: 5m\n    intervalJitter: 30s",
+		},
+		{
+			name:      "cron and interval",
+			specLines: "    cron: '*/5 * * * *'\n    interval: 5m",
+			wantPath:  "ddns[0].interval",
+			wantErr:   "interval cannot be used together with cron",
+		},
+		{
+			name:     "no schedule",
+			wantPath: "ddns[0].cron",
+			wantErr:  "either cron or interval must be specified",
+		},
+		{
+			name:      "invalid cron",
+			specLines: "    cron: '*/5 * * *'",
+			wantPath:  "ddns[0].cron",
+			wantErr:   "is not a valid cron expression",
+		},
+		{
+			name:      "interval too short",
+			specLines: "    interval: 9s",
+			wantPath:  "ddns[0].interval",
+			wantErr:   "interval cannot be shorter than 10s",
+		},
+		{
+			name:      "jitter with cron",
+			specLines: "    cron: '*/5 * * * *'\n    intervalJitter: 30s",
+			wantPath:  "ddns[0].intervalJitter",
+			wantErr:   "intervalJitter can only be used with interval",
+		},
+		{
+			name:      "negative jitter",
+			specLines: "    interval: 5m\n    intervalJitter: -30s",
+			wantPath:  "ddns[0].intervalJitter",
+			wantErr:   "intervalJitter cannot be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := validateSpec(t, "    providerRef: home\n    detectionRef: home\n"+tt.specLines)
+			expectProblem(t, errs, tt.wantPath, tt.wantErr)
+		})
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Watcher polls the config file and notifies when its content changed
+// Content is compared instead of modification time, so symlink swaps done by
+// Kubernetes when updating a mounted ConfigMap are also detected.
+// When path is a directory, every fragment and included file is watched.
+// Files referenced by secrets are watched as well, so rotated credentials are
+// picked up without a restart.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	checksum string
+	lastErr  string
+	changes  chan struct{}
+	logger   *slog.Logger
+}
+
+func NewWatcher(path string, interval time.Duration, logger *slog.Logger) (*Watcher, error) {
+	checksum, err := checksumOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		checksum: checksum,
+		changes:  make(chan struct{}, 1),
+		logger:   logger,
+	}, nil
+}
+
+func checksumOf(path string) (string, error) {
+	files, secretFiles, err := listConfigFiles(path)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(file))
+		hash.Write(content)
+	}
+
+	// A missing secret file is part of the checksum as well, the config is
+	// reloaded as soon as the file shows up
+	for _, file := range secretFiles {
+		hash.Write([]byte(file))
+		if content, err := os.ReadFile(file); err == nil {
+			hash.Write(content)
+		} else {
+			hash.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Changes returns a channel that receives a value every time the config file changed
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Watch blocks until parentCtx is cancelled, checking the config file every interval
+func (w *Watcher) Watch(parentCtx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-parentCtx.Done():
+			return
+		case <-ticker.C:
+			checksum, err := checksumOf(w.path)
+			if err != nil {
+				// Only report an error once until it changes, a broken
+				// fragment would flood the log otherwise
+				if err.Error() != w.lastErr {
+					w.logger.Warn("failed to read config file", "path", w.path, "err", err)
+					w.lastErr = err.Error()
+				}
+				continue
+			}
+			w.lastErr = ""
+
+			if checksum == w.checksum {
+				continue
+			}
+			w.logger.Debug("config file content changed", "path", w.path)
+			w.checksum = checksum
+
+			select {
+			case w.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/notify"
+)
+
+// digestJob is the daily job sending the digest of a notifier
+type digestJob struct {
+	spec *config.NotifierSpec
+	job  gocron.Job
+}
+
+// syncDigests schedules the digest of every notifier in digest mode used by
+// an instance and removes those no longer used, the caller must hold the lock
+func (m *DDNSInstanceManager) syncDigests() {
+	wanted := make(map[string]*config.NotifierSpec)
+	for _, instance := range m.instances {
+		for _, subscription := range instance.spec.Notify {
+			spec := subscription.GetNotifierSpec()
+			if spec.Digest != nil {
+				wanted[spec.Name] = spec
+			}
+		}
+	}
+
+	for name, digest := range m.digests {
+		if spec, ok := wanted[name]; ok && reflect.DeepEqual(spec, digest.spec) {
+			continue
+		}
+
+		if err := m.scheduler.RemoveJob(digest.job.ID()); err != nil {
+			m.logger.Error("failed to remove digest job", "notifier", name, "err", err)
+		}
+		delete(m.digests, name)
+	}
+
+	for name, spec := range wanted {
+		if _, exists := m.digests[name]; exists {
+			continue
+		}
+
+		if err := m.registerDigest(spec); err != nil {
+			m.logger.Error("failed to create digest job", "notifier", name, "err", err)
+		}
+	}
+}
+
+func (m *DDNSInstanceManager) registerDigest(spec *config.NotifierSpec) error {
+	notifier, err := notify.New(spec)
+	if err != nil {
+		return err
+	}
+
+	hour, minute := spec.Digest.GetTime()
+	definition := gocron.DailyJob(1, gocron.NewAtTimes(gocron.NewAtTime(uint(hour), uint(minute), 0)))
+	job, err := m.scheduler.NewJob(definition, gocron.NewTask(m.sendDigest, m.ctx, spec.Name, notifier))
+	if err != nil {
+		return err
+	}
+
+	m.digests[spec.Name] = &digestJob{spec: spec, job: job}
+	m.logger.Info("scheduled digest", "notifier", spec.Name, "at", spec.Digest.At)
+	return nil
+}
+
+// sendDigest sends the records observed since the previous digest of a notifier
+func (m *DDNSInstanceManager) sendDigest(ctx context.Context, name string, notifier notify.Notifier) {
+	message := m.shared.Digests.Get(name).Message(name, time.Now())
+	if message == nil {
+		m.logger.Info("no record observed since the previous digest, skipping", "notifier", name)
+		return
+	}
+
+	if err := notifier.Notify(ctx, message); err != nil {
+		m.logger.Error("failed to send digest", "notifier", name, "err", err)
+		return
+	}
+	m.logger.Info("sent digest", "notifier", name, "records", len(message.Records))
+}
@@ -18,113 +18,620 @@ package ddns
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync/atomic"
+	"time"
 
+	"github.com/masteryyh/micro-ddns/internal/breaker"
 	"github.com/masteryyh/micro-ddns/internal/config"
 	"github.com/masteryyh/micro-ddns/internal/dns"
+	"github.com/masteryyh/micro-ddns/internal/history"
+	"github.com/masteryyh/micro-ddns/internal/hook"
 	"github.com/masteryyh/micro-ddns/internal/ip"
+	"github.com/masteryyh/micro-ddns/internal/retry"
+	"github.com/masteryyh/micro-ddns/internal/state"
 )
 
+// recordHandler is the DNS update handler of a single record managed by an instance
+type recordHandler struct {
+	record   dns.Record
+	handler  dns.DNSUpdateHandler
+	provider *dns.Provider
+	logger   *slog.Logger
+
+	// stateKey is the key of the record in the state store
+	stateKey string
+
+	// owned is set once the record was created or updated by micro-ddns,
+	// only those records are deleted on shutdown
+	owned atomic.Bool
+}
+
+// stackUpdater detects the address of a single stack and keeps the records
+// of that stack up to date
+type stackUpdater struct {
+	stack           config.NetworkStack
+	records         []*recordHandler
+	addressDetector ip.AddressDetector
+
+	// fallback is published once every detection attempt failed, empty
+	// when the spec has no fallback address for the stack
+	fallback string
+}
+
+// stateRecheckInterval is how long the address stored for a record is trusted
+// before the provider is queried again, so a record changed by someone else
+// is eventually fixed
+const stateRecheckInterval = time.Hour
+
+// Action is what reconciling a DNS record did, or would do in dry run mode
+type Action string
+
+const (
+	ActionNone   Action = "none"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+)
+
+// RecordResult is the outcome of reconciling a single DNS record
+type RecordResult struct {
+	// Name is the name of the DDNS spec managing the record
+	Name   string
+	Record string
+	Type   dns.RecordType
+
+	// Address is the detected address, empty if detection failed
+	Address string
+
+	// PreviousAddress is the address the record had, empty if it did not exist
+	PreviousAddress string
+
+	Action Action
+	DryRun bool
+	Err    error
+}
+
 type DDNSInstance struct {
 	spec *config.DDNSSpec
 
-	dnsHandler      dns.DNSUpdateHandler
-	addressDetector ip.AddressDetector
-	logger          *slog.Logger
+	// dryRun reports changes instead of making them
+	dryRun bool
+
+	stacks []*stackUpdater
+	store  *state.Store
+	retry  *retry.Policy
+	hooks  *hook.Runner
+	logger *slog.Logger
+
+	// breaker skips runs once the instance failed too often in a row
+	breaker       *breaker.Breaker
+	history       *history.Recorder
+	notifications *notifications
 }
 
-func NewDDNSInstance(ddnsSpec *config.DDNSSpec, logger *slog.Logger) (*DDNSInstance, error) {
+func newDNSUpdateHandler(record dns.Record, providerSpec *config.DNSProviderSpec, provider *dns.Provider, logger *slog.Logger) (dns.DNSUpdateHandler, error) {
 	var handler dns.DNSUpdateHandler
 
-	providerSpec := ddnsSpec.GetProviderSpec()
 	providerType := providerSpec.GetType()
 	switch providerType {
 	case config.DNSProviderCloudflare:
 		spec := providerSpec.Cloudflare
-		h, err := dns.NewCloudflareDNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewCloudflareDNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
 	case config.DNSProviderAliCloud:
 		spec := providerSpec.AliCloud
-		h, err := dns.NewAliCloudDNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewAliCloudDNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
 	case config.DNSProviderDNSPod:
 		spec := providerSpec.DNSPod
-		h, err := dns.NewDNSPodDNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewDNSPodDNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
 	case config.DNSProviderHuaweiCloud:
 		spec := providerSpec.Huawei
-		h, err := dns.NewHuaweiCloudDNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewHuaweiCloudDNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
 	case config.DNSProviderJDCloud:
 		spec := providerSpec.JD
-		h, err := dns.NewJDCloudDNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewJDCloudDNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
 	case config.DNSProviderRFC2136:
 		spec := providerSpec.RFC2136
-		h, err := dns.NewRFC2136DNSUpdateHandler(ddnsSpec, spec, logger)
+		h, err := dns.NewRFC2136DNSUpdateHandler(record, spec, provider, logger)
 		if err != nil {
 			return nil, err
 		}
 		handler = h
+	default:
+		return nil, fmt.Errorf("unknown DNS provider type %s", providerType)
 	}
+	return handler, nil
+}
+
+// newHandler creates the DNS update handler of every record, tests replace it
+// with an in-memory provider
+var newHandler = newDNSUpdateHandler
+
+// newAddressDetector returns the detector of stack, a spec with several
+// detection sources or a strategy combines them with a MultiDetector
+func newAddressDetector(ddnsSpec *config.DDNSSpec, shared *Shared, stack config.NetworkStack, logger *slog.Logger) ip.AddressDetector {
+	detectionSpecs := ddnsSpec.GetDetectionSpecs()
+	if len(detectionSpecs) == 1 && ddnsSpec.DetectionStrategy == nil {
+		return shared.Detectors.Get(detectionSpecs[0], stack)
+	}
+
+	sources := make([]ip.Source, 0, len(detectionSpecs))
+	for _, detectionSpec := range detectionSpecs {
+		sources = append(sources, ip.Source{
+			Name:     detectionSpec.Name,
+			Detector: shared.Detectors.Get(detectionSpec, stack),
+		})
+	}
+	return ip.NewMultiDetector(sources, ddnsSpec.DetectionStrategy, logger.With("stack", string(stack)))
+}
 
-	var addrDetector ip.AddressDetector
+// NewDDNSInstance creates the instance of a validated DDNS spec, dryRun
+// forces dry run mode even if the spec does not ask for it
+func NewDDNSInstance(ddnsSpec *config.DDNSSpec, shared *Shared, dryRun bool, logger *slog.Logger) (*DDNSInstance, error) {
+	providerSpec := ddnsSpec.GetProviderSpec()
+	provider := shared.Providers.Get(providerSpec)
+
+	var stacks []*stackUpdater
+	for _, stack := range ddnsSpec.GetStacks() {
+		var records []*recordHandler
+		for _, subdomain := range ddnsSpec.GetSubdomains() {
+			record := dns.Record{
+				Domain:    ddnsSpec.Domain,
+				Subdomain: subdomain,
+				Type:      dns.RecordTypeOf(stack),
+			}
+			recordLogger := logger.With("record", record.FQDN(), "type", string(record.Type))
+
+			handler, err := newHandler(record, providerSpec, provider, recordLogger)
+			if err != nil {
+				return nil, err
+			}
 
-	detectionSpec := ddnsSpec.GetDetectionSpec()
-	detectionType := detectionSpec.GetDetectionType()
-	switch detectionType {
-	case config.AddressDetectionIface:
-		addrDetector = ip.NewIfaceAddressDetector(detectionSpec, ddnsSpec.Stack, logger)
-	case config.AddressDetectionThirdParty:
-		addrDetector = ip.NewThirdPartyAddressDetector(detectionSpec, ddnsSpec.Stack, logger)
+			// Restore identifiers found by a previous run so the handler
+			// does not have to look them up again
+			stateKey := state.Key(ddnsSpec.Name, providerSpec.PoolKey(), record.FQDN(), string(record.Type))
+			stored := shared.Store.Get(stateKey)
+			if stateful, ok := handler.(dns.StatefulHandler); ok && len(stored.IDs) > 0 {
+				stateful.RestoreState(stored.IDs)
+			}
+
+			r := &recordHandler{
+				record:   record,
+				handler:  handler,
+				provider: provider,
+				logger:   recordLogger,
+				stateKey: stateKey,
+			}
+			r.owned.Store(stored.Owned)
+			records = append(records, r)
+		}
+
+		u := &stackUpdater{
+			stack:           stack,
+			records:         records,
+			addressDetector: newAddressDetector(ddnsSpec, shared, stack, logger),
+		}
+		if ddnsSpec.DetectionStrategy != nil {
+			u.fallback = ddnsSpec.DetectionStrategy.GetFallback(stack)
+		}
+		stacks = append(stacks, u)
+	}
+
+	hooks, err := hook.NewRunner(ddnsSpec.Hooks, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, err := newNotifications(ddnsSpec, shared)
+	if err != nil {
+		return nil, err
 	}
 
 	return &DDNSInstance{
-		spec:            ddnsSpec,
-		dnsHandler:      handler,
-		addressDetector: addrDetector,
-		logger:          logger,
+		spec:   ddnsSpec,
+		dryRun: dryRun || ddnsSpec.IsDryRun(),
+		stacks: stacks,
+		store:  shared.Store,
+		retry:  retry.NewPolicy(ddnsSpec.Retry),
+		hooks:  hooks,
+		logger: logger,
+
+		breaker:       breaker.New(ddnsSpec.CircuitBreaker),
+		history:       shared.History,
+		notifications: notifications,
 	}, nil
 }
 
-func (n *DDNSInstance) DoUpdate(parentCtx context.Context) error {
-	n.logger.Info("detecting current address", "name", n.spec.Name)
-	addr, err := n.addressDetector.Detect(parentCtx)
+// call runs a single operation of the handler of r once the limits of its
+// provider allow it
+func (r *recordHandler) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	release, err := r.provider.Acquire(ctx)
 	if err != nil {
-		n.logger.Error("error detecting address", "name", n.spec.Name, "err", err)
 		return err
 	}
+	defer release()
+	return fn(ctx)
+}
+
+// recordState returns the state of r once it points to addr, with the
+// identifiers known by its handler
+func (n *DDNSInstance) recordState(r *recordHandler, addr string) state.RecordState {
+	recordState := state.RecordState{
+		Address:     addr,
+		LastSuccess: time.Now(),
+		Owned:       r.owned.Load(),
+	}
+	if stateful, ok := r.handler.(dns.StatefulHandler); ok {
+		recordState.IDs = stateful.SaveState()
+	}
+	return recordState
+}
+
+// saveStates writes the states collected during a run at once
+func (n *DDNSInstance) saveStates(states map[string]state.RecordState) {
+	if len(states) == 0 {
+		return
+	}
+
+	if err := n.store.PutAll(states); err != nil {
+		n.logger.Warn("failed to save record state", "name", n.spec.Name, "err", err)
+	}
+}
+
+// isKnown reports if the provider confirmed recently that r points to addr,
+// so the lookup can be skipped
+func (n *DDNSInstance) isKnown(r *recordHandler, addr string) bool {
+	stored := n.store.Get(r.stateKey)
+	return stored.Address == addr && time.Since(stored.LastSuccess) < stateRecheckInterval
+}
 
-	n.logger.Info("getting current address registered with DNS provider", "name", n.spec.Name)
-	recordAddr, err := n.dnsHandler.Get(parentCtx)
+// updateRecord makes a single record point to addr, in dry run mode the
+// provider is only queried and the change it would make is reported
+func (n *DDNSInstance) updateRecord(parentCtx context.Context, r *recordHandler, addr string, result *RecordResult) error {
+	r.logger.Info("getting current address registered with DNS provider", "name", n.spec.Name)
+	var recordAddr string
+	err := r.call(parentCtx, func(ctx context.Context) error {
+		var err error
+		recordAddr, err = r.handler.Get(ctx)
+		return err
+	})
 	if err != nil {
-		n.logger.Error("error getting current address", "name", n.spec.Name, "err", err)
+		r.logger.Error("error getting current address", "name", n.spec.Name, "err", err)
 		return err
 	}
+	result.PreviousAddress = recordAddr
+
 	if recordAddr == "" {
-		n.logger.Info("DNS record for this subdomain not found or ignored, creating", "name", n.spec.Name, "domain", n.spec.Domain, "subdomain", n.spec.Subdomain)
-		return n.dnsHandler.Create(parentCtx, addr)
+		result.Action = ActionCreate
+		if n.dryRun {
+			r.logger.Info("dry run: would create DNS record", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain, "address", addr)
+			return nil
+		}
+		r.logger.Info("DNS record for this subdomain not found or ignored, creating", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain)
+		return r.call(parentCtx, func(ctx context.Context) error {
+			return r.handler.Create(ctx, addr)
+		})
 	}
 
 	if recordAddr != addr {
-		n.logger.Info("address changed, updating DNS record", "name", n.spec.Name, "domain", n.spec.Domain, "subdomain", n.spec.Subdomain, "address", addr)
-		return n.dnsHandler.Update(parentCtx, addr)
+		result.Action = ActionUpdate
+		if n.dryRun {
+			r.logger.Info("dry run: would update DNS record", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain, "from", recordAddr, "address", addr)
+			return nil
+		}
+		r.logger.Info("address changed, updating DNS record", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain, "address", addr)
+		return r.call(parentCtx, func(ctx context.Context) error {
+			return r.handler.Update(ctx, addr)
+		})
+	}
+
+	result.Action = ActionNone
+	r.logger.Info("address not changed, skipping")
+	return nil
+}
+
+// newResult returns the result of r before anything is done to it
+func (n *DDNSInstance) newResult(r *recordHandler) *RecordResult {
+	return &RecordResult{
+		Name:   n.spec.Name,
+		Record: r.record.FQDN(),
+		Type:   r.record.Type,
+		DryRun: n.dryRun,
+	}
+}
+
+// updateStack detects the address of a single stack and reconciles its
+// records, the new state of every updated record is added to states
+func (n *DDNSInstance) updateStack(parentCtx context.Context, u *stackUpdater, states map[string]state.RecordState) []*RecordResult {
+	results := make([]*RecordResult, 0, len(u.records))
+	for _, r := range u.records {
+		results = append(results, n.newResult(r))
+	}
+
+	n.logger.Info("detecting current address", "name", n.spec.Name, "stack", string(u.stack))
+	var addr string
+	err := n.retry.Do(parentCtx, n.logger.With("stack", string(u.stack)), nil, func(ctx context.Context) error {
+		detected, err := u.addressDetector.Detect(ctx)
+		addr = detected
+		return err
+	})
+
+	// The fallback address is only used once retries are exhausted, a
+	// canceled run does not publish it
+	if err != nil && u.fallback != "" && parentCtx.Err() == nil {
+		n.logger.Warn("address detection failed, using fallback address", "name", n.spec.Name, "stack", string(u.stack), "address", u.fallback, "err", err)
+		addr, err = u.fallback, nil
+	}
+	if err != nil {
+		n.logger.Error("error detecting address", "name", n.spec.Name, "stack", string(u.stack), "err", err)
+		for _, result := range results {
+			result.Err = fmt.Errorf("%s address detection: %w", u.stack, err)
+		}
+		return results
+	}
+
+	// Every record is reconciled even if some of them failed, so a single
+	// broken name does not block the others
+	for i, r := range u.records {
+		result := results[i]
+		result.Address = addr
+
+		// A dry run always asks the provider, so it reports what would
+		// actually change rather than what the local state remembers
+		if !n.dryRun && n.isKnown(r, addr) {
+			result.PreviousAddress = addr
+			result.Action = ActionNone
+			r.logger.Info("address matches the last published one, skipping")
+			continue
+		}
+
+		classifier, _ := r.handler.(dns.ErrorClassifier)
+		var isPermanent func(error) bool
+		if classifier != nil {
+			isPermanent = classifier.IsPermanent
+		}
+
+		err := n.retry.Do(parentCtx, r.logger, isPermanent, func(ctx context.Context) error {
+			return n.updateRecord(ctx, r, addr, result)
+		})
+		if err != nil {
+			r.logger.Error("failed to update DNS record", "name", n.spec.Name, "err", err)
+			result.Err = fmt.Errorf("%s %s: %w", r.record.FQDN(), r.record.Type, err)
+			continue
+		}
+
+		if n.dryRun {
+			continue
+		}
+		r.logger.Debug("DNS record is up to date", "name", n.spec.Name)
+		if result.Action == ActionCreate || result.Action == ActionUpdate {
+			r.owned.Store(true)
+		}
+		states[r.stateKey] = n.recordState(r, addr)
+	}
+	return results
+}
+
+// Reconcile updates every record of the instance and reports what was done
+// to each of them, stacks are handled independently so a missing IPv6
+// address does not block the IPv4 update
+func (n *DDNSInstance) Reconcile(parentCtx context.Context) ([]*RecordResult, error) {
+	start := time.Now()
+	var results []*RecordResult
+	var errs []error
+	states := make(map[string]state.RecordState)
+	for _, u := range n.stacks {
+		for _, result := range n.updateStack(parentCtx, u, states) {
+			results = append(results, result)
+			if result.Err != nil {
+				errs = append(errs, result.Err)
+			}
+		}
+	}
+	n.saveStates(states)
+	n.recordHistory(start, results)
+	n.runHooks(parentCtx, results)
+	n.notify(parentCtx, results)
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("%d problem(s) updating DNS records: %w", len(errs), errors.Join(errs...))
+	}
+	return results, nil
+}
+
+// recordHistory adds the results of the run started at start to the history
+func (n *DDNSInstance) recordHistory(start time.Time, results []*RecordResult) {
+	duration := config.Duration(time.Since(start))
+	entries := make([]history.Entry, 0, len(results))
+	for _, result := range results {
+		entry := history.Entry{
+			Time:            start,
+			Name:            result.Name,
+			Record:          result.Record,
+			Type:            string(result.Type),
+			Action:          string(result.Action),
+			Address:         result.Address,
+			PreviousAddress: result.PreviousAddress,
+			DryRun:          result.DryRun,
+			Duration:        duration,
+		}
+		if result.Err != nil {
+			entry.Action = history.ActionError
+			entry.Error = result.Err.Error()
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := n.history.Add(entries...); err != nil {
+		n.logger.Warn("failed to record history", "name", n.spec.Name, "err", err)
+	}
+}
+
+// hookEvent returns the event passed to the hooks of the record of result
+func (result *RecordResult) hookEvent() *hook.Event {
+	event := &hook.Event{
+		Name:       result.Name,
+		FQDN:       result.Record,
+		Type:       string(result.Type),
+		OldAddress: result.PreviousAddress,
+		NewAddress: result.Address,
+		Action:     string(result.Action),
+		Success:    result.Err == nil,
+		Time:       time.Now(),
+	}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	return event
+}
+
+// runHooks runs the hooks of every record that was created or updated, and
+// of every record that failed for hooks asking for failures
+func (n *DDNSInstance) runHooks(parentCtx context.Context, results []*RecordResult) {
+	if len(n.spec.Hooks) == 0 {
+		return
+	}
+
+	for _, result := range results {
+		changed := result.Action == ActionCreate || result.Action == ActionUpdate
+		if result.Err == nil && !changed {
+			continue
+		}
+
+		if n.dryRun {
+			n.logger.Info("dry run: would run hooks", "name", n.spec.Name, "record", result.Record, "type", string(result.Type))
+			continue
+		}
+		n.hooks.Run(parentCtx, result.hookEvent())
+	}
+}
+
+// runFailure returns err if every record of a run failed and nil otherwise, a
+// run where some records failed still counts as a success for the circuit
+// breaker, so a missing IPv6 address or a single broken name never stops
+// the updates of the other records
+func runFailure(results []*RecordResult, err error) error {
+	for _, result := range results {
+		if result.Err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// DoUpdate reconciles every record of the instance, unless its circuit
+// breaker is open in which case an error wrapping breaker.ErrOpen is returned
+func (n *DDNSInstance) DoUpdate(parentCtx context.Context) error {
+	probe, err := n.breaker.Allow(time.Now())
+	if err != nil {
+		return fmt.Errorf("skipping update: %w", err)
+	}
+	if probe {
+		n.logger.Info("circuit breaker cool-down is over, probing", "name", n.spec.Name)
+	}
+
+	results, err := n.Reconcile(parentCtx)
+
+	// Shutdown and reload cancel runs, that says nothing about the provider
+	if parentCtx.Err() != nil || errors.Is(err, context.Canceled) {
+		n.breaker.Cancel()
+		return err
+	}
+
+	failure := runFailure(results, err)
+	status := n.breaker.Done(failure, time.Now())
+	switch {
+	case failure == nil && probe:
+		n.logger.Info("probe succeeded, circuit breaker closed", "name", n.spec.Name)
+	case failure != nil && status.State == breaker.Open:
+		n.logger.Warn("circuit breaker opened, skipping updates until the cool-down is over", "name", n.spec.Name,
+			"failures", status.ConsecutiveFailures, "coolDown", status.CoolDown)
+	}
+	return err
+}
+
+// stateKeys returns the state keys of every record of the instance
+func (n *DDNSInstance) stateKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, u := range n.stacks {
+		for _, r := range u.records {
+			keys[r.stateKey] = true
+		}
+	}
+	return keys
+}
+
+// Status returns the health of the instance
+func (n *DDNSInstance) Status() InstanceStatus {
+	return InstanceStatus{
+		Name:           n.spec.Name,
+		CircuitBreaker: n.breaker.Status(),
+	}
+}
+
+// DeleteRecords deletes every record this instance created or updated except
+// those whose state key is in keep, records that already pointed to the right
+// address or were never touched are left alone. In dry run mode the records
+// are only reported.
+func (n *DDNSInstance) DeleteRecords(parentCtx context.Context, keep map[string]bool) error {
+	var errs []error
+	for _, u := range n.stacks {
+		for _, r := range u.records {
+			if !r.owned.Load() || keep[r.stateKey] {
+				continue
+			}
+
+			if n.dryRun {
+				r.logger.Info("dry run: would delete DNS record", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain)
+				continue
+			}
+
+			classifier, _ := r.handler.(dns.ErrorClassifier)
+			var isPermanent func(error) bool
+			if classifier != nil {
+				isPermanent = classifier.IsPermanent
+			}
+
+			r.logger.Info("deleting DNS record", "name", n.spec.Name, "domain", r.record.Domain, "subdomain", r.record.Subdomain)
+			err := n.retry.Do(parentCtx, r.logger, isPermanent, func(ctx context.Context) error {
+				return r.call(ctx, r.handler.Delete)
+			})
+			if err != nil {
+				r.logger.Error("failed to delete DNS record", "name", n.spec.Name, "err", err)
+				errs = append(errs, fmt.Errorf("%s %s: %w", r.record.FQDN(), r.record.Type, err))
+				continue
+			}
+
+			r.owned.Store(false)
+			if err := n.store.Delete(r.stateKey); err != nil {
+				r.logger.Warn("failed to delete record state", "name", n.spec.Name, "err", err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) deleting DNS records: %w", len(errs), errors.Join(errs...))
 	}
-	n.logger.Info("address not changed, skipping")
 	return nil
 }
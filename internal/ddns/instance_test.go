@@ -0,0 +1,539 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/breaker"
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/dns"
+	"github.com/masteryyh/micro-ddns/internal/state"
+	"github.com/masteryyh/micro-ddns/pkg/utils"
+)
+
+// staticDetector detects the same address or fails with the same error
+type staticDetector struct {
+	address string
+	err     error
+
+	// failures is how many detections return err before address is
+	// detected, 0 returns err every time
+	failures int
+
+	// detections counts the calls of Detect
+	detections int
+}
+
+func (d *staticDetector) Detect(context.Context) (string, error) {
+	d.detections++
+	if d.err != nil && (d.failures == 0 || d.detections <= d.failures) {
+		return "", d.err
+	}
+	return d.address, nil
+}
+
+// newTestInstance returns the instance of spec detecting the addresses of
+// detectors, stacks missing from detectors detect nothing
+func newTestInstance(t *testing.T, shared *Shared, spec *config.DDNSSpec, dryRun bool, detectors map[config.NetworkStack]*staticDetector) *DDNSInstance {
+	t.Helper()
+	instance, err := NewDDNSInstance(spec, shared, dryRun, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range instance.stacks {
+		detector, ok := detectors[u.stack]
+		if !ok {
+			detector = &staticDetector{}
+		}
+		u.addressDetector = detector
+	}
+	return instance
+}
+
+// recordKey returns the state key of the record of spec named fqdn
+func recordKey(spec *config.DDNSSpec, fqdn string, recordType string) string {
+	return state.Key(spec.Name, spec.GetProviderSpec().PoolKey(), fqdn, recordType)
+}
+
+func TestKnownAddressSkipsLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored state.RecordState
+
+		// published is the address of the record at the provider
+		published string
+		wantCalls []string
+	}{
+		{
+			name:      "confirmed recently",
+			stored:    state.RecordState{Address: "203.0.113.1", LastSuccess: time.Now().Add(-time.Minute)},
+			published: "203.0.113.1",
+		},
+		{
+			name:      "confirmation expired",
+			stored:    state.RecordState{Address: "203.0.113.1", LastSuccess: time.Now().Add(-stateRecheckInterval - time.Minute)},
+			published: "203.0.113.1",
+			wantCalls: []string{"get home.example.com A"},
+		},
+		{
+			name:      "address changed",
+			stored:    state.RecordState{Address: "203.0.113.9", LastSuccess: time.Now().Add(-time.Minute)},
+			published: "203.0.113.9",
+			wantCalls: []string{"get home.example.com A", "update home.example.com A"},
+		},
+		{
+			name:      "nothing known",
+			published: "203.0.113.9",
+			wantCalls: []string{"get home.example.com A", "update home.example.com A"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			provider.records["home.example.com A"] = tt.published
+
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home")
+			key := recordKey(spec, "home.example.com", "A")
+			if err := shared.Store.Put(key, tt.stored); err != nil {
+				t.Fatal(err)
+			}
+			instance := newTestInstance(t, shared, spec, false, map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+			})
+
+			if _, err := instance.Reconcile(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if calls := provider.recordedCalls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+
+			// Once the provider confirmed the address, the next run trusts it
+			stored := shared.Store.Get(key)
+			if stored.Address != "203.0.113.1" || time.Since(stored.LastSuccess) > time.Minute+time.Second {
+				t.Errorf("expected a fresh confirmation of 203.0.113.1, got %+v", stored)
+			}
+			if _, err := instance.Reconcile(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if calls := provider.recordedCalls(); len(calls) != len(tt.wantCalls) {
+				t.Errorf("expected no lookup on the next run, got calls %v", calls)
+			}
+		})
+	}
+}
+
+func TestDryRunReportsChanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		published string
+		want      Action
+	}{
+		{
+			name: "missing record",
+			want: ActionCreate,
+		},
+		{
+			name:      "outdated record",
+			published: "203.0.113.9",
+			want:      ActionUpdate,
+		},
+		{
+			name:      "up to date record",
+			published: "203.0.113.1",
+			want:      ActionNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			if tt.published != "" {
+				provider.records["home.example.com A"] = tt.published
+			}
+
+			// The local state claims the record is up to date
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home")
+			key := recordKey(spec, "home.example.com", "A")
+			stored := state.RecordState{Address: "203.0.113.1", LastSuccess: time.Now()}
+			if err := shared.Store.Put(key, stored); err != nil {
+				t.Fatal(err)
+			}
+			instance := newTestInstance(t, shared, spec, true, map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+			})
+
+			results, err := instance.Reconcile(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+			result := results[0]
+			if result.Action != tt.want || !result.DryRun {
+				t.Errorf("expected dry run action %s, got %s (dry run %v)", tt.want, result.Action, result.DryRun)
+			}
+			if result.PreviousAddress != tt.published {
+				t.Errorf("expected previous address %q, got %q", tt.published, result.PreviousAddress)
+			}
+
+			if calls := provider.recordedCalls(); !reflect.DeepEqual(calls, []string{"get home.example.com A"}) {
+				t.Errorf("expected a single lookup, got calls %v", calls)
+			}
+			if got := shared.Store.Get(key); !reflect.DeepEqual(got, stored) {
+				t.Errorf("dry run changed the state to %+v", got)
+			}
+		})
+	}
+}
+
+func TestDeleteRecords(t *testing.T) {
+	tests := []struct {
+		name      string
+		dryRun    bool
+		wantCalls []string
+	}{
+		{
+			name:      "owned records are deleted",
+			wantCalls: []string{"delete home.example.com A"},
+		},
+		{
+			name:   "dry run only reports",
+			dryRun: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			provider.records["home.example.com A"] = "203.0.113.1"
+			provider.records["www.example.com A"] = "203.0.113.1"
+
+			// Only home was created by micro-ddns, www already pointed to the address
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+				spec.Subdomains = []string{"www"}
+				spec.DeleteOnShutdown = utils.BoolPtr(true)
+			})
+			owned := recordKey(spec, "home.example.com", "A")
+			if err := shared.Store.Put(owned, state.RecordState{Address: "203.0.113.1", LastSuccess: time.Now(), Owned: true}); err != nil {
+				t.Fatal(err)
+			}
+			instance := newTestInstance(t, shared, spec, tt.dryRun, nil)
+
+			if err := instance.DeleteRecords(context.Background(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if calls := provider.recordedCalls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+			if got := shared.Store.Get(owned).Owned; got != tt.dryRun {
+				t.Errorf("expected owned state %v after deletion, got %v", tt.dryRun, got)
+			}
+		})
+	}
+}
+
+func TestBreakerCountsRunsWhereEveryRecordFailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		detectors   map[config.NetworkStack]*staticDetector
+		failing     []string
+		wantState   breaker.State
+		wantSkipped int
+	}{
+		{
+			name: "missing IPv6 address",
+			detectors: map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+				config.IPv6: {err: errors.New("no IPv6 address")},
+			},
+			wantState: breaker.Closed,
+		},
+		{
+			name: "one broken name",
+			detectors: map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+				config.IPv6: {address: "2001:db8::1"},
+			},
+			failing:   []string{"www.example.com A", "www.example.com AAAA"},
+			wantState: breaker.Closed,
+		},
+		{
+			name: "every record failed",
+			detectors: map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+				config.IPv6: {address: "2001:db8::1"},
+			},
+			failing:     []string{"home.example.com A", "home.example.com AAAA", "www.example.com A", "www.example.com AAAA"},
+			wantState:   breaker.Open,
+			wantSkipped: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			for _, key := range tt.failing {
+				provider.failing[key] = errors.New("record is locked")
+			}
+
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+				spec.Stack = config.DualStack
+				spec.Subdomains = []string{"www"}
+				spec.CircuitBreaker = &config.CircuitBreakerSpec{FailureThreshold: utils.IntPtr(3)}
+			})
+			instance := newTestInstance(t, shared, spec, false, tt.detectors)
+
+			skipped := 0
+			for i := 0; i < 6; i++ {
+				err := instance.DoUpdate(context.Background())
+				if errors.Is(err, breaker.ErrOpen) {
+					skipped++
+				} else if err == nil {
+					t.Fatalf("run %d: expected the failures to be reported", i)
+				}
+			}
+
+			if skipped != tt.wantSkipped {
+				t.Errorf("expected %d skipped runs, got %d", tt.wantSkipped, skipped)
+			}
+			if state := instance.Status().CircuitBreaker.State; state != tt.wantState {
+				t.Errorf("expected circuit breaker %s, got %s", tt.wantState, state)
+			}
+			if len(tt.failing) < 4 && provider.records["home.example.com A"] != "203.0.113.1" {
+				t.Errorf("IPv4 record of home was not updated: %v", provider.records)
+			}
+		})
+	}
+}
+
+func TestReconcileFansOutToEverySubdomain(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+	provider.records["www.example.com A"] = "203.0.113.9"
+	provider.records["example.com A"] = "203.0.113.1"
+
+	shared := newTestShared(t)
+	spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+		spec.Subdomains = []string{"www", "@"}
+	})
+	detector := &staticDetector{address: "203.0.113.1"}
+	instance := newTestInstance(t, shared, spec, false, map[config.NetworkStack]*staticDetector{
+		config.IPv4: detector,
+	})
+
+	results, err := instance.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The address is detected once and published to every name
+	if detector.detections != 1 {
+		t.Errorf("expected a single detection, got %d", detector.detections)
+	}
+	want := map[string]Action{
+		"home.example.com": ActionCreate,
+		"www.example.com":  ActionUpdate,
+		"example.com":      ActionNone,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+	for _, result := range results {
+		if result.Action != want[result.Record] || result.Address != "203.0.113.1" || result.Err != nil {
+			t.Errorf("%s: expected action %s, got %+v", result.Record, want[result.Record], result)
+		}
+		if got := provider.records[result.Record+" A"]; got != "203.0.113.1" {
+			t.Errorf("%s: expected the record to point to 203.0.113.1, got %q", result.Record, got)
+		}
+	}
+}
+
+func TestReconcileContinuesAfterBrokenSubdomain(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+	provider.failing["www.example.com A"] = errors.New("record is locked")
+
+	shared := newTestShared(t)
+	spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+		spec.Subdomains = []string{"www", "api"}
+	})
+	instance := newTestInstance(t, shared, spec, false, map[config.NetworkStack]*staticDetector{
+		config.IPv4: {address: "203.0.113.1"},
+	})
+
+	results, err := instance.Reconcile(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 problem(s)") {
+		t.Fatalf("expected the broken name to be reported, got %v", err)
+	}
+	for _, result := range results {
+		if failed := result.Err != nil; failed != (result.Record == "www.example.com") {
+			t.Errorf("%s: unexpected error %v", result.Record, result.Err)
+		}
+	}
+	if provider.records["home.example.com A"] != "203.0.113.1" || provider.records["api.example.com A"] != "203.0.113.1" {
+		t.Errorf("names after the broken one were not updated: %v", provider.records)
+	}
+}
+
+func TestDualStackWithMissingStack(t *testing.T) {
+	tests := []struct {
+		name      string
+		detectors map[config.NetworkStack]*staticDetector
+		missing   dns.RecordType
+	}{
+		{
+			name: "no IPv6 address",
+			detectors: map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+				config.IPv6: {err: errors.New("no IPv6 address")},
+			},
+			missing: dns.AAAA,
+		},
+		{
+			name: "no IPv4 address",
+			detectors: map[config.NetworkStack]*staticDetector{
+				config.IPv4: {err: errors.New("no IPv4 address")},
+				config.IPv6: {address: "2001:db8::1"},
+			},
+			missing: dns.A,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+				spec.Stack = config.DualStack
+			})
+			instance := newTestInstance(t, shared, spec, false, tt.detectors)
+
+			results, err := instance.Reconcile(context.Background())
+			if err == nil {
+				t.Fatal("expected the missing stack to be reported")
+			}
+			if len(results) != 2 {
+				t.Fatalf("expected a result for A and AAAA, got %d", len(results))
+			}
+			for _, result := range results {
+				if result.Type == tt.missing {
+					if result.Err == nil || result.Action != "" {
+						t.Errorf("expected the %s record to fail without an action, got %+v", result.Type, result)
+					}
+					if _, ok := provider.records["home.example.com "+string(result.Type)]; ok {
+						t.Errorf("%s record was published without an address", result.Type)
+					}
+					continue
+				}
+				if result.Err != nil || result.Action != ActionCreate {
+					t.Errorf("expected the %s record to be created, got %+v", result.Type, result)
+				}
+				if got := provider.records["home.example.com "+string(result.Type)]; got != result.Address {
+					t.Errorf("expected the %s record to point to %s, got %q", result.Type, result.Address, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackAfterRetriesExhausted(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector *staticDetector
+		canceled bool
+
+		// want is the published address, empty when nothing is published
+		want           string
+		wantDetections int
+	}{
+		{
+			name:           "detection failing once is retried",
+			detector:       &staticDetector{address: "203.0.113.1", err: errors.New("timeout"), failures: 1},
+			want:           "203.0.113.1",
+			wantDetections: 2,
+		},
+		{
+			name:           "fallback once every attempt failed",
+			detector:       &staticDetector{err: errors.New("timeout")},
+			want:           "192.0.2.1",
+			wantDetections: 3,
+		},
+		{
+			name:           "canceled run does not publish the fallback",
+			detector:       &staticDetector{err: errors.New("timeout")},
+			canceled:       true,
+			wantDetections: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+
+			shared := newTestShared(t)
+			spec := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+				backoff := config.Duration(time.Millisecond)
+				spec.Retry = &config.RetrySpec{MaxAttempts: utils.IntPtr(3), InitialBackoff: &backoff}
+				spec.DetectionStrategy = &config.DetectionStrategySpec{FallbackIPv4: utils.StringPtr("192.0.2.1")}
+			})
+			instance := newTestInstance(t, shared, spec, false, map[config.NetworkStack]*staticDetector{
+				config.IPv4: tt.detector,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			results, err := instance.Reconcile(ctx)
+			if tt.want == "" {
+				if err == nil || len(results) != 1 || results[0].Err == nil {
+					t.Fatalf("expected the detection to fail, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.detector.detections != tt.wantDetections {
+				t.Errorf("expected %d detections, got %d", tt.wantDetections, tt.detector.detections)
+			}
+			if got := provider.records["home.example.com A"]; got != tt.want {
+				t.Errorf("expected the record to point to %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -18,72 +18,365 @@ package ddns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/masteryyh/micro-ddns/internal/breaker"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/ip"
 )
 
+// ManagerOptions are settings of DDNSInstanceManager shared by every instance
+type ManagerOptions struct {
+	// RunOnStart updates an instance as soon as its job is registered,
+	// unless its spec says otherwise
+	RunOnStart bool
+
+	// RunOnStartStagger is the delay between the startup updates of two
+	// instances, so they don't hit the same provider at the same time
+	RunOnStartStagger time.Duration
+
+	// DryRun makes every instance report changes instead of making them
+	DryRun bool
+
+	// ShutdownTimeout bounds the deletion of records of instances with
+	// deleteOnShutdown once the manager is stopped
+	ShutdownTimeout time.Duration
+}
+
 type DDNSInstanceManager struct {
 	instances map[string]*DDNSInstance
+	jobs      map[string]gocron.Job
+	watchers  map[string]context.CancelFunc
+	digests   map[string]*digestJob
 	specs     []*config.DDNSSpec
 	scheduler gocron.Scheduler
+	shared    *Shared
+	options   ManagerOptions
 	logger    *slog.Logger
 	wg        *sync.WaitGroup
+
+	// ctx is the context passed to Start, jobs registered by Reload use it as well
+	ctx  context.Context
+	lock sync.Mutex
+}
+
+func (m *DDNSInstanceManager) newInstance(spec *config.DDNSSpec) (*DDNSInstance, error) {
+	instanceLogger := m.logger.With(slog.Group("component", "type", "instance", "name", spec.Name))
+	return NewDDNSInstance(spec, m.shared, m.options.DryRun, instanceLogger)
 }
 
-func NewDDNSInstanceManager(specs []*config.DDNSSpec, scheduler gocron.Scheduler, logger *slog.Logger, wg *sync.WaitGroup) (*DDNSInstanceManager, error) {
-	instances := make(map[string]*DDNSInstance, len(specs))
+func NewDDNSInstanceManager(specs []*config.DDNSSpec, scheduler gocron.Scheduler, shared *Shared, options ManagerOptions, logger *slog.Logger, wg *sync.WaitGroup) (*DDNSInstanceManager, error) {
+	manager := &DDNSInstanceManager{
+		instances: make(map[string]*DDNSInstance, len(specs)),
+		jobs:      make(map[string]gocron.Job, len(specs)),
+		watchers:  make(map[string]context.CancelFunc),
+		digests:   make(map[string]*digestJob),
+		specs:     specs,
+		scheduler: scheduler,
+		shared:    shared,
+		options:   options,
+		logger:    logger,
+		wg:        wg,
+	}
+
 	for _, spec := range specs {
-		if _, ok := instances[spec.Name]; ok {
+		if _, ok := manager.instances[spec.Name]; ok {
 			return nil, fmt.Errorf("DDNS instance %s already exists", spec.Name)
 		}
 
-		instanceLogger := logger.With(slog.Group("component", "type", "instance", "name", spec.Name))
-		instance, err := NewDDNSInstance(spec, instanceLogger)
+		instance, err := manager.newInstance(spec)
 		if err != nil {
 			return nil, err
 		}
-		instances[spec.Name] = instance
+		manager.instances[spec.Name] = instance
 	}
 
 	wg.Add(1)
-	return &DDNSInstanceManager{
-		instances: instances,
-		specs:     specs,
-		scheduler: scheduler,
-		logger:    logger,
-		wg:        wg,
-	}, nil
+	return manager, nil
 }
 
-func (m *DDNSInstanceManager) Start(parentCtx context.Context) {
-	for name, instance := range m.instances {
-		m.logger.Info("registering DDNS task", "name", name)
-		job, err := m.scheduler.NewJob(gocron.CronJob(instance.spec.Cron, false), gocron.NewTask(func(ctx context.Context, instance *DDNSInstance) {
-			err := instance.DoUpdate(ctx)
-			if err != nil {
-				m.logger.Error("failed to handle DNS update", "name", instance.spec.Name, "err", err)
-				return
-			}
-			m.logger.Info("successfully updated DNS record", "name", instance.spec.Name)
-		}, parentCtx, instance))
+// runOnStart reports if an instance should be updated as soon as its job is registered
+func (m *DDNSInstanceManager) runOnStart(spec *config.DDNSSpec) bool {
+	if spec.RunOnStart != nil {
+		return *spec.RunOnStart
+	}
+	return m.options.RunOnStart
+}
+
+// jobDefinition returns the schedule of a DDNS spec, either a cron expression
+// or a fixed interval with optional random jitter
+func jobDefinition(spec *config.DDNSSpec) gocron.JobDefinition {
+	if spec.Interval == nil {
+		return gocron.CronJob(spec.Cron, false)
+	}
+
+	interval := spec.Interval.Duration()
+	if spec.IntervalJitter != nil && *spec.IntervalJitter > 0 {
+		return gocron.DurationRandomJob(interval, interval+spec.IntervalJitter.Duration())
+	}
+	return gocron.DurationJob(interval)
+}
+
+// startAt returns the job option running a job after delay, then following its schedule
+func startAt(delay time.Duration) gocron.JobOption {
+	if delay <= 0 {
+		return gocron.WithStartAt(gocron.WithStartImmediately())
+	}
+	return gocron.WithStartAt(gocron.WithStartDateTime(time.Now().Add(delay)))
+}
+
+// startOptions returns the job options of an instance running on start, slot
+// counts the instances started so far so each of them is staggered after the other
+func (m *DDNSInstanceManager) startOptions(instance *DDNSInstance, slot *int) []gocron.JobOption {
+	if !m.runOnStart(instance.spec) {
+		return nil
+	}
+
+	delay := time.Duration(*slot) * m.options.RunOnStartStagger
+	*slot++
+	m.logger.Info("DDNS task will run on start", "name", instance.spec.Name, "delay", delay.String())
+	return []gocron.JobOption{startAt(delay)}
+}
+
+func (m *DDNSInstanceManager) registerJob(instance *DDNSInstance, options ...gocron.JobOption) error {
+	job, err := m.newJob(instance, options...)
+	if err != nil {
+		return err
+	}
+	m.jobs[instance.spec.Name] = job
+	return nil
+}
+
+// newJob schedules the updates of instance without tracking the job, so a
+// rebuilt instance only replaces the previous one once its job exists
+func (m *DDNSInstanceManager) newJob(instance *DDNSInstance, options ...gocron.JobOption) (gocron.Job, error) {
+	name := instance.spec.Name
+	m.logger.Info("registering DDNS task", "name", name)
+	// Runs of the same instance never overlap, a run due while the previous one
+	// is still going is skipped
+	options = append(options, gocron.WithSingletonMode(gocron.LimitModeReschedule))
+	job, err := m.scheduler.NewJob(jobDefinition(instance.spec), gocron.NewTask(func(ctx context.Context, instance *DDNSInstance) {
+		err := instance.DoUpdate(ctx)
+		if errors.Is(err, breaker.ErrOpen) {
+			m.logger.Debug("circuit breaker is open, skipped DNS update", "name", instance.spec.Name)
+			return
+		}
 		if err != nil {
-			m.logger.Error("failed to create job", "name", name, "err", err)
+			m.logger.Error("failed to handle DNS update", "name", instance.spec.Name, "err", err)
 			return
 		}
+		m.logger.Info("successfully updated DNS record", "name", instance.spec.Name)
+	}, m.ctx, instance), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("created job", "name", name, "id", job.ID().String())
+	return job, nil
+}
+
+func (m *DDNSInstanceManager) removeJob(name string) {
+	job, ok := m.jobs[name]
+	if !ok {
+		return
+	}
+
+	if err := m.scheduler.RemoveJob(job.ID()); err != nil {
+		m.logger.Error("failed to remove job", "name", name, "err", err)
+	}
+	delete(m.jobs, name)
+	m.logger.Info("removed job", "name", name, "id", job.ID().String())
+}
+
+func (m *DDNSInstanceManager) Start(parentCtx context.Context) {
+	m.lock.Lock()
+	m.ctx = parentCtx
+
+	// Register in name order so instances running on start are staggered predictably
+	names := make([]string, 0, len(m.instances))
+	for name := range m.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		m.logger.Info("created cron job", "name", name, "id", job.ID().String())
+	slot := 0
+	for _, name := range names {
+		instance := m.instances[name]
+		if err := m.registerJob(instance, m.startOptions(instance, &slot)...); err != nil {
+			m.logger.Error("failed to create job", "name", name, "err", err)
+			m.lock.Unlock()
+			return
+		}
 	}
 
+	m.syncDigests()
 	m.scheduler.Start()
+	m.syncWatchers()
+	m.lock.Unlock()
 
 	<-parentCtx.Done()
 	m.logger.Info("shutting down ddns scheduler")
 	if err := m.scheduler.Shutdown(); err != nil {
 		m.logger.Error(fmt.Sprintf("failed shutting down ddns scheduler: %v", err))
 	}
+	m.deleteRecords()
 	m.wg.Done()
 }
+
+// deleteRecords deletes the records of every instance with deleteOnShutdown,
+// giving up on the ones not done within the shutdown timeout
+func (m *DDNSInstanceManager) deleteRecords() {
+	m.lock.Lock()
+	var instances []*DDNSInstance
+	for _, instance := range m.instances {
+		if instance.spec.IsDeleteOnShutdown() {
+			instances = append(instances, instance)
+		}
+	}
+	m.lock.Unlock()
+
+	if len(instances) == 0 {
+		return
+	}
+
+	m.logger.Info("deleting DNS records on shutdown", "instances", len(instances), "timeout", m.options.ShutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), m.options.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, instance := range instances {
+		wg.Add(1)
+		go func(instance *DDNSInstance) {
+			defer wg.Done()
+			if err := instance.DeleteRecords(ctx, nil); err != nil {
+				m.logger.Error("failed to delete DNS records", "name", instance.spec.Name, "err", err)
+				return
+			}
+			m.logger.Info("deleted DNS records", "name", instance.spec.Name)
+		}(instance)
+	}
+	wg.Wait()
+}
+
+// deleteDropped deletes in the background the records of a removed or rebuilt
+// instance with deleteOnShutdown, except those still managed by its
+// replacement in keep
+func (m *DDNSInstanceManager) deleteDropped(instance *DDNSInstance, keep map[string]bool) {
+	if !instance.spec.IsDeleteOnShutdown() {
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), m.options.ShutdownTimeout)
+		defer cancel()
+
+		if err := instance.DeleteRecords(ctx, keep); err != nil {
+			m.logger.Error("failed to delete dropped DNS records", "name", instance.spec.Name, "err", err)
+			return
+		}
+		m.logger.Info("deleted dropped DNS records", "name", instance.spec.Name)
+	}()
+}
+
+// Reload reconciles running jobs with a new set of validated DDNS specs:
+// jobs of removed specs are dropped, new specs get a job, and instances whose
+// spec, detection or provider changed are rebuilt. An instance that cannot
+// be rebuilt keeps running with its previous spec.
+func (m *DDNSInstanceManager) Reload(specs []*config.DDNSSpec) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.ctx == nil {
+		return fmt.Errorf("DDNS instance manager is not started")
+	}
+
+	wanted := make(map[string]*config.DDNSSpec, len(specs))
+	for _, spec := range specs {
+		if _, ok := wanted[spec.Name]; ok {
+			return fmt.Errorf("DDNS instance %s already exists", spec.Name)
+		}
+		wanted[spec.Name] = spec
+	}
+
+	for name := range m.instances {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+
+		m.logger.Info("DDNS spec removed, stopping instance", "name", name)
+		m.removeJob(name)
+		m.deleteDropped(m.instances[name], nil)
+		delete(m.instances, name)
+		m.shared.Digests.Forget(name)
+		m.shared.Notifications.Forget(name, nil)
+	}
+
+	// New and rebuilt instances follow the run on start setting as well
+	slot := 0
+	for _, spec := range specs {
+		old, exists := m.instances[spec.Name]
+
+		// DeepEqual follows the resolved detection and provider specs as well,
+		// so a change in any of them triggers a rebuild
+		if exists && reflect.DeepEqual(old.spec, spec) {
+			continue
+		}
+
+		instance, err := m.newInstance(spec)
+		if err != nil {
+			m.logger.Error("failed to rebuild DDNS instance, keeping previous one", "name", spec.Name, "err", err)
+			continue
+		}
+
+		// The previous job keeps running until the new one is registered
+		job, err := m.newJob(instance, m.startOptions(instance, &slot)...)
+		if err != nil {
+			m.logger.Error("failed to create job, keeping previous instance", "name", spec.Name, "err", err)
+			continue
+		}
+
+		if exists {
+			m.logger.Info("DDNS spec changed, rebuilding instance", "name", spec.Name)
+			m.removeJob(spec.Name)
+			m.shared.Digests.Forget(spec.Name)
+			m.shared.Notifications.Forget(spec.Name, instance.stateKeys())
+			m.deleteDropped(old, instance.stateKeys())
+		} else {
+			m.logger.Info("DDNS spec added, creating instance", "name", spec.Name)
+		}
+		m.jobs[spec.Name] = job
+		m.instances[spec.Name] = instance
+	}
+
+	m.syncWatchers()
+	m.syncDigests()
+	m.prunePools()
+	m.specs = specs
+	return nil
+}
+
+// prunePools drops the shared providers and detectors no instance uses any
+// more, instances being deleted in the background keep the ones they hold
+func (m *DDNSInstanceManager) prunePools() {
+	providers := make(map[string]bool)
+	detectors := make(map[string]bool)
+	for _, instance := range m.instances {
+		providers[instance.spec.GetProviderSpec().PoolKey()] = true
+		for _, stack := range instance.spec.GetStacks() {
+			for _, detection := range instance.spec.GetDetectionSpecs() {
+				detectors[ip.DetectorKey(detection, stack)] = true
+			}
+		}
+	}
+	m.shared.Providers.Retain(providers)
+	m.shared.Detectors.Retain(detectors)
+}
@@ -0,0 +1,602 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/dns"
+	"github.com/masteryyh/micro-ddns/internal/history"
+	"github.com/masteryyh/micro-ddns/internal/ip"
+	"github.com/masteryyh/micro-ddns/internal/state"
+	"github.com/masteryyh/micro-ddns/pkg/utils"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeProvider keeps DNS records in memory and records every call made by
+// the handlers it creates
+type fakeProvider struct {
+	// records maps "fqdn type" to the address of the record
+	records map[string]string
+
+	// failing makes every call on a record fail with the given error
+	failing map[string]error
+
+	// buildErr makes the creation of handlers fail
+	buildErr error
+
+	calls   []string
+	deleted chan string
+	lock    sync.Mutex
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		records: make(map[string]string),
+		failing: make(map[string]error),
+		deleted: make(chan string, 16),
+	}
+}
+
+// install makes every instance created by the test use p as its provider
+func (p *fakeProvider) install(t *testing.T) {
+	previous := newHandler
+	newHandler = p.newHandler
+	t.Cleanup(func() {
+		newHandler = previous
+	})
+}
+
+func (p *fakeProvider) newHandler(record dns.Record, _ *config.DNSProviderSpec, _ *dns.Provider, _ *slog.Logger) (dns.DNSUpdateHandler, error) {
+	if p.buildErr != nil {
+		return nil, p.buildErr
+	}
+	return &fakeHandler{
+		provider: p,
+		key:      record.FQDN() + " " + string(record.Type),
+	}, nil
+}
+
+func (p *fakeProvider) call(op string, key string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.calls = append(p.calls, op+" "+key)
+	return p.failing[key]
+}
+
+// recordedCalls returns the calls made so far as "op fqdn type"
+func (p *fakeProvider) recordedCalls() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.calls...)
+}
+
+// waitDeleted waits until the record key was deleted
+func (p *fakeProvider) waitDeleted(t *testing.T, key string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case deleted := <-p.deleted:
+			if deleted == key {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("record %s was not deleted", key)
+		}
+	}
+}
+
+type fakeHandler struct {
+	provider *fakeProvider
+	key      string
+}
+
+func (h *fakeHandler) Get(context.Context) (string, error) {
+	if err := h.provider.call("get", h.key); err != nil {
+		return "", err
+	}
+	h.provider.lock.Lock()
+	defer h.provider.lock.Unlock()
+	return h.provider.records[h.key], nil
+}
+
+func (h *fakeHandler) Create(_ context.Context, address string) error {
+	return h.set("create", address)
+}
+
+func (h *fakeHandler) Update(_ context.Context, address string) error {
+	return h.set("update", address)
+}
+
+func (h *fakeHandler) set(op string, address string) error {
+	if err := h.provider.call(op, h.key); err != nil {
+		return err
+	}
+	h.provider.lock.Lock()
+	defer h.provider.lock.Unlock()
+	h.provider.records[h.key] = address
+	return nil
+}
+
+func (h *fakeHandler) Delete(context.Context) error {
+	if err := h.provider.call("delete", h.key); err != nil {
+		return err
+	}
+	h.provider.lock.Lock()
+	delete(h.provider.records, h.key)
+	h.provider.lock.Unlock()
+	h.provider.deleted <- h.key
+	return nil
+}
+
+// newTestSpec returns a validated spec managing name.example.com, edit
+// changes it before validation
+func newTestSpec(t *testing.T, name string, edit ...func(spec *config.DDNSSpec)) *config.DDNSSpec {
+	t.Helper()
+	spec := &config.DDNSSpec{
+		Name:      name,
+		Domain:    "example.com",
+		Subdomain: name,
+		Stack:     config.IPv4,
+		Cron:      "0 0 1 1 *",
+		Provider: &config.DNSProviderSpec{
+			RFC2136: &config.RFC2136Spec{Address: "192.0.2.53"},
+		},
+		Detection: &config.AddressDetectionSpec{
+			API: &config.ThirdPartyServiceSpec{URL: "https://detect.example.com"},
+		},
+		Retry: &config.RetrySpec{MaxAttempts: utils.IntPtr(1)},
+	}
+	for _, e := range edit {
+		e(spec)
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func newTestShared(t *testing.T) *Shared {
+	t.Helper()
+	store, err := state.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, err := history.Open(100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		recorder.Close()
+	})
+	return NewShared(store, recorder, discardLogger)
+}
+
+// recordingScheduler remembers the jobs that existed whenever a job was created
+type recordingScheduler struct {
+	gocron.Scheduler
+	existing [][]string
+}
+
+func (s *recordingScheduler) NewJob(definition gocron.JobDefinition, task gocron.Task, options ...gocron.JobOption) (gocron.Job, error) {
+	var ids []string
+	for _, job := range s.Scheduler.Jobs() {
+		ids = append(ids, job.ID().String())
+	}
+	s.existing = append(s.existing, ids)
+	return s.Scheduler.NewJob(definition, task, options...)
+}
+
+// newTestManager returns a manager of specs whose jobs are registered but
+// never run, as its scheduler is not started
+func newTestManager(t *testing.T, shared *Shared, specs ...*config.DDNSSpec) (*DDNSInstanceManager, *recordingScheduler) {
+	t.Helper()
+	scheduler, err := gocron.NewScheduler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		scheduler.Shutdown()
+	})
+	recording := &recordingScheduler{Scheduler: scheduler}
+
+	manager, err := NewDDNSInstanceManager(specs, recording, shared, ManagerOptions{ShutdownTimeout: 5 * time.Second}, discardLogger, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	manager.ctx = ctx
+	for _, instance := range manager.instances {
+		if err := manager.registerJob(instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager, recording
+}
+
+func jobIDs(t *testing.T, scheduler gocron.Scheduler) map[string]bool {
+	t.Helper()
+	ids := make(map[string]bool)
+	for _, job := range scheduler.Jobs() {
+		ids[job.ID().String()] = true
+	}
+	return ids
+}
+
+// ownRecord marks the A record of spec as created by micro-ddns in the store
+func ownRecord(t *testing.T, shared *Shared, spec *config.DDNSSpec) {
+	t.Helper()
+	fqdn := spec.Subdomain + "." + spec.Domain
+	key := state.Key(spec.Name, spec.GetProviderSpec().PoolKey(), fqdn, string(dns.A))
+	if err := shared.Store.Put(key, state.RecordState{Address: "203.0.113.1", LastSuccess: time.Now(), Owned: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadAddsSpec(t *testing.T) {
+	newFakeProvider().install(t)
+	shared := newTestShared(t)
+	manager, scheduler := newTestManager(t, shared, newTestSpec(t, "home"))
+
+	if err := manager.Reload([]*config.DDNSSpec{newTestSpec(t, "home"), newTestSpec(t, "office")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manager.instances) != 2 || manager.instances["office"] == nil {
+		t.Fatalf("expected instances home and office, got %v", manager.instances)
+	}
+	job, ok := manager.jobs["office"]
+	if !ok {
+		t.Fatal("no job registered for office")
+	}
+	ids := jobIDs(t, scheduler)
+	if len(ids) != 2 || !ids[job.ID().String()] {
+		t.Errorf("expected 2 jobs including the one of office, got %v", ids)
+	}
+}
+
+func TestReloadRemovesSpec(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+	shared := newTestShared(t)
+
+	office := newTestSpec(t, "office", func(spec *config.DDNSSpec) {
+		spec.DeleteOnShutdown = utils.BoolPtr(true)
+	})
+	ownRecord(t, shared, office)
+	manager, scheduler := newTestManager(t, shared, newTestSpec(t, "home"), office)
+	officeJob := manager.jobs["office"].ID().String()
+
+	if err := manager.Reload([]*config.DDNSSpec{newTestSpec(t, "home")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := manager.instances["office"]; ok {
+		t.Error("instance office is still running")
+	}
+	if _, ok := manager.jobs["office"]; ok {
+		t.Error("job of office is still tracked")
+	}
+	if jobIDs(t, scheduler)[officeJob] {
+		t.Error("job of office is still scheduled")
+	}
+	provider.waitDeleted(t, "office.example.com A")
+}
+
+func TestReloadRebuildsChangedSpec(t *testing.T) {
+	newFakeProvider().install(t)
+	shared := newTestShared(t)
+	manager, scheduler := newTestManager(t, shared, newTestSpec(t, "home"))
+	previous := manager.instances["home"]
+	previousJob := manager.jobs["home"].ID().String()
+
+	changed := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+		spec.Cron = "*/5 * * * *"
+	})
+	if err := manager.Reload([]*config.DDNSSpec{changed}); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := manager.instances["home"]
+	if instance == previous || instance.spec != changed {
+		t.Fatal("instance home was not rebuilt with the changed spec")
+	}
+	ids := jobIDs(t, scheduler)
+	if len(ids) != 1 || ids[previousJob] || !ids[manager.jobs["home"].ID().String()] {
+		t.Errorf("expected only the new job of home, got %v", ids)
+	}
+
+	// The new job is created while the previous one still runs
+	existing := scheduler.existing[len(scheduler.existing)-1]
+	if len(existing) != 1 || existing[0] != previousJob {
+		t.Errorf("expected the previous job to exist when the new one was created, got %v", existing)
+	}
+}
+
+func TestReloadUnchangedSpecKeepsInstance(t *testing.T) {
+	newFakeProvider().install(t)
+	shared := newTestShared(t)
+	manager, scheduler := newTestManager(t, shared, newTestSpec(t, "home"))
+	previous := manager.instances["home"]
+	created := len(scheduler.existing)
+
+	if err := manager.Reload([]*config.DDNSSpec{newTestSpec(t, "home")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if manager.instances["home"] != previous {
+		t.Error("unchanged instance was rebuilt")
+	}
+	if len(scheduler.existing) != created {
+		t.Error("unchanged instance got a new job")
+	}
+}
+
+func TestReloadPrunesPools(t *testing.T) {
+	newFakeProvider().install(t)
+	shared := newTestShared(t)
+	home, office := newTestSpec(t, "home"), newTestSpec(t, "office")
+	manager, _ := newTestManager(t, shared, home, office)
+
+	type pooled struct {
+		provider *dns.Provider
+		detector ip.AddressDetector
+	}
+	get := func(spec *config.DDNSSpec) pooled {
+		return pooled{
+			provider: shared.Providers.Get(spec.GetProviderSpec()),
+			detector: shared.Detectors.Get(spec.GetDetectionSpecs()[0], config.IPv4),
+		}
+	}
+	previous := map[string]pooled{"home": get(home), "office": get(office)}
+
+	if err := manager.Reload([]*config.DDNSSpec{newTestSpec(t, "home")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the provider and detector of the live instance are still pooled
+	if got := get(home); got != previous["home"] {
+		t.Error("provider or detector of home was dropped")
+	}
+	if got := get(office); got.provider == previous["office"].provider || got.detector == previous["office"].detector {
+		t.Error("provider or detector of removed office is still pooled")
+	}
+}
+
+func TestReloadKeepsPreviousInstanceOnFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(provider *fakeProvider, spec *config.DDNSSpec)
+	}{
+		{
+			name: "instance cannot be built",
+			setup: func(provider *fakeProvider, _ *config.DDNSSpec) {
+				provider.buildErr = errors.New("invalid credential")
+			},
+		},
+		{
+			name: "job cannot be created",
+			setup: func(_ *fakeProvider, spec *config.DDNSSpec) {
+				spec.Cron = "not a cron expression"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			shared := newTestShared(t)
+			manager, scheduler := newTestManager(t, shared, newTestSpec(t, "home"))
+			previous := manager.instances["home"]
+			previousJob := manager.jobs["home"].ID().String()
+
+			changed := newTestSpec(t, "home", func(spec *config.DDNSSpec) {
+				spec.Subdomains = []string{"www"}
+			})
+			tt.setup(provider, changed)
+			if err := manager.Reload([]*config.DDNSSpec{changed}); err != nil {
+				t.Fatal(err)
+			}
+
+			if manager.instances["home"] != previous {
+				t.Error("previous instance was replaced")
+			}
+			if manager.jobs["home"].ID().String() != previousJob {
+				t.Error("previous job was replaced")
+			}
+			ids := jobIDs(t, scheduler)
+			if len(ids) != 1 || !ids[previousJob] {
+				t.Errorf("expected only the previous job, got %v", ids)
+			}
+		})
+	}
+}
+
+// waitCall waits until the provider got call
+func (p *fakeProvider) waitCall(t *testing.T, call string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, c := range p.recordedCalls() {
+			if c == call {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("provider did not get %s, got %v", call, p.recordedCalls())
+}
+
+func TestRunOnStartIsStaggered(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+	shared := newTestShared(t)
+	scheduler, err := gocron.NewScheduler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Instances built by the manager detect their address from a local server
+	detection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "203.0.113.1")
+	}))
+	t.Cleanup(detection.Close)
+	local := func(spec *config.DDNSSpec) {
+		spec.Detection.API.URL = detection.URL
+	}
+	skipped := func(spec *config.DDNSSpec) {
+		spec.RunOnStart = utils.BoolPtr(false)
+	}
+
+	const stagger = time.Hour
+	options := ManagerOptions{RunOnStart: true, RunOnStartStagger: stagger, ShutdownTimeout: 5 * time.Second}
+	specs := []*config.DDNSSpec{
+		newTestSpec(t, "d", local),
+		newTestSpec(t, "c", local, skipped),
+		newTestSpec(t, "b", local),
+		newTestSpec(t, "a", local),
+	}
+	var wg sync.WaitGroup
+	manager, err := NewDDNSInstanceManager(specs, scheduler, shared, options, discardLogger, &wg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	go manager.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	// expectNextRun checks that the job of name runs after about delay
+	expectNextRun := func(name string, delay time.Duration) {
+		t.Helper()
+		manager.lock.Lock()
+		job := manager.jobs[name]
+		manager.lock.Unlock()
+
+		next, err := job.NextRun()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := start.Add(delay); next.Before(want) || next.After(want.Add(time.Minute)) {
+			t.Errorf("expected %s to run at %s, got %s", name, want, next)
+		}
+	}
+
+	// Instances start in name order, each one a stagger after the previous
+	provider.waitCall(t, "get a.example.com A")
+	expectNextRun("b", stagger)
+	expectNextRun("d", 2*stagger)
+
+	// An instance not running on start waits for its cron schedule
+	manager.lock.Lock()
+	next, err := manager.jobs["c"].NextRun()
+	manager.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Month() != time.January || next.Day() != 1 || next.Sub(start) < 0 {
+		t.Errorf("expected c to wait for its cron schedule, got %s", next)
+	}
+
+	// Instances added by a reload are staggered among themselves, in the
+	// order of the config
+	start = time.Now()
+	if err := manager.Reload(append(specs, newTestSpec(t, "f", local), newTestSpec(t, "e", local))); err != nil {
+		t.Fatal(err)
+	}
+	provider.waitCall(t, "get f.example.com A")
+	expectNextRun("e", stagger)
+}
+
+func TestIntervalScheduling(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter time.Duration
+	}{
+		{name: "fixed interval"},
+		{name: "interval with jitter", jitter: 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeProvider().install(t)
+			shared := newTestShared(t)
+			schedule := func(spec *config.DDNSSpec) {
+				interval := config.Duration(time.Hour)
+				spec.Cron = ""
+				spec.Interval = &interval
+				if tt.jitter > 0 {
+					jitter := config.Duration(tt.jitter)
+					spec.IntervalJitter = &jitter
+				}
+			}
+			names := []string{"a", "b", "c", "d"}
+			var specs []*config.DDNSSpec
+			for _, name := range names {
+				specs = append(specs, newTestSpec(t, name, schedule))
+			}
+			manager, scheduler := newTestManager(t, shared, specs...)
+
+			start := time.Now()
+			scheduler.Start()
+			time.Sleep(50 * time.Millisecond)
+			earliest := start.Add(time.Hour)
+			latest := time.Now().Add(time.Hour + tt.jitter)
+
+			var first, last time.Time
+			for _, name := range names {
+				next, err := manager.jobs[name].NextRun()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if next.Before(earliest) || next.After(latest) {
+					t.Errorf("%s: expected the next run between %s and %s, got %s", name, earliest, latest, next)
+				}
+				if first.IsZero() || next.Before(first) {
+					first = next
+				}
+				if next.After(last) {
+					last = next
+				}
+			}
+
+			// Jitter spreads instances sharing an interval over the jitter
+			if spread := last.Sub(first); (tt.jitter > 0) != (spread > time.Second) {
+				t.Errorf("unexpected spread %s of the next runs with jitter %s", spread, tt.jitter)
+			}
+		})
+	}
+}
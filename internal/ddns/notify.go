@@ -0,0 +1,256 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/notify"
+	"github.com/masteryyh/micro-ddns/internal/state"
+)
+
+// subscription is a notifier receiving some events of an instance
+type subscription struct {
+	spec     *config.SubscriptionSpec
+	notifier notify.Notifier
+
+	// digest collects every result instead of sending events when the
+	// notifier is in digest mode
+	digest *notify.Digest
+}
+
+// notifications sends the events of an instance to its subscriptions, a
+// record failing on every run is only reported once it starts failing
+type notifications struct {
+	subscriptions []*subscription
+	state         *NotificationState
+}
+
+// NotificationState remembers which records are failing and when their
+// failure was last sent to each notifier. It is shared by every instance so
+// a rebuilt instance does not report a failure that was already sent.
+type NotificationState struct {
+	// failing are the keys of records whose last update failed
+	failing map[string]bool
+
+	// lastFailure is when the failure of a record was last sent, keyed by
+	// notifier name and then by record key
+	lastFailure map[string]map[string]time.Time
+	lock        sync.Mutex
+}
+
+func NewNotificationState() *NotificationState {
+	return &NotificationState{
+		failing:     make(map[string]bool),
+		lastFailure: make(map[string]map[string]time.Time),
+	}
+}
+
+// observe records whether the record of key failed, and reports whether it
+// just recovered from a failure
+func (s *NotificationState) observe(key string, failed bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if failed {
+		s.failing[key] = true
+		return false
+	}
+	if !s.failing[key] {
+		return false
+	}
+	delete(s.failing, key)
+	for _, sent := range s.lastFailure {
+		delete(sent, key)
+	}
+	return true
+}
+
+// dueFailures returns the failures not sent to notifier yet, or sent longer
+// than repeatInterval ago, and marks them as sent. A zero repeatInterval
+// sends a failure once until the record recovers.
+func (s *NotificationState) dueFailures(notifier string, failed []keyedRecord, repeatInterval time.Duration, now time.Time) []keyedRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sent, ok := s.lastFailure[notifier]
+	if !ok {
+		sent = make(map[string]time.Time)
+		s.lastFailure[notifier] = sent
+	}
+
+	var due []keyedRecord
+	for _, k := range failed {
+		last, ok := sent[k.key]
+		if ok && (repeatInterval == 0 || now.Sub(last) < repeatInterval) {
+			continue
+		}
+		sent[k.key] = now
+		due = append(due, k)
+	}
+	return due
+}
+
+// Forget drops the state of the records of DDNS spec name, except those
+// whose keys are in keep
+func (s *NotificationState) Forget(name string, keep map[string]bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prefix := name + "/"
+	forget := func(key string) bool {
+		return strings.HasPrefix(key, prefix) && !keep[key]
+	}
+	for key := range s.failing {
+		if forget(key) {
+			delete(s.failing, key)
+		}
+	}
+	for _, sent := range s.lastFailure {
+		for key := range sent {
+			if forget(key) {
+				delete(sent, key)
+			}
+		}
+	}
+}
+
+func newNotifications(spec *config.DDNSSpec, shared *Shared) (*notifications, error) {
+	n := &notifications{
+		state: shared.Notifications,
+	}
+
+	for _, subscriptionSpec := range spec.Notify {
+		notifierSpec := subscriptionSpec.GetNotifierSpec()
+		if notifierSpec.Digest != nil {
+			n.subscriptions = append(n.subscriptions, &subscription{
+				spec:   subscriptionSpec,
+				digest: shared.Digests.Get(notifierSpec.Name),
+			})
+			continue
+		}
+
+		notifier, err := notify.New(notifierSpec)
+		if err != nil {
+			return nil, err
+		}
+		n.subscriptions = append(n.subscriptions, &subscription{
+			spec:     subscriptionSpec,
+			notifier: notifier,
+		})
+	}
+	return n, nil
+}
+
+// keyedRecord is a record of a notification and the state key of the record
+type keyedRecord struct {
+	key    string
+	record notify.Record
+}
+
+func records(keyed []keyedRecord) []notify.Record {
+	list := make([]notify.Record, 0, len(keyed))
+	for _, k := range keyed {
+		list = append(list, k.record)
+	}
+	return list
+}
+
+// notify sends the events found in the results of a run
+func (n *DDNSInstance) notify(parentCtx context.Context, results []*RecordResult) {
+	if len(n.notifications.subscriptions) == 0 || n.dryRun {
+		return
+	}
+
+	notifications := n.notifications
+	provider := n.spec.GetProviderSpec().PoolKey()
+	var observed, changed, failed, recovered []keyedRecord
+	for _, result := range results {
+		k := keyedRecord{
+			key: state.Key(result.Name, provider, result.Record, string(result.Type)),
+			record: notify.Record{
+				Name:       result.Name,
+				FQDN:       result.Record,
+				Type:       string(result.Type),
+				OldAddress: result.PreviousAddress,
+				NewAddress: result.Address,
+			},
+		}
+		if result.Err != nil {
+			k.record.Error = result.Err.Error()
+		}
+		observed = append(observed, k)
+
+		if notifications.state.observe(k.key, result.Err != nil) {
+			recovered = append(recovered, k)
+		}
+		if result.Err != nil {
+			failed = append(failed, k)
+			continue
+		}
+		if result.Action == ActionCreate || result.Action == ActionUpdate {
+			changed = append(changed, k)
+		}
+	}
+
+	now := time.Now()
+	for _, s := range notifications.subscriptions {
+		if s.digest != nil {
+			for _, k := range observed {
+				s.digest.Observe(k.key, k.record)
+			}
+			continue
+		}
+
+		if s.spec.Wants(config.NotifyChanged) {
+			n.send(parentCtx, s, config.NotifyChanged, records(changed), now)
+		}
+		if s.spec.Wants(config.NotifyRecovered) {
+			n.send(parentCtx, s, config.NotifyRecovered, records(recovered), now)
+		}
+		if s.spec.Wants(config.NotifyFailed) {
+			var repeatInterval time.Duration
+			if s.spec.RepeatInterval != nil {
+				repeatInterval = s.spec.RepeatInterval.Duration()
+			}
+			due := notifications.state.dueFailures(s.spec.NotifierRef, failed, repeatInterval, now)
+			n.send(parentCtx, s, config.NotifyFailed, records(due), now)
+		}
+	}
+}
+
+func (n *DDNSInstance) send(parentCtx context.Context, s *subscription, event config.NotificationEvent, list []notify.Record, now time.Time) {
+	if len(list) == 0 {
+		return
+	}
+
+	message := &notify.Message{
+		Event:   event,
+		Name:    n.spec.Name,
+		Records: list,
+		Time:    now,
+	}
+	if err := s.notifier.Notify(parentCtx, message); err != nil {
+		n.logger.Error("failed to send notification", "name", n.spec.Name, "notifier", s.spec.NotifierRef, "event", string(event), "err", err)
+		return
+	}
+	n.logger.Info("sent notification", "name", n.spec.Name, "notifier", s.spec.NotifierRef, "event", string(event), "records", len(list))
+}
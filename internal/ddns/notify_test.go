@@ -0,0 +1,219 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/notify"
+)
+
+// recordingNotifier keeps the event of every message it was asked to send
+type recordingNotifier struct {
+	events []config.NotificationEvent
+	lock   sync.Mutex
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, message *notify.Message) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.events = append(n.events, message.Event)
+	return nil
+}
+
+func (n *recordingNotifier) sent() []config.NotificationEvent {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([]config.NotificationEvent(nil), n.events...)
+}
+
+// newNotifiedSpec returns the spec of newTestSpec subscribed to notifier ops,
+// failures are sent again after repeatInterval unless it is 0
+func newNotifiedSpec(t *testing.T, repeatInterval time.Duration, edit ...func(spec *config.DDNSSpec)) *config.DDNSSpec {
+	t.Helper()
+	spec := newTestSpec(t, "home", append([]func(spec *config.DDNSSpec){func(spec *config.DDNSSpec) {
+		subscription := &config.SubscriptionSpec{NotifierRef: "ops"}
+		if repeatInterval > 0 {
+			d := config.Duration(repeatInterval)
+			subscription.RepeatInterval = &d
+		}
+		spec.Notify = []*config.SubscriptionSpec{subscription}
+	}}, edit...)...)
+
+	// Subscriptions are resolved against the notifiers of the config
+	c := &config.Config{
+		DDNS:         []*config.DDNSSpec{spec},
+		Notification: []*config.NotifierSpec{{Name: "ops", Webhook: &config.WebhookNotifierSpec{URL: "https://hooks.example.com"}}},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// listen makes instance send its notifications to notifier
+func listen(instance *DDNSInstance, notifier notify.Notifier) {
+	for _, s := range instance.notifications.subscriptions {
+		s.notifier = notifier
+	}
+}
+
+// ageFailures makes every failure sent to ops look sent d earlier
+func ageFailures(shared *Shared, d time.Duration) {
+	state := shared.Notifications
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	for key, last := range state.lastFailure["ops"] {
+		state.lastFailure["ops"][key] = last.Add(-d)
+	}
+}
+
+func TestFailureNotificationRepeat(t *testing.T) {
+	tests := []struct {
+		name           string
+		repeatInterval time.Duration
+
+		// age is how long before the second run the failure looks sent
+		age  time.Duration
+		want []config.NotificationEvent
+	}{
+		{
+			name: "sent once without repeat interval",
+			age:  24 * time.Hour,
+			want: []config.NotificationEvent{config.NotifyFailed},
+		},
+		{
+			name:           "suppressed within repeat interval",
+			repeatInterval: time.Hour,
+			age:            30 * time.Minute,
+			want:           []config.NotificationEvent{config.NotifyFailed},
+		},
+		{
+			name:           "sent again after repeat interval",
+			repeatInterval: time.Hour,
+			age:            time.Hour,
+			want:           []config.NotificationEvent{config.NotifyFailed, config.NotifyFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newFakeProvider()
+			provider.install(t)
+			provider.failing["home.example.com A"] = errors.New("provider unavailable")
+
+			shared := newTestShared(t)
+			instance := newTestInstance(t, shared, newNotifiedSpec(t, tt.repeatInterval), false, map[config.NetworkStack]*staticDetector{
+				config.IPv4: {address: "203.0.113.1"},
+			})
+			notifier := &recordingNotifier{}
+			listen(instance, notifier)
+
+			for i := 0; i < 2; i++ {
+				if _, err := instance.Reconcile(context.Background()); err == nil {
+					t.Fatal("expected the run to fail")
+				}
+				ageFailures(shared, tt.age)
+			}
+
+			if got := notifier.sent(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected events %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRecoveredNotification(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+
+	shared := newTestShared(t)
+	detector := &staticDetector{address: "203.0.113.1"}
+	instance := newTestInstance(t, shared, newNotifiedSpec(t, 0), false, map[config.NetworkStack]*staticDetector{
+		config.IPv4: detector,
+	})
+	notifier := &recordingNotifier{}
+	listen(instance, notifier)
+
+	provider.failing["home.example.com A"] = errors.New("provider unavailable")
+	for i := 0; i < 2; i++ {
+		instance.Reconcile(context.Background())
+	}
+	delete(provider.failing, "home.example.com A")
+	for i := 0; i < 2; i++ {
+		instance.Reconcile(context.Background())
+	}
+
+	// A record failing again after it recovered is sent again
+	detector.err = errors.New("no address")
+	instance.Reconcile(context.Background())
+
+	want := []config.NotificationEvent{config.NotifyFailed, config.NotifyChanged, config.NotifyRecovered, config.NotifyFailed}
+	if got := notifier.sent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+}
+
+func TestNotificationStateSurvivesRebuild(t *testing.T) {
+	provider := newFakeProvider()
+	provider.install(t)
+	provider.failing["home.example.com A"] = errors.New("provider unavailable")
+
+	shared := newTestShared(t)
+	manager, _ := newTestManager(t, shared, newNotifiedSpec(t, 0))
+	notifier := &recordingNotifier{}
+	run := func() {
+		t.Helper()
+		instance := manager.instances["home"]
+		listen(instance, notifier)
+		if _, err := instance.Reconcile(context.Background()); err == nil {
+			t.Fatal("expected the run to fail")
+		}
+	}
+	run()
+
+	// A rebuilt instance knows the failure was already sent
+	changed := newNotifiedSpec(t, 0, func(spec *config.DDNSSpec) {
+		spec.Cron = "*/5 * * * *"
+	})
+	if err := manager.Reload([]*config.DDNSSpec{changed}); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	if got := notifier.sent(); len(got) != 1 {
+		t.Fatalf("expected the failure to be sent once across the rebuild, got %v", got)
+	}
+
+	// A removed spec is forgotten, so it reports the failure again once added back
+	if err := manager.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Reload([]*config.DDNSSpec{changed}); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	want := []config.NotificationEvent{config.NotifyFailed, config.NotifyFailed}
+	if got := notifier.sent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"log/slog"
+
+	"github.com/masteryyh/micro-ddns/internal/dns"
+	"github.com/masteryyh/micro-ddns/internal/history"
+	"github.com/masteryyh/micro-ddns/internal/ip"
+	"github.com/masteryyh/micro-ddns/internal/notify"
+	"github.com/masteryyh/micro-ddns/internal/state"
+)
+
+// Shared holds what every instance of a run shares, so instances using the
+// same detection or provider don't repeat the same work
+type Shared struct {
+	Store         *state.Store
+	History       *history.Recorder
+	Detectors     *ip.DetectorPool
+	Providers     *dns.ProviderPool
+	Digests       *notify.DigestPool
+	Notifications *NotificationState
+}
+
+func NewShared(store *state.Store, recorder *history.Recorder, logger *slog.Logger) *Shared {
+	return &Shared{
+		Store:         store,
+		History:       recorder,
+		Detectors:     ip.NewDetectorPool(logger),
+		Providers:     dns.NewProviderPool(),
+		Digests:       notify.NewDigestPool(),
+		Notifications: NewNotificationState(),
+	}
+}
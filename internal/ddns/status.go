@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/masteryyh/micro-ddns/internal/breaker"
+)
+
+// InstanceStatus is the health of a DDNS instance
+type InstanceStatus struct {
+	Name           string         `json:"name"`
+	CircuitBreaker breaker.Status `json:"circuitBreaker"`
+}
+
+// Status is the health of every DDNS instance, served by the status endpoint
+// and printed by the status command
+type Status struct {
+	Instances []InstanceStatus `json:"instances"`
+}
+
+// Problems describes every instance that is not healthy, i.e. its circuit
+// breaker is not closed
+func (s *Status) Problems() []string {
+	var problems []string
+	for _, instance := range s.Instances {
+		if instance.CircuitBreaker.State == breaker.Closed {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("instance %s: circuit breaker is %s after %d consecutive failure(s): %s",
+			instance.Name, instance.CircuitBreaker.State, instance.CircuitBreaker.ConsecutiveFailures, instance.CircuitBreaker.LastError))
+	}
+	return problems
+}
+
+// Status returns the health of every running instance sorted by name
+func (m *DDNSInstanceManager) Status() *Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	status := &Status{
+		Instances: make([]InstanceStatus, 0, len(m.instances)),
+	}
+	for _, instance := range m.instances {
+		status.Instances = append(status.Instances, instance.Status())
+	}
+	sort.Slice(status.Instances, func(i, j int) bool {
+		return status.Instances[i].Name < status.Instances[j].Name
+	})
+	return status
+}
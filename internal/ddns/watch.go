@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/ip"
+)
+
+// addressChangeDebounce is how long address changes of an interface must
+// settle before updates are triggered, a reconnect usually removes and adds
+// several addresses in a row
+const addressChangeDebounce = 2 * time.Second
+
+// watchedInterfaces returns the interfaces whose address changes trigger
+// updates of spec
+func watchedInterfaces(spec *config.DDNSSpec) []string {
+	var names []string
+	for _, detectionSpec := range spec.GetDetectionSpecs() {
+		if detectionSpec == nil || detectionSpec.Interface == nil || !detectionSpec.Interface.IsWatched() {
+			continue
+		}
+		names = append(names, detectionSpec.Interface.Name)
+	}
+	return names
+}
+
+// syncWatchers starts a watcher for every interface used by an instance and
+// stops those no longer used, the caller must hold the lock
+func (m *DDNSInstanceManager) syncWatchers() {
+	wanted := make(map[string]bool)
+	for _, instance := range m.instances {
+		for _, name := range watchedInterfaces(instance.spec) {
+			wanted[name] = true
+		}
+	}
+
+	for name, cancel := range m.watchers {
+		if !wanted[name] {
+			cancel()
+			delete(m.watchers, name)
+		}
+	}
+
+	for name := range wanted {
+		if _, exists := m.watchers[name]; exists {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.watchers[name] = cancel
+		go m.watchInterface(ctx, name)
+	}
+}
+
+// watchInterface triggers updates of every instance using interfaceName once
+// its address changes settle, until ctx is done
+func (m *DDNSInstanceManager) watchInterface(ctx context.Context, interfaceName string) {
+	logger := m.logger.With(slog.Group("component", "type", "watcher", "interface", interfaceName))
+	changes, err := ip.WatchAddressChanges(ctx, interfaceName, logger)
+	if err != nil {
+		if errors.Is(err, ip.ErrWatchNotSupported) {
+			logger.Debug("address changes are not watched, relying on schedule only", "err", err)
+		} else {
+			logger.Warn("failed to watch address changes, relying on schedule only", "err", err)
+		}
+		return
+	}
+	logger.Info("watching address changes")
+
+	timer := time.NewTimer(addressChangeDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			timer.Reset(addressChangeDebounce)
+		case <-timer.C:
+			logger.Info("address changed, triggering updates")
+			m.runInterfaceJobs(interfaceName)
+		}
+	}
+}
+
+// runInterfaceJobs drops the cached address of interfaceName and runs the
+// jobs of every instance using it now, jobs already running are not run twice
+func (m *DDNSInstanceManager) runInterfaceJobs(interfaceName string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0)
+	for name, instance := range m.instances {
+		if slices.Contains(watchedInterfaces(instance.spec), interfaceName) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// The address cached before the change is outdated, whatever its TTL
+	m.shared.Detectors.Invalidate(interfaceName)
+	for _, name := range names {
+		job, ok := m.jobs[name]
+		if !ok {
+			continue
+		}
+
+		if err := job.RunNow(); err != nil {
+			m.logger.Error("failed to trigger update", "name", name, "err", err)
+		}
+	}
+}
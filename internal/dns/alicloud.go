@@ -27,6 +27,7 @@ import (
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
 	"github.com/alibabacloud-go/tea/tea"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/retry"
 	"github.com/masteryyh/micro-ddns/pkg/utils"
 )
 
@@ -45,30 +46,31 @@ type AliCloudDNSUpdateHandler struct {
 	logger *slog.Logger
 }
 
-func NewAliCloudDNSUpdateHandler(ddns *config.DDNSSpec, aliSpec *config.AliCloudSpec, logger *slog.Logger) (*AliCloudDNSUpdateHandler, error) {
+func newAliCloudClient(aliSpec *config.AliCloudSpec) (*alidns.Client, error) {
 	clientConfig := &openapi.Config{
 		AccessKeyId:     &aliSpec.AccessKeyID,
 		AccessKeySecret: &aliSpec.AccessKeySecret,
 	}
-	client, err := alidns.NewClient(clientConfig)
+	return alidns.NewClient(clientConfig)
+}
+
+func NewAliCloudDNSUpdateHandler(record Record, aliSpec *config.AliCloudSpec, provider *Provider, logger *slog.Logger) (*AliCloudDNSUpdateHandler, error) {
+	client, err := sharedClient(provider, func() (*alidns.Client, error) {
+		return newAliCloudClient(aliSpec)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	recordType := A
-	if ddns.Stack == config.IPv6 {
-		recordType = AAAA
-	}
-
 	line := "default"
 	if aliSpec.Line != nil {
 		line = *aliSpec.Line
 	}
 
 	return &AliCloudDNSUpdateHandler{
-		domain:     ddns.Domain,
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain,
+		subdomain:  record.Subdomain,
+		recordType: record.Type,
 		line:       line,
 		client:     client,
 		logger:     logger,
@@ -213,3 +215,60 @@ func (h *AliCloudDNSUpdateHandler) Update(parentCtx context.Context, newAddress
 	}
 	return nil
 }
+
+func (h *AliCloudDNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.recordId == "" {
+		addr, err := h.Get(parentCtx)
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			h.logger.Debug("DNS record not found, nothing to delete")
+			return nil
+		}
+	}
+
+	h.logger.Debug("deleting DNS record", "id", h.recordId)
+
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	result, err := utils.RunWithContext(ctx, func() error {
+		_, err := h.client.DeleteDomainRecord(&alidns.DeleteDomainRecordRequest{
+			RecordId: &h.recordId,
+		})
+		if err != nil {
+			aliErr := &tea.SDKError{}
+			if errors.As(err, &aliErr) && aliErr.Code != nil && *aliErr.Code == "InvalidRR.NoExist" {
+				return nil
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if result[0] != nil {
+		return result[0].(error)
+	}
+	h.recordId = ""
+	return nil
+}
+
+func (h *AliCloudDNSUpdateHandler) SaveState() map[string]string {
+	return map[string]string{
+		"recordId": h.recordId,
+	}
+}
+
+func (h *AliCloudDNSUpdateHandler) RestoreState(state map[string]string) {
+	h.recordId = state["recordId"]
+}
+
+func (h *AliCloudDNSUpdateHandler) IsPermanent(err error) bool {
+	aliErr := &tea.SDKError{}
+	if errors.As(err, &aliErr) && aliErr.StatusCode != nil {
+		return *aliErr.StatusCode >= 400 && !retry.IsRetryableStatus(*aliErr.StatusCode)
+	}
+	return false
+}
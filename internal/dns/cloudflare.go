@@ -35,40 +35,38 @@ const (
 type CloudflareDNSUpdateHandler struct {
 	domain     string
 	subdomain  string
+	fqdn       string
 	recordType RecordType
 	zoneId     string
 	recordId   string
 
 	apiClient *cloudflare.API
+	provider  *Provider
 	logger    *slog.Logger
 }
 
-func NewCloudflareDNSUpdateHandler(ddns *config.DDNSSpec, cloudflareSpec *config.CloudflareSpec, logger *slog.Logger) (*CloudflareDNSUpdateHandler, error) {
-	var client *cloudflare.API
+func newCloudflareClient(cloudflareSpec *config.CloudflareSpec) (*cloudflare.API, error) {
 	if !utils.IsEmpty(cloudflareSpec.APIToken) {
-		c, err := cloudflare.NewWithAPIToken(utils.StringPtrToString(cloudflareSpec.APIToken))
-		if err != nil {
-			return nil, err
-		}
-		client = c
-	} else if !utils.IsEmpty(cloudflareSpec.GlobalAPIKey) && !utils.IsEmpty(cloudflareSpec.Email) {
-		c, err := cloudflare.New(utils.StringPtrToString(cloudflareSpec.GlobalAPIKey), utils.StringPtrToString(cloudflareSpec.Email))
-		if err != nil {
-			return nil, err
-		}
-		client = c
+		return cloudflare.NewWithAPIToken(utils.StringPtrToString(cloudflareSpec.APIToken))
 	}
+	return cloudflare.New(utils.StringPtrToString(cloudflareSpec.GlobalAPIKey), utils.StringPtrToString(cloudflareSpec.Email))
+}
 
-	recordType := A
-	if ddns.Stack == config.IPv6 {
-		recordType = AAAA
+func NewCloudflareDNSUpdateHandler(record Record, cloudflareSpec *config.CloudflareSpec, provider *Provider, logger *slog.Logger) (*CloudflareDNSUpdateHandler, error) {
+	client, err := sharedClient(provider, func() (*cloudflare.API, error) {
+		return newCloudflareClient(cloudflareSpec)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &CloudflareDNSUpdateHandler{
-		domain:     ddns.Domain,
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain,
+		subdomain:  record.Subdomain,
+		fqdn:       record.FQDN(),
+		recordType: record.Type,
 		apiClient:  client,
+		provider:   provider,
 		logger:     logger,
 	}, nil
 }
@@ -77,6 +75,12 @@ func (h *CloudflareDNSUpdateHandler) fetchZoneId(parentCtx context.Context) erro
 	zoneCtx, zoneCancel := context.WithTimeout(parentCtx, 30*time.Second)
 	defer zoneCancel()
 
+	if h.zoneId == "" {
+		if id, ok := h.provider.Zone(h.domain); ok {
+			h.zoneId = id
+		}
+	}
+
 	if h.zoneId == "" {
 		h.logger.Debug("looking for user's DNS zone")
 		zones, err := h.apiClient.ListZones(zoneCtx, h.domain)
@@ -95,6 +99,7 @@ func (h *CloudflareDNSUpdateHandler) fetchZoneId(parentCtx context.Context) erro
 	if h.zoneId == "" {
 		return fmt.Errorf("no corresponding DNS zone found")
 	}
+	h.provider.SetZone(h.domain, h.zoneId)
 	h.logger.Debug("found DNS zone ID " + h.zoneId)
 	return nil
 }
@@ -113,6 +118,7 @@ func (h *CloudflareDNSUpdateHandler) Get(parentCtx context.Context) (string, err
 		defer cancel()
 		records, _, err := h.apiClient.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(h.zoneId), cloudflare.ListDNSRecordsParams{
 			Type: string(h.recordType),
+			Name: h.fqdn,
 			ResultInfo: cloudflare.ResultInfo{
 				Page:    1,
 				PerPage: PerPageCount,
@@ -122,10 +128,9 @@ func (h *CloudflareDNSUpdateHandler) Get(parentCtx context.Context) (string, err
 			return "", err
 		}
 
-		fullDomain := h.subdomain + "." + h.domain
 		var id string
 		for _, record := range records {
-			if record.Name == fullDomain {
+			if record.Name == h.fqdn {
 				id = record.ID
 				break
 			}
@@ -166,7 +171,7 @@ func (h *CloudflareDNSUpdateHandler) Create(parentCtx context.Context, address s
 	h.logger.Debug("creating DNS record")
 	record, err := h.apiClient.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(h.zoneId), cloudflare.CreateDNSRecordParams{
 		Type:    string(h.recordType),
-		Name:    h.subdomain,
+		Name:    h.fqdn,
 		Content: address,
 		ID:      h.zoneId,
 		TTL:     CloudflareDefaultTTL,
@@ -195,11 +200,58 @@ func (h *CloudflareDNSUpdateHandler) Update(parentCtx context.Context, newAddres
 
 	h.logger.Debug("updating DNS record for record ID " + h.recordId)
 	_, err := h.apiClient.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(h.zoneId), cloudflare.UpdateDNSRecordParams{
+		ID:      h.recordId,
 		Type:    string(h.recordType),
-		Name:    h.subdomain,
+		Name:    h.fqdn,
 		Content: newAddress,
 		TTL:     CloudflareDefaultTTL,
 		Proxied: utils.BoolPtr(false),
 	})
 	return err
 }
+
+func (h *CloudflareDNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.zoneId == "" || h.recordId == "" {
+		addr, err := h.Get(parentCtx)
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			h.logger.Debug("DNS record not found, nothing to delete")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+
+	h.logger.Debug("deleting DNS record for record ID " + h.recordId)
+	err := h.apiClient.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(h.zoneId), h.recordId)
+	if err != nil {
+		cfError := &cloudflare.NotFoundError{}
+		if !errors.As(err, &cfError) {
+			return err
+		}
+	}
+	h.recordId = ""
+	return nil
+}
+
+func (h *CloudflareDNSUpdateHandler) SaveState() map[string]string {
+	return map[string]string{
+		"zoneId":   h.zoneId,
+		"recordId": h.recordId,
+	}
+}
+
+func (h *CloudflareDNSUpdateHandler) RestoreState(state map[string]string) {
+	h.zoneId = state["zoneId"]
+	h.recordId = state["recordId"]
+}
+
+func (h *CloudflareDNSUpdateHandler) IsPermanent(err error) bool {
+	authnErr := &cloudflare.AuthenticationError{}
+	authzErr := &cloudflare.AuthorizationError{}
+	requestErr := &cloudflare.RequestError{}
+	return errors.As(err, &authnErr) || errors.As(err, &authzErr) || errors.As(err, &requestErr)
+}
@@ -16,7 +16,11 @@ limitations under the License.
 
 package dns
 
-import "context"
+import (
+	"context"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
 
 type RecordType string
 
@@ -27,6 +31,34 @@ const (
 	PerPageCount = 500
 )
 
+// Record identifies the DNS record managed by a handler
+type Record struct {
+	// Domain is the zone the record belongs to
+	Domain string
+
+	// Subdomain is the name of the record relative to Domain in punycode,
+	// "@" for zone apex, a leading "*" label for a wildcard record
+	Subdomain string
+
+	Type RecordType
+}
+
+// FQDN returns the fully qualified name of the record without the trailing dot
+func (r Record) FQDN() string {
+	if r.Subdomain == config.ZoneApex || r.Subdomain == "" {
+		return r.Domain
+	}
+	return r.Subdomain + "." + r.Domain
+}
+
+// RecordTypeOf returns the record type used for addresses of stack
+func RecordTypeOf(stack config.NetworkStack) RecordType {
+	if stack == config.IPv6 {
+		return AAAA
+	}
+	return A
+}
+
 type DNSUpdateHandler interface {
 	// Get will get current IP address registered in DNS record
 	Get(parentCtx context.Context) (string, error)
@@ -36,4 +68,23 @@ type DNSUpdateHandler interface {
 
 	// Update will update DNS record with new address
 	Update(parentCtx context.Context, newAddress string) error
+
+	// Delete will delete the DNS record, a record that does not exist is not an error
+	Delete(parentCtx context.Context) error
+}
+
+// StatefulHandler is implemented by handlers that cache identifiers discovered
+// from the provider, so they can be saved and restored across restarts
+type StatefulHandler interface {
+	// SaveState returns the identifiers known by the handler
+	SaveState() map[string]string
+
+	// RestoreState restores identifiers saved by SaveState
+	RestoreState(state map[string]string)
+}
+
+// ErrorClassifier is implemented by handlers that can tell provider errors
+// retrying cannot fix, such as rejected credentials, from transient ones
+type ErrorClassifier interface {
+	IsPermanent(err error) bool
 }
@@ -27,6 +27,8 @@ import (
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	dnspod "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/dnspod/v20210323"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -43,41 +45,51 @@ type DNSPodDNSUpdateHandler struct {
 
 	domainId *uint64
 	recordId *uint64
-	client *dnspod.Client
-	logger *slog.Logger
+	client   *dnspod.Client
+	provider *Provider
+	logger   *slog.Logger
 }
 
-func NewDNSPodDNSUpdateHandler(ddns *config.DDNSSpec, spec *config.DNSPodSpec, logger *slog.Logger) (*DNSPodDNSUpdateHandler, error) {
+func newDNSPodClient(spec *config.DNSPodSpec) (*dnspod.Client, error) {
 	credential := common.NewCredential(spec.SecretID, spec.SecretKey)
 
 	pf := profile.NewClientProfile()
 	pf.HttpProfile.Endpoint = "dnspod.tencentcloudapi.com"
-	client, err := dnspod.NewClient(credential, "", profile.NewClientProfile())
+	return dnspod.NewClient(credential, "", profile.NewClientProfile())
+}
+
+func NewDNSPodDNSUpdateHandler(record Record, spec *config.DNSPodSpec, provider *Provider, logger *slog.Logger) (*DNSPodDNSUpdateHandler, error) {
+	client, err := sharedClient(provider, func() (*dnspod.Client, error) {
+		return newDNSPodClient(spec)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	recordType := AAAA
-	if ddns.Stack == config.IPv4 {
-		recordType = A
-	}
-
 	line := "0"
 	if spec.LineID != nil {
 		line = *spec.LineID
 	}
 
 	return &DNSPodDNSUpdateHandler{
-		domain:     ddns.Domain,
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain,
+		subdomain:  record.Subdomain,
+		recordType: record.Type,
 		line:       line,
 		client:     client,
+		provider:   provider,
 		logger:     logger,
 	}, nil
 }
 
 func (h *DNSPodDNSUpdateHandler) findDomainId(parentCtx context.Context) error {
+	if cached, ok := h.provider.Zone(h.domain); ok {
+		if id, err := strconv.ParseUint(cached, 10, 64); err == nil {
+			h.domainId = &id
+			return nil
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
 	defer cancel()
 
@@ -101,6 +113,7 @@ func (h *DNSPodDNSUpdateHandler) findDomainId(parentCtx context.Context) error {
 	if h.domainId == nil {
 		return fmt.Errorf("domain " + h.domain + " not exists in the account")
 	}
+	h.provider.SetZone(h.domain, strconv.FormatUint(*h.domainId, 10))
 	return nil
 }
 
@@ -220,3 +233,63 @@ func (h *DNSPodDNSUpdateHandler) Update(parentCtx context.Context, newAddress st
 	_, err := h.client.ModifyRecordWithContext(ctx, request)
 	return err
 }
+
+func (h *DNSPodDNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.domainId == nil || h.recordId == nil {
+		addr, err := h.Get(parentCtx)
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			h.logger.Debug("DNS record not found, nothing to delete")
+			return nil
+		}
+	}
+
+	h.logger.Debug("deleting DNS record for domain " + h.subdomain + "." + h.domain)
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	request := dnspod.NewDeleteRecordRequest()
+	request.Domain = utils.StringPtr("")
+	request.DomainId = h.domainId
+	request.RecordId = h.recordId
+	_, err := h.client.DeleteRecordWithContext(ctx, request)
+	if err != nil {
+		tcErr := &tcerrors.TencentCloudSDKError{}
+		if !errors.As(err, &tcErr) || tcErr.Code != dnspod.INVALIDPARAMETER_RECORDIDINVALID {
+			return err
+		}
+	}
+	h.recordId = nil
+	return nil
+}
+
+func (h *DNSPodDNSUpdateHandler) SaveState() map[string]string {
+	state := make(map[string]string)
+	if h.domainId != nil {
+		state["domainId"] = strconv.FormatUint(*h.domainId, 10)
+	}
+	if h.recordId != nil {
+		state["recordId"] = strconv.FormatUint(*h.recordId, 10)
+	}
+	return state
+}
+
+func (h *DNSPodDNSUpdateHandler) RestoreState(state map[string]string) {
+	if id, err := strconv.ParseUint(state["domainId"], 10, 64); err == nil {
+		h.domainId = &id
+	}
+	if id, err := strconv.ParseUint(state["recordId"], 10, 64); err == nil {
+		h.recordId = &id
+	}
+}
+
+func (h *DNSPodDNSUpdateHandler) IsPermanent(err error) bool {
+	tcErr := &tcerrors.TencentCloudSDKError{}
+	if errors.As(err, &tcErr) {
+		return strings.HasPrefix(tcErr.Code, "AuthFailure") ||
+			strings.HasPrefix(tcErr.Code, "UnauthorizedOperation") ||
+			strings.HasPrefix(tcErr.Code, "InvalidParameter")
+	}
+	return false
+}
@@ -29,6 +29,7 @@ import (
 	huaweiv2 "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2"
 	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2/model"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/retry"
 	"github.com/masteryyh/micro-ddns/pkg/utils"
 )
 
@@ -37,15 +38,17 @@ const HuaweiCloudDefaultTTL = 120
 type HuaweiCloudDNSUpdateHandler struct {
 	domain      string
 	subdomain   string
+	fqdn        string
 	recordType  RecordType
 	zoneId      string
 	recordSetId string
 
-	client *huaweiv2.DnsClient
-	logger *slog.Logger
+	client   *huaweiv2.DnsClient
+	provider *Provider
+	logger   *slog.Logger
 }
 
-func NewHuaweiCloudDNSUpdateHandler(ddns *config.DDNSSpec, spec *config.HuaweiCloudSpec, logger *slog.Logger) (*HuaweiCloudDNSUpdateHandler, error) {
+func newHuaweiCloudClient(spec *config.HuaweiCloudSpec) (*huaweiv2.DnsClient, error) {
 	cred, err := basic.NewCredentialsBuilder().WithAk(spec.AccessKey).WithSk(spec.SecretAccessKey).SafeBuild()
 	if err != nil {
 		return nil, err
@@ -63,24 +66,36 @@ func NewHuaweiCloudDNSUpdateHandler(ddns *config.DDNSSpec, spec *config.HuaweiCl
 	if err != nil {
 		return nil, err
 	}
-	client := huaweiv2.NewDnsClient(hcClient)
+	return huaweiv2.NewDnsClient(hcClient), nil
+}
 
-	recordType := A
-	if ddns.Stack == config.IPv6 {
-		recordType = AAAA
+func NewHuaweiCloudDNSUpdateHandler(record Record, spec *config.HuaweiCloudSpec, provider *Provider, logger *slog.Logger) (*HuaweiCloudDNSUpdateHandler, error) {
+	client, err := sharedClient(provider, func() (*huaweiv2.DnsClient, error) {
+		return newHuaweiCloudClient(spec)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &HuaweiCloudDNSUpdateHandler{
 		// Add a dot at the end of the domain for compatibility
-		domain:     ddns.Domain + ".",
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain + ".",
+		subdomain:  record.Subdomain,
+		fqdn:       record.FQDN() + ".",
+		recordType: record.Type,
 		client:     client,
+		provider:   provider,
 		logger:     logger,
 	}, nil
 }
 
 func (h *HuaweiCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error) {
+	if h.zoneId == "" {
+		if id, ok := h.provider.Zone(h.domain); ok {
+			h.zoneId = id
+		}
+	}
+
 	if h.zoneId == "" {
 		h.logger.Debug("zone id not present, searching")
 
@@ -113,6 +128,7 @@ func (h *HuaweiCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, er
 
 		h.logger.Debug("got zone id " + val)
 		h.zoneId = val
+		h.provider.SetZone(h.domain, val)
 	}
 
 	if h.recordSetId == "" {
@@ -124,17 +140,18 @@ func (h *HuaweiCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, er
 			result, err := h.client.ListRecordSetsByZone(&model.ListRecordSetsByZoneRequest{
 				ZoneId:     h.zoneId,
 				Type:       utils.StringPtr(string(h.recordType)),
-				Name:       utils.StringPtr(h.subdomain),
+				Name:       utils.StringPtr(h.fqdn),
 				SearchMode: utils.StringPtr("equal"),
 			})
 			if err != nil {
 				return "", err
 			}
 
+			// Record set names are fully qualified, wildcard names included
 			for _, record := range *result.Recordsets {
-				if h.subdomain == *record.Name {
+				if h.fqdn == *record.Name {
 					h.logger.Debug("got record id " + *record.Id)
-					return (*record.Records)[0], nil
+					return *record.Id, nil
 				}
 			}
 
@@ -193,7 +210,7 @@ func (h *HuaweiCloudDNSUpdateHandler) Create(parentCtx context.Context, address
 	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
 	defer cancel()
 	result, err := utils.RunWithContext(ctx, func() (string, error) {
-		fqdn := h.subdomain + "." + h.domain
+		fqdn := h.fqdn
 		result, err := h.client.CreateRecordSet(&model.CreateRecordSetRequest{
 			ZoneId: h.zoneId,
 			Body: &model.CreateRecordSetRequestBody{
@@ -234,7 +251,7 @@ func (h *HuaweiCloudDNSUpdateHandler) Update(parentCtx context.Context, newAddre
 	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
 	defer cancel()
 	result, err := utils.RunWithContext(ctx, func() error {
-		fqdn := h.subdomain + "." + h.domain
+		fqdn := h.fqdn
 		_, err := h.client.UpdateRecordSet(&model.UpdateRecordSetRequest{
 			ZoneId:      h.zoneId,
 			RecordsetId: h.recordSetId,
@@ -257,3 +274,63 @@ func (h *HuaweiCloudDNSUpdateHandler) Update(parentCtx context.Context, newAddre
 	}
 	return nil
 }
+
+func (h *HuaweiCloudDNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.zoneId == "" || h.recordSetId == "" {
+		addr, err := h.Get(parentCtx)
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			h.logger.Debug("DNS record not found, nothing to delete")
+			return nil
+		}
+	}
+
+	h.logger.Debug("deleting DNS record " + h.recordSetId)
+
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	result, err := utils.RunWithContext(ctx, func() error {
+		_, err := h.client.DeleteRecordSet(&model.DeleteRecordSetRequest{
+			ZoneId:      h.zoneId,
+			RecordsetId: h.recordSetId,
+		})
+		if err != nil {
+			hwErr := &sdkerr.ServiceResponseError{}
+			if errors.As(err, &hwErr) && hwErr.StatusCode == 404 {
+				return nil
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if result[0] != nil {
+		return result[0].(error)
+	}
+	h.recordSetId = ""
+	return nil
+}
+
+func (h *HuaweiCloudDNSUpdateHandler) SaveState() map[string]string {
+	return map[string]string{
+		"zoneId":      h.zoneId,
+		"recordSetId": h.recordSetId,
+	}
+}
+
+func (h *HuaweiCloudDNSUpdateHandler) RestoreState(state map[string]string) {
+	h.zoneId = state["zoneId"]
+	h.recordSetId = state["recordSetId"]
+}
+
+func (h *HuaweiCloudDNSUpdateHandler) IsPermanent(err error) bool {
+	hwErr := &sdkerr.ServiceResponseError{}
+	if errors.As(err, &hwErr) {
+		return hwErr.StatusCode >= 400 && !retry.IsRetryableStatus(hwErr.StatusCode)
+	}
+	return false
+}
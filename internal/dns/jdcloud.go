@@ -18,6 +18,7 @@ package dns
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
@@ -28,6 +29,7 @@ import (
 	"github.com/jdcloud-api/jdcloud-sdk-go/services/domainservice/client"
 	"github.com/jdcloud-api/jdcloud-sdk-go/services/domainservice/models"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/retry"
 	"github.com/masteryyh/micro-ddns/pkg/utils"
 )
 
@@ -44,18 +46,24 @@ type JDCloudDNSUpdateHandler struct {
 	recordId   *int
 	viewId     int
 
-	client *client.DomainserviceClient
-	logger *slog.Logger
+	client   *client.DomainserviceClient
+	provider *Provider
+	logger   *slog.Logger
 }
 
-func NewJDCloudDNSUpdateHandler(ddns *config.DDNSSpec, spec *config.JDCloudSpec, logger *slog.Logger) (*JDCloudDNSUpdateHandler, error) {
+func newJDCloudClient(spec *config.JDCloudSpec) (*client.DomainserviceClient, error) {
 	cred := core.NewCredentials(spec.AccessKey, spec.SecretKey)
 	dnsClient := client.NewDomainserviceClient(cred)
 	dnsClient.SetLogger(core.NewDefaultLogger(core.LogWarn))
+	return dnsClient, nil
+}
 
-	recordType := A
-	if ddns.Stack == config.IPv6 {
-		recordType = AAAA
+func NewJDCloudDNSUpdateHandler(record Record, spec *config.JDCloudSpec, provider *Provider, logger *slog.Logger) (*JDCloudDNSUpdateHandler, error) {
+	dnsClient, err := sharedClient(provider, func() (*client.DomainserviceClient, error) {
+		return newJDCloudClient(spec)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	view := -1
@@ -64,16 +72,25 @@ func NewJDCloudDNSUpdateHandler(ddns *config.DDNSSpec, spec *config.JDCloudSpec,
 	}
 
 	return &JDCloudDNSUpdateHandler{
-		domain:     ddns.Domain,
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain,
+		subdomain:  record.Subdomain,
+		recordType: record.Type,
 		viewId:     view,
 		client:     dnsClient,
+		provider:   provider,
 		logger:     logger,
 	}, nil
 }
 
 func (h *JDCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error) {
+	if h.domainId == nil {
+		if cached, ok := h.provider.Zone(h.domain); ok {
+			if id, err := strconv.Atoi(cached); err == nil {
+				h.domainId = &id
+			}
+		}
+	}
+
 	if h.domainId == nil {
 		h.logger.Debug("domain id is empty, searching")
 
@@ -86,7 +103,7 @@ func (h *JDCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error)
 				return -1, err
 			}
 			if result.Error.Code != 0 {
-				return -1, fmt.Errorf(result.Error.Message)
+				return -1, jdcloudError(result.Error)
 			}
 
 			for _, domain := range result.Result.DataList {
@@ -107,6 +124,7 @@ func (h *JDCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error)
 		val := result[0].(int)
 		h.logger.Debug("got domain id " + strconv.Itoa(val))
 		h.domainId = &val
+		h.provider.SetZone(h.domain, strconv.Itoa(val))
 	}
 
 	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
@@ -118,7 +136,7 @@ func (h *JDCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error)
 			return -1, "", err
 		}
 		if result.Error.Code != 0 {
-			return -1, "", fmt.Errorf(result.Error.Message)
+			return -1, "", jdcloudError(result.Error)
 		}
 
 		for _, record := range result.Result.DataList {
@@ -141,6 +159,7 @@ func (h *JDCloudDNSUpdateHandler) Get(parentCtx context.Context) (string, error)
 		return "", nil
 	}
 	h.logger.Debug("got record id " + strconv.Itoa(id))
+	h.recordId = &id
 	return addr, nil
 }
 
@@ -164,7 +183,7 @@ func (h *JDCloudDNSUpdateHandler) Create(parentCtx context.Context, address stri
 			return -1, err
 		}
 		if result.Error.Code != 0 {
-			return -1, fmt.Errorf(result.Error.Message)
+			return -1, jdcloudError(result.Error)
 		}
 		return result.Result.DataList.Id, nil
 	})
@@ -206,7 +225,42 @@ func (h *JDCloudDNSUpdateHandler) Update(parentCtx context.Context, newAddress s
 			return err
 		}
 		if result.Error.Code != 0 {
-			return fmt.Errorf(result.Error.Message)
+			return jdcloudError(result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if result[0] != nil {
+		return result[0].(error)
+	}
+	return nil
+}
+
+func (h *JDCloudDNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.domainId == nil || h.recordId == nil {
+		addr, err := h.Get(parentCtx)
+		if err != nil {
+			return err
+		}
+		if addr == "" {
+			h.logger.Debug("DNS record not found, nothing to delete")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	result, err := utils.RunWithContext(ctx, func() error {
+		request := apis.NewDeleteResourceRecordRequestWithAllParams("jdcloud-api", strconv.Itoa(*h.domainId), strconv.Itoa(*h.recordId))
+		result, err := h.client.DeleteResourceRecord(request)
+		if err != nil {
+			return err
+		}
+		if result.Error.Code != 0 {
+			return jdcloudError(result.Error)
 		}
 		return nil
 	})
@@ -217,5 +271,35 @@ func (h *JDCloudDNSUpdateHandler) Update(parentCtx context.Context, newAddress s
 	if result[0] != nil {
 		return result[0].(error)
 	}
+	h.recordId = nil
 	return nil
 }
+
+func (h *JDCloudDNSUpdateHandler) SaveState() map[string]string {
+	state := make(map[string]string)
+	if h.domainId != nil {
+		state["domainId"] = strconv.Itoa(*h.domainId)
+	}
+	if h.recordId != nil {
+		state["recordId"] = strconv.Itoa(*h.recordId)
+	}
+	return state
+}
+
+func (h *JDCloudDNSUpdateHandler) RestoreState(state map[string]string) {
+	if id, err := strconv.Atoi(state["domainId"]); err == nil {
+		h.domainId = &id
+	}
+	if id, err := strconv.Atoi(state["recordId"]); err == nil {
+		h.recordId = &id
+	}
+}
+
+// jdcloudError converts an error response, only server errors and throttling are retryable
+func jdcloudError(response core.ErrorResponse) error {
+	err := errors.New(response.Message)
+	if retry.IsRetryableStatus(response.Code) {
+		return err
+	}
+	return retry.Permanent(err)
+}
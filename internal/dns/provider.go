@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// Provider is what every handler of the same DNS provider spec shares: the
+// SDK client, the zone IDs discovered so far, and the limits keeping them
+// under the API quotas of the provider
+type Provider struct {
+	name string
+
+	client     any
+	clientLock sync.Mutex
+
+	zones     map[string]string
+	zonesLock sync.RWMutex
+
+	limiter *rate.Limiter
+	slots   chan struct{}
+}
+
+func NewProvider(spec *config.DNSProviderSpec) *Provider {
+	limit := rate.Inf
+	burst := 1
+	slots := config.DefaultProviderMaxConcurrency
+	if limits := spec.Limits; limits != nil {
+		if *limits.RequestsPerSecond > 0 {
+			limit = rate.Limit(*limits.RequestsPerSecond)
+		}
+		burst = *limits.Burst
+		slots = *limits.MaxConcurrency
+	}
+
+	return &Provider{
+		name:    spec.Name,
+		zones:   make(map[string]string),
+		limiter: rate.NewLimiter(limit, max(burst, 1)),
+		slots:   make(chan struct{}, max(slots, 1)),
+	}
+}
+
+// sharedClient returns the SDK client of provider, created by newClient on
+// first use. A failed creation is not remembered so it is tried again later.
+func sharedClient[T any](provider *Provider, newClient func() (T, error)) (T, error) {
+	provider.clientLock.Lock()
+	defer provider.clientLock.Unlock()
+
+	if client, ok := provider.client.(T); ok {
+		return client, nil
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return client, err
+	}
+	provider.client = client
+	return client, nil
+}
+
+// Zone returns the zone ID of domain discovered by any handler of this provider
+func (p *Provider) Zone(domain string) (string, bool) {
+	p.zonesLock.RLock()
+	defer p.zonesLock.RUnlock()
+	id, ok := p.zones[domain]
+	return id, ok
+}
+
+// SetZone records the zone ID of domain so other handlers don't look it up again
+func (p *Provider) SetZone(domain string, id string) {
+	p.zonesLock.Lock()
+	defer p.zonesLock.Unlock()
+	p.zones[domain] = id
+}
+
+// Acquire waits until an operation can be started against the provider, the
+// returned function must be called once the operation is done
+func (p *Provider) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if err := p.limiter.Wait(ctx); err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return func() { <-p.slots }, nil
+}
+
+// pooledProvider is a shared provider and the spec it was created from
+type pooledProvider struct {
+	spec     config.DNSProviderSpec
+	provider *Provider
+}
+
+// ProviderPool hands out the Provider of every DNS provider spec
+type ProviderPool struct {
+	providers map[string]*pooledProvider
+	lock      sync.Mutex
+}
+
+func NewProviderPool() *ProviderPool {
+	return &ProviderPool{
+		providers: make(map[string]*pooledProvider),
+	}
+}
+
+// Get returns the shared Provider of spec, a Provider is created again when
+// the spec changed since it was last requested
+func (p *ProviderPool) Get(spec *config.DNSProviderSpec) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := spec.PoolKey()
+	if pooled, ok := p.providers[key]; ok && reflect.DeepEqual(pooled.spec, *spec) {
+		return pooled.provider
+	}
+
+	pooled := &pooledProvider{
+		spec:     *spec,
+		provider: NewProvider(spec),
+	}
+	p.providers[key] = pooled
+	return pooled.provider
+}
+
+// Retain drops the Provider of every pool key not in keys, so providers no
+// longer used by any instance are released
+func (p *ProviderPool) Retain(keys map[string]bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key := range p.providers {
+		if !keys[key] {
+			delete(p.providers, key)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+func newTestProvider(t *testing.T, limits *config.ProviderLimitsSpec) *Provider {
+	t.Helper()
+	spec := &config.DNSProviderSpec{
+		Name:    "dns",
+		RFC2136: &config.RFC2136Spec{Address: "192.0.2.53"},
+		Limits:  limits,
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewProvider(spec)
+}
+
+func TestAcquireMaxConcurrency(t *testing.T) {
+	for _, maxConcurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("maxConcurrency %d", maxConcurrency), func(t *testing.T) {
+			provider := newTestProvider(t, &config.ProviderLimitsSpec{MaxConcurrency: &maxConcurrency})
+
+			var running, peak atomic.Int32
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					release, err := provider.Acquire(context.Background())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer release()
+
+					current := running.Add(1)
+					for {
+						previous := peak.Load()
+						if current <= previous || peak.CompareAndSwap(previous, current) {
+							break
+						}
+					}
+					time.Sleep(20 * time.Millisecond)
+					running.Add(-1)
+				}()
+			}
+			wg.Wait()
+
+			if got := peak.Load(); got != int32(maxConcurrency) {
+				t.Errorf("expected %d operations at the same time at most, got %d", maxConcurrency, got)
+			}
+		})
+	}
+}
+
+func TestAcquireRequestsPerSecond(t *testing.T) {
+	tests := []struct {
+		name              string
+		requestsPerSecond float64
+		burst             int
+		calls             int
+
+		// minElapsed and maxElapsed bound the time taken to start every call
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{
+			name:              "calls are spread at the rate",
+			requestsPerSecond: 20,
+			burst:             1,
+			calls:             5,
+			minElapsed:        190 * time.Millisecond,
+			maxElapsed:        time.Second,
+		},
+		{
+			name:              "burst starts at once",
+			requestsPerSecond: 1,
+			burst:             3,
+			calls:             3,
+			maxElapsed:        500 * time.Millisecond,
+		},
+		{
+			name:       "zero is unlimited",
+			burst:      1,
+			calls:      50,
+			maxElapsed: 500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxConcurrency := tt.calls
+			provider := newTestProvider(t, &config.ProviderLimitsSpec{
+				RequestsPerSecond: &tt.requestsPerSecond,
+				Burst:             &tt.burst,
+				MaxConcurrency:    &maxConcurrency,
+			})
+
+			start := time.Now()
+			for i := 0; i < tt.calls; i++ {
+				release, err := provider.Acquire(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				release()
+			}
+			elapsed := time.Since(start)
+
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("expected %d calls to start within %s and %s, took %s", tt.calls, tt.minElapsed, tt.maxElapsed, elapsed)
+			}
+		})
+	}
+}
+
+func TestAcquireGivesUpWithContext(t *testing.T) {
+	maxConcurrency := 1
+	provider := newTestProvider(t, &config.ProviderLimitsSpec{MaxConcurrency: &maxConcurrency})
+	release, err := provider.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := provider.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v while every slot is taken, got %v", context.DeadlineExceeded, err)
+	}
+}
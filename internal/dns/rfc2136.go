@@ -26,6 +26,7 @@ import (
 	"github.com/bodgit/tsig"
 	"github.com/bodgit/tsig/gss"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/retry"
 	"github.com/miekg/dns"
 )
 
@@ -34,6 +35,7 @@ const RFC2136DefaultTTL = 120
 type RFC2136DNSUpdateHandler struct {
 	domain     string
 	subdomain  string
+	fqdn       string
 	recordType RecordType
 	server     string
 
@@ -45,22 +47,18 @@ type RFC2136DNSUpdateHandler struct {
 	logger     *slog.Logger
 }
 
-func NewRFC2136DNSUpdateHandler(ddns *config.DDNSSpec, spec *config.RFC2136Spec, logger *slog.Logger) (*RFC2136DNSUpdateHandler, error) {
+func NewRFC2136DNSUpdateHandler(record Record, spec *config.RFC2136Spec, provider *Provider, logger *slog.Logger) (*RFC2136DNSUpdateHandler, error) {
 	port := 53
 	if spec.Port != nil {
 		port = *spec.Port
 	}
 
-	recordType := A
-	if ddns.Stack == config.IPv6 {
-		recordType = AAAA
-	}
-
 	server := spec.Address + ":" + strconv.Itoa(port)
 	handler := &RFC2136DNSUpdateHandler{
-		domain:     ddns.Domain,
-		subdomain:  ddns.Subdomain,
-		recordType: recordType,
+		domain:     record.Domain,
+		subdomain:  record.Subdomain,
+		fqdn:       dns.Fqdn(record.FQDN()),
+		recordType: record.Type,
 		server:     server,
 		spec:       spec,
 		logger:     logger,
@@ -115,7 +113,7 @@ func (h *RFC2136DNSUpdateHandler) Get(parentCtx context.Context) (string, error)
 		},
 	}
 
-	fqdn := dns.Fqdn(h.subdomain + "." + h.domain)
+	fqdn := h.fqdn
 	qtype := dns.TypeA
 	if h.recordType == AAAA {
 		qtype = dns.TypeAAAA
@@ -164,7 +162,7 @@ func (h *RFC2136DNSUpdateHandler) Create(parentCtx context.Context, address stri
 	message := &dns.Msg{}
 	message.SetUpdate(dns.Fqdn(h.domain))
 
-	fqdn := dns.Fqdn(h.subdomain + "." + h.domain)
+	fqdn := h.fqdn
 	rrStr := fqdn + "\t" + strconv.Itoa(RFC2136DefaultTTL) + "\tIN\t" + string(h.recordType) + "\t" + address
 	rr, err := dns.NewRR(rrStr)
 	if err != nil {
@@ -185,10 +183,13 @@ func (h *RFC2136DNSUpdateHandler) Create(parentCtx context.Context, address stri
 		message.SetTsig(h.keyName, dns.HmacSHA256, 300, time.Now().Unix())
 	}
 
-	_, _, err = h.client.ExchangeContext(ctx, message, h.server)
+	reply, _, err := h.client.ExchangeContext(ctx, message, h.server)
 	if err != nil {
 		return err
 	}
+	if err := checkRcode(reply); err != nil {
+		return err
+	}
 
 	h.lastRR = rrStr
 	return nil
@@ -203,7 +204,7 @@ func (h *RFC2136DNSUpdateHandler) Update(parentCtx context.Context, newAddress s
 	message := &dns.Msg{}
 	message.SetUpdate(dns.Fqdn(h.domain))
 
-	fqdn := dns.Fqdn(h.subdomain + "." + h.domain)
+	fqdn := h.fqdn
 	rrStr := fqdn + "\t" + strconv.Itoa(RFC2136DefaultTTL) + "\tIN\t" + string(h.recordType) + "\t" + newAddress
 	rr, err := dns.NewRR(rrStr)
 	if err != nil {
@@ -230,11 +231,80 @@ func (h *RFC2136DNSUpdateHandler) Update(parentCtx context.Context, newAddress s
 		message.SetTsig(h.keyName, dns.HmacSHA256, 300, time.Now().Unix())
 	}
 
-	_, _, err = h.client.ExchangeContext(ctx, message, h.server)
+	reply, _, err := h.client.ExchangeContext(ctx, message, h.server)
 	if err != nil {
 		return err
 	}
+	if err := checkRcode(reply); err != nil {
+		return err
+	}
 
 	h.lastRR = rrStr
 	return nil
 }
+
+// Delete removes the record last written by this handler only, other records
+// of the same name and type are left alone
+func (h *RFC2136DNSUpdateHandler) Delete(parentCtx context.Context) error {
+	if h.lastRR == "" {
+		h.logger.Debug("last address unknown, nothing to delete")
+		return nil
+	}
+
+	message := &dns.Msg{}
+	message.SetUpdate(dns.Fqdn(h.domain))
+
+	oldRr, err := dns.NewRR(h.lastRR)
+	if err != nil {
+		return err
+	}
+	message.Remove([]dns.RR{oldRr})
+
+	h.logger.Debug("RR about to delete: " + oldRr.String())
+	ctx, cancel := context.WithTimeout(parentCtx, 10*time.Second)
+	defer cancel()
+	if err := h.negotiate(ctx); err != nil {
+		return err
+	}
+
+	if h.gssKeyName != "" {
+		message.SetTsig(h.gssKeyName, tsig.GSS, 300, time.Now().Unix())
+	} else if h.keyName != "" {
+		message.SetTsig(h.keyName, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+
+	reply, _, err := h.client.ExchangeContext(ctx, message, h.server)
+	if err != nil {
+		return err
+	}
+	if err := checkRcode(reply); err != nil {
+		return err
+	}
+
+	h.lastRR = ""
+	return nil
+}
+
+func (h *RFC2136DNSUpdateHandler) SaveState() map[string]string {
+	return map[string]string{
+		"lastRR": h.lastRR,
+	}
+}
+
+func (h *RFC2136DNSUpdateHandler) RestoreState(state map[string]string) {
+	h.lastRR = state["lastRR"]
+}
+
+// checkRcode turns an unsuccessful update reply into an error, a server failure
+// is transient but a refused or unauthorized update is not
+func checkRcode(reply *dns.Msg) error {
+	if reply == nil || reply.Rcode == dns.RcodeSuccess {
+		return nil
+	}
+
+	err := fmt.Errorf("update rejected by server: %s", dns.RcodeToString[reply.Rcode])
+	if reply.Rcode == dns.RcodeServerFailure {
+		return err
+	}
+	return retry.Permanent(err)
+}
@@ -0,0 +1,268 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// DefaultSize is the number of entries kept in memory for every instance
+const DefaultSize = 100
+
+// ActionError is the action of an entry whose record failed to update
+const ActionError = "error"
+
+// Entry is what a single run of an instance did to one of its records
+type Entry struct {
+	// Time is when the run started
+	Time time.Time `json:"time"`
+
+	// Name is the name of the DDNS spec managing the record
+	Name   string `json:"name"`
+	Record string `json:"record"`
+	Type   string `json:"type"`
+
+	// Action is none, create, update or error
+	Action string `json:"action"`
+
+	// Address is the detected address, empty if detection failed
+	Address string `json:"address,omitempty"`
+
+	// PreviousAddress is the value the record had before the run
+	PreviousAddress string `json:"previousAddress,omitempty"`
+
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dryRun,omitempty"`
+
+	// Duration is how long the whole run took
+	Duration config.Duration `json:"duration"`
+}
+
+// Query selects entries, the zero value selects every entry
+type Query struct {
+	// Name only selects entries of the DDNS spec with this name
+	Name string
+
+	// Since only selects entries of runs started at or after this time
+	Since time.Time
+
+	// Limit only selects the latest entries, 0 means no limit
+	Limit int
+}
+
+// ParseQuery reads a Query from the parameters of a history request, since
+// is an RFC 3339 timestamp
+func ParseQuery(values url.Values) (Query, error) {
+	q := Query{
+		Name: values.Get("name"),
+	}
+
+	if since := values.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return q, fmt.Errorf("since must be an RFC 3339 timestamp: %w", err)
+		}
+		q.Since = t
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("limit must be a non-negative number")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// Values returns q as the parameters of a history request
+func (q *Query) Values() url.Values {
+	values := url.Values{}
+	if q.Name != "" {
+		values.Set("name", q.Name)
+	}
+	if !q.Since.IsZero() {
+		values.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
+// Match reports if entry is selected by q, regardless of Limit
+func (q *Query) Match(entry *Entry) bool {
+	if q.Name != "" && entry.Name != q.Name {
+		return false
+	}
+	return entry.Time.Equal(q.Since) || entry.Time.After(q.Since)
+}
+
+// Filter returns the entries selected by q in time order
+func (q *Query) Filter(entries []Entry) []Entry {
+	selected := make([]Entry, 0)
+	for i := range entries {
+		if q.Match(&entries[i]) {
+			selected = append(selected, entries[i])
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Time.Before(selected[j].Time)
+	})
+	if q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[len(selected)-q.Limit:]
+	}
+	return selected
+}
+
+// Recorder keeps the latest entries of every instance in memory, and appends
+// every entry to an audit log as JSON lines when it has one
+type Recorder struct {
+	size    int
+	entries map[string][]Entry
+	audit   *os.File
+	lock    sync.Mutex
+}
+
+// Open creates a Recorder keeping size entries per instance, the audit log at
+// auditPath is created if missing and appended to, use an empty path to only
+// keep entries in memory
+func Open(size int, auditPath string) (*Recorder, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("history size cannot be negative")
+	}
+
+	recorder := &Recorder{
+		size:    size,
+		entries: make(map[string][]Entry),
+	}
+	if auditPath == "" {
+		return recorder, nil
+	}
+
+	audit, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	recorder.audit = audit
+	return recorder, nil
+}
+
+// Add records entries and appends them to the audit log, entries are kept
+// in memory even if writing the audit log failed
+func (r *Recorder) Add(entries ...Entry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, entry := range entries {
+		if r.size == 0 {
+			break
+		}
+
+		list := append(r.entries[entry.Name], entry)
+		if len(list) > r.size {
+			list = append([]Entry(nil), list[len(list)-r.size:]...)
+		}
+		r.entries[entry.Name] = list
+	}
+
+	if r.audit == nil {
+		return nil
+	}
+
+	var lines []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	// A single write keeps the entries of a run together when several
+	// instances finish at the same time
+	if _, err := r.audit.Write(lines); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Query returns the entries in memory selected by q in time order
+func (r *Recorder) Query(q Query) []Entry {
+	r.lock.Lock()
+	var entries []Entry
+	for _, list := range r.entries {
+		entries = append(entries, list...)
+	}
+	r.lock.Unlock()
+
+	return q.Filter(entries)
+}
+
+// Lookup returns the entries in memory selected by the parameters of a
+// history request, see ParseQuery
+func (r *Recorder) Lookup(values url.Values) (interface{}, error) {
+	q, err := ParseQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	return r.Query(q), nil
+}
+
+// Close closes the audit log
+func (r *Recorder) Close() error {
+	if r.audit == nil {
+		return nil
+	}
+	return r.audit.Close()
+}
+
+// ReadAuditLog returns every entry of the audit log at path
+func ReadAuditLog(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+var start = time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+func entry(name string, minute int, address string) Entry {
+	return Entry{
+		Time:     start.Add(time.Duration(minute) * time.Minute),
+		Name:     name,
+		Record:   name + ".example.com",
+		Type:     "A",
+		Action:   "update",
+		Address:  address,
+		Duration: config.Duration(150 * time.Millisecond),
+	}
+}
+
+func addresses(entries []Entry) []string {
+	result := make([]string, len(entries))
+	for i, e := range entries {
+		result[i] = e.Name + "=" + e.Address
+	}
+	return result
+}
+
+func TestRecorderKeepsLatestEntries(t *testing.T) {
+	recorder, err := Open(3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	for i := 1; i <= 5; i++ {
+		if err := recorder.Add(entry("home", i, "203.0.113."+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Add(entry("office", 0, "198.51.100.1"), entry("office", 6, "198.51.100.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{
+			name: "every instance",
+			want: []string{"office=198.51.100.1", "home=203.0.113.3", "home=203.0.113.4", "home=203.0.113.5", "office=198.51.100.2"},
+		},
+		{
+			name:  "by name",
+			query: Query{Name: "home"},
+			want:  []string{"home=203.0.113.3", "home=203.0.113.4", "home=203.0.113.5"},
+		},
+		{
+			name:  "since is inclusive",
+			query: Query{Since: start.Add(4 * time.Minute)},
+			want:  []string{"home=203.0.113.4", "home=203.0.113.5", "office=198.51.100.2"},
+		},
+		{
+			name:  "limit keeps the latest",
+			query: Query{Limit: 2},
+			want:  []string{"home=203.0.113.5", "office=198.51.100.2"},
+		},
+		{
+			name:  "unknown name",
+			query: Query{Name: "missing"},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addresses(recorder.Query(tt.query)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Size 0 keeps nothing in memory, the audit log still gets every entry
+	recorder, err := Open(0, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := entry("home", 1, "203.0.113.1")
+	first.PreviousAddress = "203.0.113.9"
+	failed := entry("home", 2, "")
+	failed.Action = ActionError
+	failed.Error = "connection refused"
+	if err := recorder.Add(first, failed); err != nil {
+		t.Fatal(err)
+	}
+	if got := recorder.Query(Query{}); len(got) != 0 {
+		t.Errorf("expected no entries in memory, got %d", len(got))
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening appends to the existing log
+	recorder, err = Open(DefaultSize, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun := entry("office", 3, "198.51.100.1")
+	dryRun.DryRun = true
+	if err := recorder.Add(dryRun); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{first, failed, dryRun}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("expected %+v, got %+v", want, entries)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 3 {
+		t.Errorf("expected 3 lines, got %d", lines)
+	}
+}
+
+func TestReadAuditLogReportsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	content := `{"time":"2024-01-02T08:00:00Z","name":"home","record":"home.example.com","type":"A","action":"none","duration":"1s"}
+
+not json
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadAuditLog(path)
+	if err == nil || !strings.Contains(err.Error(), path+":3:") {
+		t.Fatalf("expected an error at line 3, got %v", err)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    Query
+		wantErr string
+	}{
+		{name: "empty", values: url.Values{}},
+		{
+			name:   "every parameter",
+			values: url.Values{"name": {"home"}, "since": {"2024-01-02T08:00:00Z"}, "limit": {"10"}},
+			want:   Query{Name: "home", Since: start, Limit: 10},
+		},
+		{name: "invalid since", values: url.Values{"since": {"yesterday"}}, wantErr: "since must be an RFC 3339 timestamp"},
+		{name: "negative limit", values: url.Values{"limit": {"-1"}}, wantErr: "limit must be a non-negative number"},
+		{name: "invalid limit", values: url.Values{"limit": {"ten"}}, wantErr: "limit must be a non-negative number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuery(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !q.Since.Equal(tt.want.Since) || q.Name != tt.want.Name || q.Limit != tt.want.Limit {
+				t.Errorf("expected %+v, got %+v", tt.want, q)
+			}
+
+			// Values is the inverse of ParseQuery
+			if got := q.Values(); !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("expected values %v, got %v", tt.values, got)
+			}
+		})
+	}
+}
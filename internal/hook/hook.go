@@ -0,0 +1,203 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// Event describes the change of a single record that hooks are run for
+type Event struct {
+	// Name is the name of the DDNS spec managing the record
+	Name       string    `json:"name"`
+	FQDN       string    `json:"fqdn"`
+	Type       string    `json:"type"`
+	OldAddress string    `json:"oldAddress"`
+	NewAddress string    `json:"newAddress"`
+	Action     string    `json:"action"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// env returns the event as MICRO_DDNS_* environment variables
+func (e *Event) env() []string {
+	status := "success"
+	if !e.Success {
+		status = "failure"
+	}
+
+	return []string{
+		"MICRO_DDNS_NAME=" + e.Name,
+		"MICRO_DDNS_FQDN=" + e.FQDN,
+		"MICRO_DDNS_TYPE=" + e.Type,
+		"MICRO_DDNS_OLD_ADDRESS=" + e.OldAddress,
+		"MICRO_DDNS_NEW_ADDRESS=" + e.NewAddress,
+		"MICRO_DDNS_ACTION=" + e.Action,
+		"MICRO_DDNS_STATUS=" + status,
+		"MICRO_DDNS_ERROR=" + e.Error,
+	}
+}
+
+// Hook is a single configured hook
+type Hook struct {
+	spec   *config.HookSpec
+	body   *template.Template
+	client *http.Client
+	logger *slog.Logger
+}
+
+func NewHook(spec *config.HookSpec, logger *slog.Logger) (*Hook, error) {
+	hook := &Hook{
+		spec:   spec,
+		logger: logger.With("hook", spec.Name),
+	}
+
+	if spec.HTTP != nil {
+		hook.client = &http.Client{}
+		if spec.HTTP.Body != "" {
+			body, err := template.New(spec.Name).Parse(spec.HTTP.Body)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: %w", spec.Name, err)
+			}
+			hook.body = body
+		}
+	}
+	return hook, nil
+}
+
+// Run runs the hook for event, bounded by the timeout of the hook
+func (h *Hook) Run(parentCtx context.Context, event *Event) error {
+	ctx, cancel := context.WithTimeout(parentCtx, h.spec.Timeout.Duration())
+	defer cancel()
+
+	if h.spec.Command != nil {
+		return h.runCommand(ctx, event)
+	}
+	return h.sendRequest(ctx, event)
+}
+
+func (h *Hook) runCommand(ctx context.Context, event *Event) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	spec := h.spec.Command
+	cmd := exec.CommandContext(ctx, spec.Path, spec.Args...)
+	cmd.Env = append(os.Environ(), event.env()...)
+	cmd.Stdin = bytes.NewReader(input)
+
+	h.logger.Debug("running hook command", "path", spec.Path)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		h.logger.Debug("hook command output", "output", strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command %s timed out: %w", spec.Path, ctx.Err())
+		}
+		return fmt.Errorf("command %s failed: %w", spec.Path, err)
+	}
+	return nil
+}
+
+func (h *Hook) sendRequest(ctx context.Context, event *Event) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+	if h.body != nil {
+		if err := h.body.Execute(&body, event); err != nil {
+			return fmt.Errorf("failed to render request body: %w", err)
+		}
+		contentType = ""
+	} else if err := json.NewEncoder(&body).Encode(event); err != nil {
+		return err
+	}
+
+	spec := h.spec.HTTP
+	req, err := http.NewRequestWithContext(ctx, spec.Method, spec.URL, &body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	h.logger.Debug("sending hook request", "method", spec.Method, "url", spec.URL)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s %s responded with status %s", spec.Method, spec.URL, res.Status)
+	}
+	return nil
+}
+
+// Runner runs every hook of a DDNS spec
+type Runner struct {
+	hooks  []*Hook
+	logger *slog.Logger
+}
+
+func NewRunner(specs []*config.HookSpec, logger *slog.Logger) (*Runner, error) {
+	runner := &Runner{logger: logger}
+	for _, spec := range specs {
+		hook, err := NewHook(spec, logger)
+		if err != nil {
+			return nil, err
+		}
+		runner.hooks = append(runner.hooks, hook)
+	}
+	return runner, nil
+}
+
+// Run runs the hooks interested in event in order, a failed hook is logged
+// and does not stop the others
+func (r *Runner) Run(ctx context.Context, event *Event) {
+	for _, hook := range r.hooks {
+		if !event.Success && !hook.spec.IsOnFailure() {
+			continue
+		}
+
+		start := time.Now()
+		if err := hook.Run(ctx, event); err != nil {
+			hook.logger.Error("hook failed", "record", event.FQDN, "type", event.Type, "err", err)
+			continue
+		}
+		hook.logger.Info("hook finished", "record", event.FQDN, "type", event.Type, "duration", time.Since(start).String())
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func testEvent(success bool) *Event {
+	event := &Event{
+		Name:       "home",
+		FQDN:       "home.example.com",
+		Type:       "A",
+		OldAddress: "203.0.113.1",
+		NewAddress: "203.0.113.2",
+		Action:     "update",
+		Success:    success,
+		Time:       time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC),
+	}
+	if !success {
+		event.Error = "provider unavailable"
+	}
+	return event
+}
+
+func newTestHook(t *testing.T, spec *config.HookSpec) *Hook {
+	t.Helper()
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	hook, err := NewHook(spec, discardLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func shellHook(t *testing.T, script string, timeout time.Duration) *Hook {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("command hooks are tested with a POSIX shell")
+	}
+	d := config.Duration(timeout)
+	return newTestHook(t, &config.HookSpec{
+		Name:    "script",
+		Command: &config.CommandHookSpec{Path: "/bin/sh", Args: []string{"-c", script}},
+		Timeout: &d,
+	})
+}
+
+func TestCommandHook(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env")
+	stdinFile := filepath.Join(dir, "stdin")
+	hook := shellHook(t, `env | grep '^MICRO_DDNS_' | sort > "$0"; cat > "$1"`, 10*time.Second)
+	hook.spec.Command.Args = append(hook.spec.Command.Args, envFile, stdinFile)
+
+	event := testEvent(false)
+	if err := hook.Run(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEnv := []string{
+		"MICRO_DDNS_ACTION=update",
+		"MICRO_DDNS_ERROR=provider unavailable",
+		"MICRO_DDNS_FQDN=home.example.com",
+		"MICRO_DDNS_NAME=home",
+		"MICRO_DDNS_NEW_ADDRESS=203.0.113.2",
+		"MICRO_DDNS_OLD_ADDRESS=203.0.113.1",
+		"MICRO_DDNS_STATUS=failure",
+		"MICRO_DDNS_TYPE=A",
+	}
+	if got := strings.Split(strings.TrimSpace(string(env)), "\n"); !reflect.DeepEqual(got, wantEnv) {
+		t.Errorf("expected environment %v, got %v", wantEnv, got)
+	}
+
+	stdin, err := os.ReadFile(stdinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := json.Unmarshal(stdin, &got); err != nil {
+		t.Fatalf("stdin is not a JSON event: %v", err)
+	}
+	if !reflect.DeepEqual(&got, event) {
+		t.Errorf("expected event %+v on stdin, got %+v", event, got)
+	}
+}
+
+func TestCommandHookFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			name:    "non-zero exit",
+			script:  "exit 3",
+			wantErr: "failed",
+		},
+		{
+			name:    "timeout kills the process",
+			script:  "exec sleep 30",
+			wantErr: "timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := shellHook(t, tt.script, 200*time.Millisecond)
+
+			start := time.Now()
+			err := hook.Run(context.Background(), testEvent(true))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("hook returned after %s, the process was not killed", elapsed)
+			}
+		})
+	}
+}
+
+// request is what the test server received
+type request struct {
+	method      string
+	path        string
+	contentType string
+	header      string
+	body        string
+}
+
+// newTestServer records every request and answers with status
+func newTestServer(t *testing.T, status int) (*httptest.Server, func() []request) {
+	t.Helper()
+	var requests []request
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		requests = append(requests, request{
+			method:      r.Method,
+			path:        r.URL.Path,
+			contentType: r.Header.Get("Content-Type"),
+			header:      r.Header.Get("X-Token"),
+			body:        string(body),
+		})
+		lock.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []request {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func TestHTTPHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    config.HTTPHookSpec
+		status  int
+		want    request
+		wantErr string
+	}{
+		{
+			name:   "event sent as JSON",
+			spec:   config.HTTPHookSpec{},
+			status: http.StatusOK,
+			want: request{
+				method:      http.MethodPost,
+				contentType: "application/json",
+				body:        `{"name":"home","fqdn":"home.example.com","type":"A","oldAddress":"203.0.113.1","newAddress":"203.0.113.2","action":"update","success":true,"time":"2024-01-02T08:00:00Z"}` + "\n",
+			},
+		},
+		{
+			name: "templated body",
+			spec: config.HTTPHookSpec{
+				Method:  "put",
+				Headers: map[string]string{"Content-Type": "text/plain", "X-Token": "secret"},
+				Body:    "{{.FQDN}} {{.Type}} {{.OldAddress}} -> {{.NewAddress}}",
+			},
+			status: http.StatusNoContent,
+			want: request{
+				method:      http.MethodPut,
+				contentType: "text/plain",
+				header:      "secret",
+				body:        "home.example.com A 203.0.113.1 -> 203.0.113.2",
+			},
+		},
+		{
+			name:   "templated body without content type",
+			spec:   config.HTTPHookSpec{Body: "{{.NewAddress}}"},
+			status: http.StatusOK,
+			want: request{
+				method: http.MethodPost,
+				body:   "203.0.113.2",
+			},
+		},
+		{
+			name:    "error status",
+			spec:    config.HTTPHookSpec{},
+			status:  http.StatusBadGateway,
+			wantErr: "502 Bad Gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, tt.status)
+			spec := tt.spec
+			spec.URL = server.URL + "/hook"
+			hook := newTestHook(t, &config.HookSpec{Name: "webhook", HTTP: &spec})
+
+			err := hook.Run(context.Background(), testEvent(true))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := requests()
+			tt.want.path = "/hook"
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("expected request %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunnerOnFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		success   bool
+		wantPaths []string
+	}{
+		{
+			name:      "changed record runs every hook",
+			success:   true,
+			wantPaths: []string{"/changed", "/failure"},
+		},
+		{
+			name:      "failed record runs onFailure hooks only",
+			wantPaths: []string{"/failure"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, http.StatusOK)
+
+			// Nothing listens behind a closed server
+			broken, _ := newTestServer(t, http.StatusOK)
+			broken.Close()
+
+			onFailure := true
+			specs := []*config.HookSpec{
+				{Name: "broken", HTTP: &config.HTTPHookSpec{URL: broken.URL + "/broken"}},
+				{Name: "changed", HTTP: &config.HTTPHookSpec{URL: server.URL + "/changed"}},
+				{Name: "failure", HTTP: &config.HTTPHookSpec{URL: server.URL + "/failure"}, OnFailure: &onFailure},
+			}
+			for _, spec := range specs {
+				if err := spec.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runner, err := NewRunner(specs, discardLogger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runner.Run(context.Background(), testEvent(tt.success))
+
+			// A failing hook does not stop the hooks after it
+			var paths []string
+			for _, r := range requests() {
+				paths = append(paths, r.path)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("expected hooks %v to run, got %v", tt.wantPaths, paths)
+			}
+		})
+	}
+}
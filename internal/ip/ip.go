@@ -16,10 +16,17 @@ limitations under the License.
 
 package ip
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
 // AddressDetector is the general interface for IP address detector
 type AddressDetector interface {
 	// Detect will try to detect IP address or return an error
 	Detect(parentCtx context.Context) (string, error)
 }
+
+// ErrWatchNotSupported is returned by WatchAddressChanges on platforms without
+// address change notifications
+var ErrWatchNotSupported = errors.New("watching address changes is not supported on this platform")
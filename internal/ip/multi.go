@@ -0,0 +1,157 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// Source is a detector used by a MultiDetector, named after its detection spec
+type Source struct {
+	Name     string
+	Detector AddressDetector
+}
+
+// sourceResult is what a single source detected
+type sourceResult struct {
+	name    string
+	address string
+	err     error
+}
+
+// MultiDetector combines the addresses detected by several sources following
+// a detection strategy
+type MultiDetector struct {
+	sources []Source
+	mode    config.DetectionStrategy
+	quorum  int
+	logger  *slog.Logger
+}
+
+// NewMultiDetector creates a detector from sources, tried in order, a nil
+// strategy uses the first address detected. Fallback addresses of the
+// strategy are left to the caller, so a failed detection can be retried.
+func NewMultiDetector(sources []Source, strategy *config.DetectionStrategySpec, logger *slog.Logger) *MultiDetector {
+	detector := &MultiDetector{
+		sources: sources,
+		mode:    config.DetectionFirstSuccess,
+		quorum:  1,
+		logger:  logger,
+	}
+	if strategy != nil {
+		detector.mode = strategy.Mode
+		detector.quorum = *strategy.Quorum
+	}
+	return detector
+}
+
+func (d *MultiDetector) Detect(parentCtx context.Context) (string, error) {
+	if d.mode == config.DetectionQuorum {
+		return d.detectQuorum(parentCtx)
+	}
+	return d.detectFirstSuccess(parentCtx)
+}
+
+// logResult logs what a single source detected, so a misbehaving source stands out
+func (d *MultiDetector) logResult(result sourceResult) {
+	if result.err != nil {
+		d.logger.Warn("detection source failed", "source", result.name, "err", result.err)
+		return
+	}
+	d.logger.Info("detection source reported address", "source", result.name, "address", result.address)
+}
+
+// detectFirstSuccess tries every source in order until one of them detects an address
+func (d *MultiDetector) detectFirstSuccess(parentCtx context.Context) (string, error) {
+	var errs []error
+	for _, source := range d.sources {
+		address, err := source.Detector.Detect(parentCtx)
+		d.logResult(sourceResult{name: source.Name, address: address, err: err})
+		if err == nil {
+			return address, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+		if parentCtx.Err() != nil {
+			break
+		}
+	}
+	return "", fmt.Errorf("every detection source failed: %w", errors.Join(errs...))
+}
+
+// detectQuorum asks every source at once and returns the address reported by
+// at least quorum of them
+func (d *MultiDetector) detectQuorum(parentCtx context.Context) (string, error) {
+	results := make([]sourceResult, len(d.sources))
+	var wg sync.WaitGroup
+	for i, source := range d.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			address, err := source.Detector.Detect(parentCtx)
+			results[i] = sourceResult{name: source.Name, address: address, err: err}
+		}(i, source)
+	}
+	wg.Wait()
+
+	// Addresses are compared in canonical form, "::1" and "0:0::1" are the same vote,
+	// the most voted address wins and ties go to the source listed first
+	votes := make(map[string]int)
+	var errs []error
+	var best string
+	for _, result := range results {
+		d.logResult(result)
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.name, result.err))
+			continue
+		}
+
+		address := result.address
+		if ip := net.ParseIP(address); ip != nil {
+			address = ip.String()
+		}
+		votes[address]++
+		if best == "" || votes[address] > votes[best] {
+			best = address
+		}
+	}
+
+	if best != "" && votes[best] >= d.quorum {
+		d.logger.Debug("detection sources reached quorum", "address", best, "votes", votes[best], "quorum", d.quorum)
+		return best, nil
+	}
+
+	tally := make([]string, 0, len(votes))
+	for address, count := range votes {
+		tally = append(tally, fmt.Sprintf("%s=%d", address, count))
+	}
+	sort.Strings(tally)
+	err := fmt.Errorf("no address reported by %d of %d detection sources, got [%s]", d.quorum, len(d.sources), strings.Join(tally, ", "))
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w: %w", err, errors.Join(errs...))
+	}
+	return "", err
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// staticDetector always detects the same address or fails with the same error
+type staticDetector struct {
+	address string
+	err     error
+	calls   atomic.Int32
+}
+
+func (d *staticDetector) Detect(context.Context) (string, error) {
+	d.calls.Add(1)
+	return d.address, d.err
+}
+
+func detected(address string) *staticDetector {
+	return &staticDetector{address: address}
+}
+
+func failed(message string) *staticDetector {
+	return &staticDetector{err: errors.New(message)}
+}
+
+func TestMultiDetector(t *testing.T) {
+	tests := []struct {
+		name      string
+		detectors []*staticDetector
+		strategy  *config.DetectionStrategySpec
+		want      string
+		wantErr   string
+		wantCalls []int32
+	}{
+		{
+			name:      "no strategy uses the first address",
+			detectors: []*staticDetector{failed("timeout"), detected("203.0.113.1"), detected("203.0.113.2")},
+			want:      "203.0.113.1",
+			wantCalls: []int32{1, 1, 0},
+		},
+		{
+			name:      "no strategy fails when every source failed",
+			detectors: []*staticDetector{failed("timeout"), failed("refused")},
+			wantErr:   "every detection source failed",
+		},
+		{
+			name:      "first success returns the error instead of the fallback",
+			detectors: []*staticDetector{failed("timeout")},
+			strategy:  newStrategy(config.DetectionFirstSuccess, 1, "192.0.2.1"),
+			wantErr:   "every detection source failed",
+		},
+		{
+			name:      "quorum reached",
+			detectors: []*staticDetector{detected("203.0.113.1"), detected("203.0.113.2"), detected("203.0.113.2")},
+			strategy:  newStrategy(config.DetectionQuorum, 2, ""),
+			want:      "203.0.113.2",
+			wantCalls: []int32{1, 1, 1},
+		},
+		{
+			name:      "quorum compares canonical addresses",
+			detectors: []*staticDetector{detected("2001:db8::1"), detected("2001:0db8:0:0::1"), failed("timeout")},
+			strategy:  newStrategy(config.DetectionQuorum, 2, ""),
+			want:      "2001:db8::1",
+		},
+		{
+			name:      "quorum tie goes to the first source",
+			detectors: []*staticDetector{detected("203.0.113.1"), detected("203.0.113.2")},
+			strategy:  newStrategy(config.DetectionQuorum, 1, ""),
+			want:      "203.0.113.1",
+		},
+		{
+			name:      "quorum not reached",
+			detectors: []*staticDetector{detected("203.0.113.1"), detected("203.0.113.2"), failed("timeout")},
+			strategy:  newStrategy(config.DetectionQuorum, 2, ""),
+			wantErr:   "no address reported by 2 of 3 detection sources, got [203.0.113.1=1, 203.0.113.2=1]",
+		},
+		{
+			name:      "failed sources do not vote",
+			detectors: []*staticDetector{failed("timeout"), failed("timeout"), detected("203.0.113.1")},
+			strategy:  newStrategy(config.DetectionQuorum, 2, ""),
+			wantErr:   "timeout",
+		},
+		{
+			name:      "quorum not reached returns the error instead of the fallback",
+			detectors: []*staticDetector{detected("2001:db8::1"), detected("2001:db8::2")},
+			strategy:  newStrategy(config.DetectionQuorum, 2, "192.0.2.1"),
+			wantErr:   "no address reported by 2 of 2 detection sources",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := make([]Source, len(tt.detectors))
+			for i, detector := range tt.detectors {
+				sources[i] = Source{Name: "source" + string(rune('a'+i)), Detector: detector}
+			}
+
+			address, err := NewMultiDetector(sources, tt.strategy, logger).Detect(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if address != tt.want {
+				t.Errorf("expected address %q, got %q", tt.want, address)
+			}
+
+			for i, want := range tt.wantCalls {
+				if got := tt.detectors[i].calls.Load(); got != want {
+					t.Errorf("source %d: expected %d call(s), got %d", i, want, got)
+				}
+			}
+		})
+	}
+}
+
+func newStrategy(mode config.DetectionStrategy, quorum int, fallbackIPv4 string) *config.DetectionStrategySpec {
+	spec := &config.DetectionStrategySpec{Mode: mode, Quorum: &quorum}
+	if fallbackIPv4 != "" {
+		spec.FallbackIPv4 = &fallbackIPv4
+	}
+	return spec
+}
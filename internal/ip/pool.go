@@ -0,0 +1,198 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// sharedDetectionTimeout bounds a shared detection, it does not end with the
+// callers waiting for it
+const sharedDetectionTimeout = 30 * time.Second
+
+// detection is a detection in flight, callers arriving while it runs wait for its result
+type detection struct {
+	done    chan struct{}
+	address string
+	err     error
+
+	// generation is the generation of the cache the detection started in
+	generation int
+}
+
+// cachedDetector shares the result of an AddressDetector between callers,
+// a successful result is reused for ttl and concurrent callers wait on a
+// single detection instead of starting their own
+type cachedDetector struct {
+	detector AddressDetector
+	ttl      time.Duration
+
+	address    string
+	detectedAt time.Time
+	inflight   *detection
+
+	// generation is increased by invalidate, a detection started before
+	// is not cached
+	generation int
+	lock       sync.Mutex
+}
+
+func newCachedDetector(detector AddressDetector, ttl time.Duration) *cachedDetector {
+	return &cachedDetector{
+		detector: detector,
+		ttl:      ttl,
+	}
+}
+
+func (d *cachedDetector) Detect(parentCtx context.Context) (string, error) {
+	d.lock.Lock()
+	if d.address != "" && time.Since(d.detectedAt) < d.ttl {
+		address := d.address
+		d.lock.Unlock()
+		return address, nil
+	}
+
+	call := d.inflight
+	if call == nil {
+		call = &detection{done: make(chan struct{}), generation: d.generation}
+		d.inflight = call
+		go d.detect(call)
+	}
+	d.lock.Unlock()
+
+	select {
+	case <-call.done:
+		return call.address, call.err
+	case <-parentCtx.Done():
+		return "", parentCtx.Err()
+	}
+}
+
+// detect runs call on a context of its own, so a caller giving up does not
+// fail the detection every other caller waits for
+func (d *cachedDetector) detect(call *detection) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedDetectionTimeout)
+	defer cancel()
+	call.address, call.err = d.detector.Detect(ctx)
+
+	d.lock.Lock()
+	if d.inflight == call {
+		d.inflight = nil
+	}
+	if call.err == nil && call.generation == d.generation {
+		d.address = call.address
+		d.detectedAt = time.Now()
+	}
+	d.lock.Unlock()
+	close(call.done)
+}
+
+// invalidate drops the cached address, callers arriving afterwards start a
+// new detection instead of waiting for one already in flight
+func (d *cachedDetector) invalidate() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.address = ""
+	d.inflight = nil
+	d.generation++
+}
+
+// pooledDetector is a shared detector and the spec it was created from
+type pooledDetector struct {
+	spec     config.AddressDetectionSpec
+	detector *cachedDetector
+}
+
+// DetectorPool hands out address detectors shared by every instance using the
+// same detection spec and stack
+type DetectorPool struct {
+	detectors map[string]*pooledDetector
+	logger    *slog.Logger
+	lock      sync.Mutex
+}
+
+func NewDetectorPool(logger *slog.Logger) *DetectorPool {
+	return &DetectorPool{
+		detectors: make(map[string]*pooledDetector),
+		logger:    logger,
+	}
+}
+
+// DetectorKey identifies the shared detector of spec and stack in a DetectorPool
+func DetectorKey(spec *config.AddressDetectionSpec, stack config.NetworkStack) string {
+	return spec.PoolKey() + "/" + string(stack)
+}
+
+// Get returns the shared detector of spec and stack, a detector is created
+// again when the spec changed since it was last requested
+func (p *DetectorPool) Get(spec *config.AddressDetectionSpec, stack config.NetworkStack) AddressDetector {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := DetectorKey(spec, stack)
+	if pooled, ok := p.detectors[key]; ok && reflect.DeepEqual(pooled.spec, *spec) {
+		return pooled.detector
+	}
+
+	logger := p.logger.With(slog.Group("component", "type", "detection", "name", spec.Name), "stack", string(stack))
+	var detector AddressDetector
+	switch spec.GetDetectionType() {
+	case config.AddressDetectionIface:
+		detector = NewIfaceAddressDetector(spec, stack, logger)
+	case config.AddressDetectionThirdParty:
+		detector = NewThirdPartyAddressDetector(spec, stack, logger)
+	}
+
+	pooled := &pooledDetector{
+		spec:     *spec,
+		detector: newCachedDetector(detector, spec.GetCacheTTL()),
+	}
+	p.detectors[key] = pooled
+	return pooled.detector
+}
+
+// Invalidate drops the cached address of every detector reading the address
+// of interfaceName, so a change of the interface is seen by the next detection
+func (p *DetectorPool) Invalidate(interfaceName string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, pooled := range p.detectors {
+		if pooled.spec.Interface != nil && pooled.spec.Interface.Name == interfaceName {
+			pooled.detector.invalidate()
+		}
+	}
+}
+
+// Retain drops the detector of every key not in keys, keys are built by
+// DetectorKey, so detectors no longer used by any instance are released
+func (p *DetectorPool) Retain(keys map[string]bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key := range p.detectors {
+		if !keys[key] {
+			delete(p.detectors, key)
+		}
+	}
+}
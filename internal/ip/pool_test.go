@@ -0,0 +1,191 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// blockingDetector detects its address once release is closed
+type blockingDetector struct {
+	address string
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (d *blockingDetector) Detect(ctx context.Context) (string, error) {
+	d.calls.Add(1)
+	select {
+	case <-d.release:
+		return d.address, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestCachedDetectorTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector *staticDetector
+		age      time.Duration
+		want     int32
+	}{
+		{name: "address within ttl is reused", detector: detected("203.0.113.1"), age: 59 * time.Minute, want: 1},
+		{name: "expired address is detected again", detector: detected("203.0.113.1"), age: time.Hour, want: 2},
+		{name: "failure is not cached", detector: failed("timeout"), want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached := newCachedDetector(tt.detector, time.Hour)
+			for i := 0; i < 2; i++ {
+				address, err := cached.Detect(context.Background())
+				if address != tt.detector.address || err != tt.detector.err {
+					t.Fatalf("expected %q, %v, got %q, %v", tt.detector.address, tt.detector.err, address, err)
+				}
+				cached.lock.Lock()
+				cached.detectedAt = cached.detectedAt.Add(-tt.age)
+				cached.lock.Unlock()
+			}
+			if calls := tt.detector.calls.Load(); calls != tt.want {
+				t.Errorf("expected %d detections, got %d", tt.want, calls)
+			}
+		})
+	}
+}
+
+func TestConcurrentCallersShareDetection(t *testing.T) {
+	release := make(chan struct{})
+	blocking := &blockingDetector{address: "203.0.113.1", release: release}
+	cached := newCachedDetector(blocking, time.Hour)
+
+	// The caller starting the detection gives up before it finishes
+	canceled, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cached.Detect(canceled)
+		first <- err
+	}()
+	for blocking.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	addresses := make([]string, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addresses[i], errs[i] = cached.Detect(context.Background())
+		}()
+	}
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled caller to return %v, got %v", context.Canceled, err)
+	}
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if addresses[i] != "203.0.113.1" || errs[i] != nil {
+			t.Errorf("caller %d: expected 203.0.113.1, got %q, %v", i, addresses[i], errs[i])
+		}
+	}
+	if calls := blocking.calls.Load(); calls != 1 {
+		t.Errorf("expected a single detection, got %d", calls)
+	}
+}
+
+func TestDetectorPoolInvalidate(t *testing.T) {
+	pool := NewDetectorPool(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ttl := config.Duration(time.Hour)
+	specs := map[string]*config.AddressDetectionSpec{
+		"eth0": {Name: "eth0", Interface: &config.NetworkInterfaceDetectionSpec{Name: "eth0"}, CacheTTL: &ttl},
+		"ppp0": {Name: "ppp0", Interface: &config.NetworkInterfaceDetectionSpec{Name: "ppp0"}, CacheTTL: &ttl},
+		"api":  {Name: "api", API: &config.ThirdPartyServiceSpec{URL: "https://api.example.com"}, CacheTTL: &ttl},
+	}
+
+	detectors := make(map[string]*staticDetector)
+	for name, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		cached := pool.Get(spec, config.IPv4).(*cachedDetector)
+		detectors[name] = detected("203.0.113.1")
+		cached.detector = detectors[name]
+	}
+
+	detectAll := func() {
+		for name, spec := range specs {
+			if _, err := pool.Get(spec, config.IPv4).Detect(context.Background()); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}
+	detectAll()
+	pool.Invalidate("ppp0")
+	detectAll()
+
+	// Only the detector of the changed interface skipped its cache
+	want := map[string]int32{"eth0": 1, "ppp0": 2, "api": 1}
+	for name, detector := range detectors {
+		if calls := detector.calls.Load(); calls != want[name] {
+			t.Errorf("%s: expected %d detections, got %d", name, want[name], calls)
+		}
+	}
+}
+
+func TestInvalidateDropsDetectionInFlight(t *testing.T) {
+	release := make(chan struct{})
+	blocking := &blockingDetector{address: "203.0.113.1", release: release}
+	cached := newCachedDetector(blocking, time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cached.Detect(context.Background())
+	}()
+	for blocking.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The address detected while the interface changed is not cached
+	cached.invalidate()
+	close(release)
+	<-done
+
+	blocking.address = "203.0.113.2"
+	address, err := cached.Detect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "203.0.113.2" || blocking.calls.Load() != 2 {
+		t.Errorf("expected a new detection of 203.0.113.2, got %s after %d detections", address, blocking.calls.Load())
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/itchyny/gojq"
 	"github.com/masteryyh/micro-ddns/internal/config"
+	"github.com/masteryyh/micro-ddns/internal/retry"
 	"github.com/masteryyh/micro-ddns/pkg/utils"
 )
 
@@ -43,9 +45,29 @@ type ThirdPartyAddressDetector struct {
 	localAddressPolicy config.LocalAddressPolicy
 	stack              config.NetworkStack
 
+	client *http.Client
 	logger *slog.Logger
 }
 
+// newStackClient returns an HTTP client that only connects over the address
+// family of stack, so a dual-stack API reports the address we asked for
+func newStackClient(stack config.NetworkStack) *http.Client {
+	network := "tcp4"
+	if stack == config.IPv6 {
+		network = "tcp6"
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Transport: transport}
+}
+
 func NewThirdPartyAddressDetector(detectionSpec *config.AddressDetectionSpec, stack config.NetworkStack, logger *slog.Logger) *ThirdPartyAddressDetector {
 	spec := detectionSpec.API
 
@@ -64,12 +86,13 @@ func NewThirdPartyAddressDetector(detectionSpec *config.AddressDetectionSpec, st
 		password:           utils.StringPtrToString(spec.Password),
 		localAddressPolicy: policy,
 		stack:              stack,
+		client:             newStackClient(stack),
 		logger:             logger,
 	}
 }
 
 func (d *ThirdPartyAddressDetector) requestAddress(parentCtx context.Context) (string, error) {
-	client := http.DefaultClient
+	client := d.client
 	params := url.Values{}
 	for k, v := range d.params {
 		params.Set(k, v)
@@ -97,6 +120,14 @@ func (d *ThirdPartyAddressDetector) requestAddress(parentCtx context.Context) (s
 		return "", err
 	}
 
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		err := fmt.Errorf("API responded with status %s", res.Status)
+		if retry.IsRetryableStatus(res.StatusCode) {
+			return "", err
+		}
+		return "", retry.Permanent(err)
+	}
+
 	header := res.Header.Get("Content-Type")
 	if header == "" {
 		header = res.Header.Get("content-type")
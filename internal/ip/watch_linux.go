@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WatchAddressChanges subscribes to rtnetlink address and link notifications and sends
+// on the returned channel whenever an address of interfaceName is added or
+// removed. Changes arriving faster than they are received are coalesced, the
+// channel is closed when ctx is done or the socket fails.
+func WatchAddressChanges(ctx context.Context, interfaceName string, logger *slog.Logger) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+
+	// Groups is a bitmask of the multicast groups, group n is bit n-1, link
+	// notifications tell when the interface is created again with a new index
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1<<(syscall.RTNLGRP_LINK-1) | 1<<(syscall.RTNLGRP_IPV4_IFADDR-1) | 1<<(syscall.RTNLGRP_IPV6_IFADDR-1),
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to subscribe to address notifications: %w", err)
+	}
+
+	// Wake up regularly so the socket is closed soon after ctx is done
+	timeout := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	matcher := newInterfaceMatcher(interfaceName)
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 64*1024)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+					continue
+				}
+
+				// The kernel dropped notifications, one of them might be ours
+				if errors.Is(err, syscall.ENOBUFS) {
+					notify(changes)
+					continue
+				}
+
+				logger.Error("failed to read address notifications, stop watching", "interface", interfaceName, "err", err)
+				return
+			}
+
+			messages, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				logger.Debug("ignoring malformed netlink message", "err", err)
+				continue
+			}
+
+			for _, message := range messages {
+				if matcher.matches(&message) {
+					logger.Debug("address of interface changed", "interface", interfaceName, "type", message.Header.Type)
+					notify(changes)
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// interfaceMatcher matches address changes by interface index, the index is
+// looked up once and then follows link notifications, as an interface created
+// again, e.g. a PPPoE link reconnecting, gets a new one
+type interfaceMatcher struct {
+	name  string
+	index int
+}
+
+func newInterfaceMatcher(name string) *interfaceMatcher {
+	m := &interfaceMatcher{name: name}
+	if iface, err := net.InterfaceByName(name); err == nil {
+		m.index = iface.Index
+	}
+	return m
+}
+
+// linkChanged takes the index of a link named like the interface, the last
+// known index is kept once the interface is gone so the removal of its
+// addresses still matches
+func (m *interfaceMatcher) linkChanged(message *syscall.NetlinkMessage) {
+	if len(message.Data) < syscall.SizeofIfInfomsg {
+		return
+	}
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(message)
+	if err != nil {
+		return
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type == syscall.IFLA_IFNAME && strings.TrimRight(string(attr.Value), "\x00") == m.name {
+			// ifi_index follows the family, padding and type
+			m.index = int(binary.NativeEndian.Uint32(message.Data[4:8]))
+			return
+		}
+	}
+}
+
+// matches reports if message is an address change of the interface
+func (m *interfaceMatcher) matches(message *syscall.NetlinkMessage) bool {
+	switch message.Header.Type {
+	case syscall.RTM_NEWLINK:
+		m.linkChanged(message)
+		return false
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+	default:
+		return false
+	}
+	if len(message.Data) < syscall.SizeofIfAddrmsg {
+		return false
+	}
+
+	// ifa_index follows the family, prefix length, flags and scope bytes
+	index := int(binary.NativeEndian.Uint32(message.Data[4:8]))
+	return m.index != 0 && index == m.index
+}
+
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
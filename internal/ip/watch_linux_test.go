@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// linkMessage returns a link notification of interface name with index
+func linkMessage(messageType uint16, index int, name string) *syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(data[4:8], uint32(index))
+
+	value := append([]byte(name), 0)
+	attr := make([]byte, syscall.SizeofRtAttr, syscall.SizeofRtAttr+len(value)+3)
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(syscall.SizeofRtAttr+len(value)))
+	binary.NativeEndian.PutUint16(attr[2:4], syscall.IFLA_IFNAME)
+	attr = append(attr, value...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: messageType},
+		Data:   append(data, attr...),
+	}
+}
+
+// addressMessage returns an address notification of the interface with index
+func addressMessage(messageType uint16, index int) *syscall.NetlinkMessage {
+	data := make([]byte, syscall.SizeofIfAddrmsg)
+	binary.NativeEndian.PutUint32(data[4:8], uint32(index))
+	return &syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: messageType},
+		Data:   data,
+	}
+}
+
+func TestInterfaceMatcher(t *testing.T) {
+	// The interface does not exist yet, like a PPPoE link before it connects
+	matcher := newInterfaceMatcher("ddns-test0")
+
+	steps := []struct {
+		name    string
+		message *syscall.NetlinkMessage
+		want    bool
+	}{
+		{name: "unknown interface", message: addressMessage(syscall.RTM_NEWADDR, 7)},
+		{name: "link created", message: linkMessage(syscall.RTM_NEWLINK, 7, "ddns-test0")},
+		{name: "address added", message: addressMessage(syscall.RTM_NEWADDR, 7), want: true},
+		{name: "address of another interface", message: addressMessage(syscall.RTM_NEWADDR, 8)},
+		{name: "another link created", message: linkMessage(syscall.RTM_NEWLINK, 8, "ddns-test01")},
+		{name: "address still matched", message: addressMessage(syscall.RTM_NEWADDR, 7), want: true},
+		{name: "link removed", message: linkMessage(syscall.RTM_DELLINK, 7, "ddns-test0")},
+		{name: "address removed after the link", message: addressMessage(syscall.RTM_DELADDR, 7), want: true},
+		{name: "link created again", message: linkMessage(syscall.RTM_NEWLINK, 9, "ddns-test0")},
+		{name: "address of the previous link", message: addressMessage(syscall.RTM_NEWADDR, 7)},
+		{name: "address of the new link", message: addressMessage(syscall.RTM_NEWADDR, 9), want: true},
+		{name: "route change", message: &syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWROUTE}}},
+		{name: "truncated address message", message: &syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR}, Data: []byte{1, 2}}},
+	}
+
+	for _, step := range steps {
+		if got := matcher.matches(step.message); got != step.want {
+			t.Errorf("%s: expected match %v, got %v", step.name, step.want, got)
+		}
+	}
+}
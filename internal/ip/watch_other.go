@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ip
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WatchAddressChanges relies on rtnetlink, which only exists on Linux
+func WatchAddressChanges(_ context.Context, _ string, _ *slog.Logger) (<-chan struct{}, error) {
+	return nil, ErrWatchNotSupported
+}
@@ -18,15 +18,23 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
 
+// StatusFunc returns the status document of the application and a
+// description of every problem found, no problem means healthy
+type StatusFunc func() (status interface{}, problems []string)
+
 type MetricsServer struct {
+	mux    *http.ServeMux
 	server *http.Server
 	logger *slog.Logger
 	wg     *sync.WaitGroup
@@ -44,12 +52,49 @@ func NewMetricsServer(logger *slog.Logger, wg *sync.WaitGroup) *MetricsServer {
 
 	wg.Add(1)
 	return &MetricsServer{
+		mux:    mux,
 		server: server,
 		logger: logger,
 		wg:     wg,
 	}
 }
 
+// HandleStatus serves the status document at /status, and /healthz responds
+// 503 with the problems found as long as there is any
+func (s *MetricsServer) HandleStatus(status StatusFunc) {
+	s.mux.HandleFunc("/healthz", func(response http.ResponseWriter, request *http.Request) {
+		_, problems := status()
+		if len(problems) > 0 {
+			response.WriteHeader(http.StatusServiceUnavailable)
+			response.Write([]byte(strings.Join(problems, "\n") + "\n"))
+			return
+		}
+		response.Write([]byte("ok\n"))
+	})
+
+	s.HandleJSON("/status", func(url.Values) (interface{}, error) {
+		document, _ := status()
+		return document, nil
+	})
+}
+
+// HandleJSON serves the document returned by fn for the query parameters of
+// a request at pattern, an error is reported as a bad request
+func (s *MetricsServer) HandleJSON(pattern string, fn func(query url.Values) (interface{}, error)) {
+	s.mux.HandleFunc(pattern, func(response http.ResponseWriter, request *http.Request) {
+		document, err := fn(request.URL.Query())
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(response).Encode(document); err != nil {
+			s.logger.Error("failed to write response", "path", pattern, "err", err)
+		}
+	})
+}
+
 func (s *MetricsServer) Serve(parentCtx context.Context) {
 	s.logger.Info("starting metrics server")
 	go func() {
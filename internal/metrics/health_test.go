@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/history"
+)
+
+func TestHistoryEndpoint(t *testing.T) {
+	recorder, err := history.Open(history.DefaultSize, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	for i, name := range []string{"home", "office", "home", "home"} {
+		if err := recorder.Add(history.Entry{Time: start.Add(time.Duration(i) * time.Minute), Name: name, Action: "none"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	server := NewMetricsServer(slog.New(slog.NewTextHandler(io.Discard, nil)), &wg)
+	server.HandleJSON("/history", recorder.Lookup)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantTimes  []time.Time
+		wantBody   string
+	}{
+		{
+			name:       "every entry",
+			target:     "/history",
+			wantStatus: http.StatusOK,
+			wantTimes:  []time.Time{start, start.Add(time.Minute), start.Add(2 * time.Minute), start.Add(3 * time.Minute)},
+		},
+		{
+			name:       "filtered",
+			target:     "/history?name=home&since=2024-01-02T08:01:00Z&limit=1",
+			wantStatus: http.StatusOK,
+			wantTimes:  []time.Time{start.Add(3 * time.Minute)},
+		},
+		{
+			name:       "nothing selected",
+			target:     "/history?name=missing",
+			wantStatus: http.StatusOK,
+			wantTimes:  []time.Time{},
+		},
+		{
+			name:       "invalid query",
+			target:     "/history?since=yesterday",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "since must be an RFC 3339 timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			server.mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if response.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, response.Code, response.Body.String())
+			}
+			if tt.wantBody != "" {
+				if !strings.Contains(response.Body.String(), tt.wantBody) {
+					t.Errorf("expected body containing %q, got %q", tt.wantBody, response.Body.String())
+				}
+				return
+			}
+
+			if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("unexpected content type %s", contentType)
+			}
+			var entries []history.Entry
+			if err := json.Unmarshal(response.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(entries) != len(tt.wantTimes) {
+				t.Fatalf("expected %d entries, got %d", len(tt.wantTimes), len(entries))
+			}
+			for i, want := range tt.wantTimes {
+				if !entries[i].Time.Equal(want) {
+					t.Errorf("entry %d: expected time %s, got %s", i, want, entries[i].Time)
+				}
+			}
+		})
+	}
+}
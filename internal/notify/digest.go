@@ -0,0 +1,144 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// Digest collects the state of records between two daily summaries of a notifier
+type Digest struct {
+	records map[string]*Record
+	lock    sync.Mutex
+}
+
+func NewDigest() *Digest {
+	return &Digest{
+		records: make(map[string]*Record),
+	}
+}
+
+// Observe records the outcome of an update of the record with key, a failed
+// update keeps the last known address of the record
+func (d *Digest) Observe(key string, record Record) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	current, ok := d.records[key]
+	if !ok {
+		current = &Record{
+			Name: record.Name,
+			FQDN: record.FQDN,
+			Type: record.Type,
+		}
+		d.records[key] = current
+	}
+
+	if record.Error != "" {
+		current.Failures++
+		current.Error = record.Error
+		if current.NewAddress == "" {
+			current.NewAddress = record.OldAddress
+		}
+		return
+	}
+	current.NewAddress = record.NewAddress
+}
+
+// Forget drops the records of DDNS spec name
+func (d *Digest) Forget(name string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for key, record := range d.records {
+		if record.Name == name {
+			delete(d.records, key)
+		}
+	}
+}
+
+// Message returns the summary of every record observed so far and starts
+// counting failures again, it returns nil if nothing was observed
+func (d *Digest) Message(name string, now time.Time) *Message {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(d.records) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(d.records))
+	for key := range d.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		record := d.records[key]
+		records = append(records, *record)
+		record.Failures = 0
+		record.Error = ""
+	}
+
+	return &Message{
+		Event:   config.NotifyDigest,
+		Name:    name,
+		Records: records,
+		Time:    now,
+	}
+}
+
+// DigestPool holds the digest of every notifier in digest mode, so digests
+// survive instances being rebuilt on reload
+type DigestPool struct {
+	digests map[string]*Digest
+	lock    sync.Mutex
+}
+
+func NewDigestPool() *DigestPool {
+	return &DigestPool{
+		digests: make(map[string]*Digest),
+	}
+}
+
+// Get returns the digest of the notifier with name, creating it on first use
+func (p *DigestPool) Get(name string) *Digest {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	digest, ok := p.digests[name]
+	if !ok {
+		digest = NewDigest()
+		p.digests[name] = digest
+	}
+	return digest
+}
+
+// Forget drops the records of DDNS spec name from every digest
+func (p *DigestPool) Forget(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, digest := range p.digests {
+		digest.Forget(name)
+	}
+}
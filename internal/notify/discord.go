@@ -0,0 +1,51 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// discordMaxContent is the longest message content Discord accepts
+const discordMaxContent = 2000
+
+// DiscordNotifier posts messages to a Discord channel webhook
+type DiscordNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func NewDiscordNotifier(spec *config.DiscordNotifierSpec, client *http.Client) *DiscordNotifier {
+	return &DiscordNotifier{
+		webhookURL: spec.WebhookURL,
+		client:     client,
+	}
+}
+
+func (n *DiscordNotifier) Notify(parentCtx context.Context, message *Message) error {
+	content := message.Text()
+	if len(content) > discordMaxContent {
+		content = content[:discordMaxContent-3] + "..."
+	}
+
+	return postJSON(parentCtx, n.client, n.webhookURL, nil, map[string]string{
+		"content": content,
+	})
+}
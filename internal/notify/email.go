@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// EmailNotifier sends messages as plain text mail through an SMTP server
+type EmailNotifier struct {
+	host      string
+	addr      string
+	security  config.SMTPSecurity
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	from      *mail.Address
+	to        []*mail.Address
+}
+
+func NewEmailNotifier(spec *config.EmailNotifierSpec) (*EmailNotifier, error) {
+	from, err := mail.ParseAddress(spec.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]*mail.Address, 0, len(spec.To))
+	for _, recipient := range spec.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, err
+		}
+		to = append(to, address)
+	}
+
+	n := &EmailNotifier{
+		host:      spec.Host,
+		addr:      net.JoinHostPort(spec.Host, strconv.Itoa(*spec.Port)),
+		security:  *spec.Security,
+		tlsConfig: &tls.Config{ServerName: spec.Host},
+		from:      from,
+		to:        to,
+	}
+	if spec.Username != nil && spec.Password != nil {
+		n.username = *spec.Username
+		n.password = *spec.Password
+	}
+	return n, nil
+}
+
+// dial connects to the server and sets up encryption, the deadline of ctx
+// bounds the whole conversation as net/smtp has no context support
+func (n *EmailNotifier) dial(ctx context.Context) (*smtp.Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if n.security == config.SMTPImplicitTLS {
+		conn = tls.Client(conn, n.tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if n.security == config.SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("server %s does not support STARTTLS", n.addr)
+		}
+		if err := client.StartTLS(n.tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (n *EmailNotifier) Notify(parentCtx context.Context, message *Message) error {
+	ctx, cancel := context.WithTimeout(parentCtx, sendTimeout)
+	defer cancel()
+
+	client, err := n.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// PlainAuth refuses to send the password over an unencrypted connection
+	// unless the server is on localhost
+	if n.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.from.Address); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to.Address, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.compose(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose returns the mail of message, the text is quoted-printable encoded
+// so addresses and errors of any length and charset survive
+func (n *EmailNotifier) compose(message *Message) []byte {
+	to := make([]string, len(n.to))
+	for i, address := range n.to {
+		to[i] = address.String()
+	}
+
+	var b bytes.Buffer
+	header := func(key string, value string) {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", n.from.String())
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Title()))
+	header("Date", message.Time.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	_, _ = w.Write([]byte(strings.ReplaceAll(message.Text(), "\n", "\r\n")))
+	_ = w.Close()
+	return b.Bytes()
+}
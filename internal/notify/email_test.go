@@ -0,0 +1,387 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// smtpMail is a mail received by smtpServer
+type smtpMail struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpServer is a minimal SMTP server accepting a single session per
+// connection, with optional STARTTLS and PLAIN authentication
+type smtpServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	mails     chan smtpMail
+}
+
+// newSMTPServer starts a server on a random local port, implicit wraps every
+// connection in TLS and startTLS offers the STARTTLS extension
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config, implicit bool, startTLS bool) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	if implicit {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	s := &smtpServer{
+		listener: listener,
+		mails:    make(chan smtpMail, 1),
+	}
+	if startTLS {
+		s.tlsConfig = tlsConfig
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicit)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn, isTLS bool) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			_ = text.PrintfLine("%s", line)
+		}
+	}
+
+	mail := smtpMail{tls: isTLS}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if s.tlsConfig != nil && !isTLS {
+				reply("250-localhost", "250-STARTTLS", "250 AUTH PLAIN")
+			} else {
+				reply("250-localhost", "250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			isTLS = true
+			mail.tls = true
+		case "AUTH":
+			_, response, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				reply("501 invalid response")
+				continue
+			}
+			mail.auth = string(decoded)
+			reply("235 authenticated")
+		case "MAIL":
+			mail.from = addressOf(arg)
+			reply("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, addressOf(arg))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = data
+			reply("250 queued")
+			s.mails <- mail
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// addressOf returns the address between angle brackets of a MAIL or RCPT argument
+func addressOf(arg string) string {
+	_, address, _ := strings.Cut(arg, "<")
+	address, _, _ = strings.Cut(address, ">")
+	return address
+}
+
+// newCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func newCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// readMail returns the decoded subject and body of a received mail
+func readMail(t *testing.T, data []byte) (string, string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+	if err != nil {
+		t.Fatalf("failed to parse mail: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("failed to decode subject: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	// The DATA terminator adds a line break after the last line
+	return subject, strings.TrimSuffix(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+}
+
+func newTestDigest() *Digest {
+	digest := NewDigest()
+	digest.Observe("home/home.example.com/A", Record{Name: "home", FQDN: "home.example.com", Type: "A", NewAddress: "203.0.113.1"})
+	digest.Observe("home/home.example.com/AAAA", Record{Name: "home", FQDN: "home.example.com", Type: "AAAA", OldAddress: "2001:db8::1", Error: "connection refused"})
+	return digest
+}
+
+func TestEmailNotifier(t *testing.T) {
+	cert, pool := newCertificate(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	changed := &Message{
+		Event: config.NotifyChanged,
+		Name:  "home",
+		Records: []Record{
+			{FQDN: "home.example.com", Type: "A", OldAddress: "203.0.113.1", NewAddress: "203.0.113.2"},
+		},
+		Time: time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name     string
+		security config.SMTPSecurity
+		implicit bool
+		startTLS bool
+		username string
+		message  *Message
+		wantTLS  bool
+		wantErr  string
+	}{
+		{
+			name:     "plain",
+			security: config.SMTPNone,
+			message:  changed,
+		},
+		{
+			name:     "plain with authentication",
+			security: config.SMTPNone,
+			username: "ddns@example.com",
+			message:  changed,
+		},
+		{
+			name:     "startTLS",
+			security: config.SMTPStartTLS,
+			startTLS: true,
+			username: "ddns@example.com",
+			message:  changed,
+			wantTLS:  true,
+		},
+		{
+			name:     "startTLS not offered",
+			security: config.SMTPStartTLS,
+			message:  changed,
+			wantErr:  "does not support STARTTLS",
+		},
+		{
+			name:     "implicitTLS",
+			security: config.SMTPImplicitTLS,
+			implicit: true,
+			message:  changed,
+			wantTLS:  true,
+		},
+		{
+			name:     "digest",
+			security: config.SMTPStartTLS,
+			startTLS: true,
+			message:  newTestDigest().Message("mail", time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)),
+			wantTLS:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, tlsConfig, tt.implicit, tt.startTLS)
+
+			port := server.port()
+			security := tt.security
+			spec := &config.EmailNotifierSpec{
+				Host:     "127.0.0.1",
+				Port:     &port,
+				Security: &security,
+				From:     "micro-ddns <ddns@example.com>",
+				To:       []string{"admin@example.com", "Ops <ops@example.com>"},
+			}
+			if tt.username != "" {
+				password := "secret"
+				spec.Username = &tt.username
+				spec.Password = &password
+			}
+			if err := spec.Validate(); err != nil {
+				t.Fatalf("invalid spec: %v", err)
+			}
+
+			notifier, err := NewEmailNotifier(spec)
+			if err != nil {
+				t.Fatalf("failed to create notifier: %v", err)
+			}
+			notifier.tlsConfig.RootCAs = pool
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err = notifier.Notify(ctx, tt.message)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to notify: %v", err)
+			}
+
+			var received smtpMail
+			select {
+			case received = <-server.mails:
+			case <-ctx.Done():
+				t.Fatal("no mail received")
+			}
+
+			if received.tls != tt.wantTLS {
+				t.Errorf("expected TLS %v, got %v", tt.wantTLS, received.tls)
+			}
+			wantAuth := ""
+			if tt.username != "" {
+				wantAuth = "\x00" + tt.username + "\x00secret"
+			}
+			if received.auth != wantAuth {
+				t.Errorf("expected authentication %q, got %q", wantAuth, received.auth)
+			}
+			if received.from != "ddns@example.com" {
+				t.Errorf("unexpected sender %s", received.from)
+			}
+			if got := strings.Join(received.to, ","); got != "admin@example.com,ops@example.com" {
+				t.Errorf("unexpected recipients %s", got)
+			}
+
+			subject, body := readMail(t, received.data)
+			if subject != tt.message.Title() {
+				t.Errorf("expected subject %q, got %q", tt.message.Title(), subject)
+			}
+			if body != tt.message.Text() {
+				t.Errorf("expected body %q, got %q", tt.message.Text(), body)
+			}
+		})
+	}
+}
+
+func TestDigestMessage(t *testing.T) {
+	digest := newTestDigest()
+	now := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	want := "micro-ddns: daily digest of 2 record(s), 1 failed update(s)\n" +
+		"home: home.example.com A: 203.0.113.1\n" +
+		"home: home.example.com AAAA: 2001:db8::1 (1 failed update(s), last error: connection refused)"
+	if got := digest.Message("mail", now).Text(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// Failures are counted again from the next digest on
+	want = "micro-ddns: daily digest of 2 record(s), 0 failed update(s)\n" +
+		"home: home.example.com A: 203.0.113.1\n" +
+		"home: home.example.com AAAA: 2001:db8::1"
+	if got := digest.Message("mail", now).Text(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// sendTimeout bounds a single notification so a slow service never blocks updates
+const sendTimeout = 30 * time.Second
+
+// Record is a DNS record a notification is about
+type Record struct {
+	// Name is the name of the DDNS spec managing the record, as digests
+	// cover records of many specs
+	Name string `json:"name,omitempty"`
+
+	FQDN       string `json:"fqdn"`
+	Type       string `json:"type"`
+	OldAddress string `json:"oldAddress,omitempty"`
+	NewAddress string `json:"newAddress,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	// Failures is the number of failed updates since the previous digest
+	Failures int `json:"failures,omitempty"`
+}
+
+// Message is a single notification about records of a DDNS spec
+type Message struct {
+	Event config.NotificationEvent `json:"event"`
+
+	// Name is the name of the DDNS spec the records belong to, or the name
+	// of the notifier for digests
+	Name    string    `json:"name"`
+	Records []Record  `json:"records"`
+	Time    time.Time `json:"time"`
+}
+
+// Title returns a one line summary of the message
+func (m *Message) Title() string {
+	switch m.Event {
+	case config.NotifyChanged:
+		return fmt.Sprintf("micro-ddns: %s updated %d record(s)", m.Name, len(m.Records))
+	case config.NotifyFailed:
+		return fmt.Sprintf("micro-ddns: %s failed to update %d record(s)", m.Name, len(m.Records))
+	case config.NotifyRecovered:
+		return fmt.Sprintf("micro-ddns: %s recovered %d record(s)", m.Name, len(m.Records))
+	case config.NotifyDigest:
+		failures := 0
+		for _, r := range m.Records {
+			failures += r.Failures
+		}
+		return fmt.Sprintf("micro-ddns: daily digest of %d record(s), %d failed update(s)", len(m.Records), failures)
+	}
+	return "micro-ddns: " + m.Name
+}
+
+// Text returns the message as plain text, the title followed by a line per record
+func (m *Message) Text() string {
+	var b strings.Builder
+	b.WriteString(m.Title())
+	for _, r := range m.Records {
+		b.WriteString("\n")
+		if m.Event == config.NotifyDigest {
+			b.WriteString(r.digestLine())
+			continue
+		}
+
+		b.WriteString(r.FQDN + " " + r.Type)
+		switch {
+		case r.Error != "":
+			b.WriteString(": " + r.Error)
+		case r.OldAddress != "" && r.OldAddress != r.NewAddress:
+			b.WriteString(": " + r.OldAddress + " -> " + r.NewAddress)
+		case r.NewAddress != "":
+			b.WriteString(": " + r.NewAddress)
+		}
+	}
+	return b.String()
+}
+
+// digestLine describes a record in a digest, its current address followed
+// by the failures since the previous digest
+func (r *Record) digestLine() string {
+	line := r.Name + ": " + r.FQDN + " " + r.Type + ": "
+	if r.NewAddress != "" {
+		line += r.NewAddress
+	} else {
+		line += "no address"
+	}
+
+	if r.Failures > 0 {
+		line += fmt.Sprintf(" (%d failed update(s), last error: %s)", r.Failures, r.Error)
+	}
+	return line
+}
+
+// Notifier sends messages to a chat service, webhook or mailbox
+type Notifier interface {
+	Notify(parentCtx context.Context, message *Message) error
+}
+
+// New creates the notifier of a validated notifier spec
+func New(spec *config.NotifierSpec) (Notifier, error) {
+	client := &http.Client{}
+	switch spec.GetType() {
+	case config.NotifierSlack:
+		return NewSlackNotifier(spec.Slack, client), nil
+	case config.NotifierDiscord:
+		return NewDiscordNotifier(spec.Discord, client), nil
+	case config.NotifierTelegram:
+		return NewTelegramNotifier(spec.Telegram, client), nil
+	case config.NotifierWebhook:
+		return NewWebhookNotifier(spec.Webhook, client), nil
+	case config.NotifierEmail:
+		return NewEmailNotifier(spec.Email)
+	}
+	return nil, fmt.Errorf("unknown notifier type %s", spec.GetType())
+}
+
+// postJSON sends payload as JSON to url and checks the response status
+func postJSON(parentCtx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		// Webhook URLs carry credentials, keep them out of the logs
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("responded with status %s: %s", res.Status, strings.TrimSpace(string(detail)))
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,43 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// SlackNotifier posts messages to a Slack incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func NewSlackNotifier(spec *config.SlackNotifierSpec, client *http.Client) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: spec.WebhookURL,
+		client:     client,
+	}
+}
+
+func (n *SlackNotifier) Notify(parentCtx context.Context, message *Message) error {
+	return postJSON(parentCtx, n.client, n.webhookURL, nil, map[string]string{
+		"text": message.Text(),
+	})
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+const TelegramDefaultAPIURL = "https://api.telegram.org"
+
+// TelegramNotifier sends messages to a chat with the Telegram bot API
+type TelegramNotifier struct {
+	url    string
+	chatID string
+	client *http.Client
+}
+
+func NewTelegramNotifier(spec *config.TelegramNotifierSpec, client *http.Client) *TelegramNotifier {
+	apiURL := TelegramDefaultAPIURL
+	if spec.APIURL != nil {
+		apiURL = strings.TrimSuffix(*spec.APIURL, "/")
+	}
+
+	return &TelegramNotifier{
+		url:    apiURL + "/bot" + spec.BotToken + "/sendMessage",
+		chatID: string(spec.ChatID),
+		client: client,
+	}
+}
+
+func (n *TelegramNotifier) Notify(parentCtx context.Context, message *Message) error {
+	return postJSON(parentCtx, n.client, n.url, nil, map[string]string{
+		"chat_id": n.chatID,
+		"text":    message.Text(),
+	})
+}
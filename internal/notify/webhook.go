@@ -0,0 +1,53 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// WebhookNotifier posts every message as JSON to a URL
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhookNotifier(spec *config.WebhookNotifierSpec, client *http.Client) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:     spec.URL,
+		headers: spec.Headers,
+		client:  client,
+	}
+}
+
+// webhookPayload is the message with its text, so simple receivers don't
+// have to format the records themselves
+type webhookPayload struct {
+	*Message
+	Text string `json:"text"`
+}
+
+func (n *WebhookNotifier) Notify(parentCtx context.Context, message *Message) error {
+	return postJSON(parentCtx, n.client, n.url, n.headers, &webhookPayload{
+		Message: message,
+		Text:    message.Text(),
+	})
+}
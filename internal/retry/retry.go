@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/masteryyh/micro-ddns/internal/config"
+)
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not retryable, e.g. a rejected credential or a
+// malformed request
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports if err was marked by Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// IsRetryableStatus reports if an HTTP status code indicates a transient
+// failure, i.e. a server error, a timeout or rate limiting
+func IsRetryableStatus(code int) bool {
+	return code >= 500 || code == 408 || code == 429
+}
+
+// Policy retries a failed operation with exponential backoff and jitter
+type Policy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+}
+
+// NewPolicy creates a Policy from a validated RetrySpec
+func NewPolicy(spec *config.RetrySpec) *Policy {
+	if spec == nil {
+		spec = config.NewDefaultRetrySpec()
+	}
+	return &Policy{
+		maxAttempts:    *spec.MaxAttempts,
+		initialBackoff: spec.InitialBackoff.Duration(),
+		maxBackoff:     spec.MaxBackoff.Duration(),
+		jitter:         *spec.Jitter,
+	}
+}
+
+// backoff returns the wait after the given failed attempt, starting from 1
+func (p *Policy) backoff(attempt int) time.Duration {
+	wait := p.initialBackoff
+	for i := 1; i < attempt && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, p.maxBackoff)
+
+	if p.jitter > 0 {
+		delta := float64(wait) * p.jitter
+		wait += time.Duration(delta * (2*rand.Float64() - 1))
+	}
+	return wait
+}
+
+// Do runs fn until it succeeds, returns a permanent error or the attempts are
+// used up, isPermanent can classify errors not marked by Permanent and may be nil.
+// Waiting between attempts stops as soon as ctx is done.
+func (p *Policy) Do(ctx context.Context, logger *slog.Logger, isPermanent func(error) bool, fn func(context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil || IsPermanent(err) || (isPermanent != nil && isPermanent(err)) {
+			return err
+		}
+
+		if attempt >= p.maxAttempts {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		logger.Warn("attempt failed, retrying", "attempt", attempt, "maxAttempts", p.maxAttempts, "wait", wait.String(), "err", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("connection reset")
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errTransient, want: false},
+		{name: "permanent", err: Permanent(errTransient), want: true},
+		{name: "wrapped permanent", err: fmt.Errorf("update failed: %w", Permanent(errTransient)), want: true},
+		{name: "joined permanent", err: errors.Join(errTransient, Permanent(errTransient)), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if Permanent(nil) != nil {
+		t.Error("expected Permanent(nil) to be nil")
+	}
+	if !errors.Is(Permanent(errTransient), errTransient) {
+		t.Error("expected Permanent to wrap the original error")
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{200, false},
+		{400, false},
+		{401, false},
+		{403, false},
+		{404, false},
+		{408, true},
+		{429, true},
+		{500, true},
+		{502, true},
+		{503, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryableStatus(tt.code); got != tt.want {
+			t.Errorf("status %d: expected %v, got %v", tt.code, tt.want, got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &Policy{maxAttempts: 10, initialBackoff: time.Second, maxBackoff: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: expected %s, got %s", tt.attempt, tt.want, got)
+		}
+	}
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(3); got < 2*time.Second || got > 6*time.Second {
+			t.Fatalf("expected a backoff between 2s and 6s, got %s", got)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		failures     int
+		err          error
+		isPermanent  func(error) bool
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", maxAttempts: 3, wantAttempts: 1},
+		{name: "success after retries", maxAttempts: 3, failures: 2, err: errTransient, wantAttempts: 3},
+		{name: "attempts used up", maxAttempts: 3, failures: 5, err: errTransient, wantAttempts: 3, wantErr: true},
+		{name: "retrying disabled", maxAttempts: 1, failures: 5, err: errTransient, wantAttempts: 1, wantErr: true},
+		{name: "permanent error", maxAttempts: 3, failures: 5, err: Permanent(errTransient), wantAttempts: 1, wantErr: true},
+		{
+			name:         "classified as permanent",
+			maxAttempts:  3,
+			failures:     5,
+			err:          errTransient,
+			isPermanent:  func(err error) bool { return errors.Is(err, errTransient) },
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{maxAttempts: tt.maxAttempts, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+			attempts := 0
+			err := p.Do(context.Background(), logger, tt.isPermanent, func(context.Context) error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p := &Policy{maxAttempts: 5, initialBackoff: time.Hour, maxBackoff: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	err := p.Do(ctx, logger, nil, func(context.Context) error {
+		attempts++
+		return errTransient
+	})
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+	if !errors.Is(err, errTransient) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the last error and the context error, got %v", err)
+	}
+}
@@ -42,3 +42,13 @@ func SetupContext() (context.Context, context.CancelFunc) {
 
 	return ctx, cancel
 }
+
+// SetupReloadChannel will receive SIGHUP for config reloading, the returned
+// channel never fires on platforms without reload signals
+func SetupReloadChannel() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(c, reloadSignals...)
+	}
+	return c
+}
@@ -25,3 +25,5 @@ import (
 )
 
 var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -22,3 +22,6 @@ import (
 )
 
 var shutdownSignals = []os.Signal{syscall.SIGINT}
+
+// There is no SIGHUP on Windows, config reload relies on file watching only
+var reloadSignals []os.Signal
@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RecordState is what we know about a DNS record from previous runs
+type RecordState struct {
+	// Address is the last address published to the record
+	Address string `json:"address,omitempty"`
+
+	// IDs are provider specific identifiers of the record and its zone
+	IDs map[string]string `json:"ids,omitempty"`
+
+	// LastSuccess is the last time the provider confirmed the record points to Address
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+
+	// Owned is set once micro-ddns created or updated the record, records
+	// that already pointed to the right address are never owned
+	Owned bool `json:"owned,omitempty"`
+}
+
+// Store keeps RecordState of every record in a JSON file, so they survive
+// restarts. A Store without path only keeps state in memory.
+type Store struct {
+	path    string
+	records map[string]RecordState
+	lock    sync.Mutex
+}
+
+// Open loads the state file at path, a missing file is treated as empty
+// state and created on first write, use an empty path to disable persistence
+func Open(path string) (*Store, error) {
+	store := &Store{
+		path:    path,
+		records: make(map[string]RecordState),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	if len(content) == 0 {
+		return store, nil
+	}
+
+	if err := json.Unmarshal(content, &store.records); err != nil {
+		return nil, fmt.Errorf("state file %s is corrupted: %w", path, err)
+	}
+	return store, nil
+}
+
+// Key returns the key of a record managed by DDNS spec name through the
+// provider with the given pool key, so state of a record moved to another
+// provider is never handed to the new one
+func Key(name string, provider string, fqdn string, recordType string) string {
+	return name + "/" + provider + "/" + fqdn + "/" + recordType
+}
+
+// Get returns the state of key, or an empty state if nothing is known yet
+func (s *Store) Get(key string) RecordState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.records[key]
+}
+
+// Put saves the state of key and writes the state file
+func (s *Store) Put(key string, state RecordState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records[key] = state
+	return s.save()
+}
+
+// PutAll saves the state of several keys with a single write of the state file
+func (s *Store) PutAll(states map[string]RecordState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, state := range states {
+		s.records[key] = state
+	}
+	return s.save()
+}
+
+// Delete forgets the state of key and writes the state file
+func (s *Store) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.records[key]; !ok {
+		return nil
+	}
+	delete(s.records, key)
+	return s.save()
+}
+
+// save writes the state file atomically, so a crash never leaves it half written
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright © 2024 masteryyh <yyh991013@163.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		content *string
+		wantErr string
+		want    map[string]RecordState
+	}{
+		{
+			name: "missing file",
+			want: map[string]RecordState{},
+		},
+		{
+			name:    "empty file",
+			content: ptr(""),
+			want:    map[string]RecordState{},
+		},
+		{
+			name:    "corrupt file",
+			content: ptr(`{"home/ref:dns/home.example.com/A": {"address": `),
+			wantErr: "is corrupted",
+		},
+		{
+			name:    "saved state",
+			content: ptr(`{"home/ref:dns/home.example.com/A": {"address": "203.0.113.1", "ids": {"zone": "z1"}, "owned": true}}`),
+			want: map[string]RecordState{
+				"home/ref:dns/home.example.com/A": {Address: "203.0.113.1", IDs: map[string]string{"zone": "z1"}, Owned: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.content != nil {
+				if err := os.WriteFile(path, []byte(*tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			store, err := Open(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(store.records, tt.want) {
+				t.Errorf("expected records %v, got %v", tt.want, store.records)
+			}
+		})
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := Key("home", "ref:dns", "home.example.com", "A")
+	www := Key("home", "ref:dns", "www.example.com", "A")
+	states := map[string]RecordState{
+		home: {Address: "203.0.113.1", IDs: map[string]string{"zone": "z1", "record": "r1"}, LastSuccess: start, Owned: true},
+		www:  {Address: "203.0.113.1", LastSuccess: start},
+	}
+	if err := store.PutAll(states); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(www); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(Key("home", "ref:dns", "unknown.example.com", "A")); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Get(home); !reflect.DeepEqual(got, states[home]) {
+		t.Errorf("expected %v, got %v", states[home], got)
+	}
+	if got := reopened.Get(www); !reflect.DeepEqual(got, RecordState{}) {
+		t.Errorf("expected deleted state to be empty, got %v", got)
+	}
+}
+
+func TestKeyIncludesProvider(t *testing.T) {
+	store, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inline := Key("home", "inline:home", "home.example.com", "A")
+	ref := Key("home", "ref:home", "home.example.com", "A")
+	if inline == ref {
+		t.Fatalf("keys of different providers are equal: %s", inline)
+	}
+
+	if err := store.Put(ref, RecordState{Address: "203.0.113.1", IDs: map[string]string{"record": "r1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Get(inline); !reflect.DeepEqual(got, RecordState{}) {
+		t.Errorf("state of another provider was handed out: %v", got)
+	}
+}
+
+func TestFailedSaveKeepsPreviousFile(t *testing.T) {
+	key := Key("home", "ref:dns", "home.example.com", "A")
+	tests := []struct {
+		name string
+
+		// file is the name of the state file
+		file  string
+		state RecordState
+	}{
+		{
+			name: "state cannot be encoded",
+			file: "state.json",
+			// Times past year 9999 cannot be encoded as JSON
+			state: RecordState{Address: "203.0.113.2", LastSuccess: start.AddDate(10000, 0, 0)},
+		},
+		{
+			name: "temporary file cannot be created",
+			// The name of the temporary file exceeds the file name limit
+			file:  strings.Repeat("s", 250),
+			state: RecordState{Address: "203.0.113.2", LastSuccess: start},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.file)
+			previous := []byte(`{"home/ref:dns/home.example.com/A": {"address": "203.0.113.1"}}`)
+			if err := os.WriteFile(path, previous, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(key, tt.state); err == nil {
+				t.Fatal("expected the save to fail")
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != string(previous) {
+				t.Errorf("state file changed by a failed save:\n%s", content)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the state file to be left, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestSaveReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key("home", "ref:dns", "home.example.com", "A")
+	for _, address := range []string{"203.0.113.1", "203.0.113.2"} {
+		if err := store.Put(key, RecordState{Address: address, LastSuccess: start}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Get(key).Address; got != "203.0.113.2" {
+		t.Errorf("expected the latest address, got %s", got)
+	}
+
+	// Temporary files are renamed over the state file, none is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "state.json" {
+		t.Errorf("expected only the state file, got %v", entries)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}